@@ -0,0 +1,248 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Artifact is a manifest which carries arbitrary blobs instead of filesystem layers.
+// With a subject it refers to another manifest, like a signature or SBOM refers to the image it describes.
+// https://github.com/opencontainers/image-spec/blob/main/manifest.md#guidelines-for-artifact-usage
+type Artifact struct {
+	manifest specsv1.Manifest
+	blobs    BlobStore
+}
+
+// CreateArtifact starts a new artifact of the given type. The subject is optional.
+// Until SetConfig is called the artifact uses the empty config descriptor.
+func (layout *ImageLayout) CreateArtifact(artifactType string, subject *specsv1.Descriptor) *Artifact {
+	return &Artifact{
+		blobs: layout.blobs,
+		manifest: specsv1.Manifest{
+			Versioned: specs.Versioned{
+				SchemaVersion: 2,
+			},
+			MediaType:    specsv1.MediaTypeImageManifest,
+			ArtifactType: artifactType,
+			Config:       specsv1.DescriptorEmptyJSON,
+			Layers:       make([]specsv1.Descriptor, 0),
+			Subject:      referrerSubject(subject),
+			Annotations:  make(map[string]string),
+		},
+	}
+}
+
+// Manifest returns the manifest of the artifact as it would be written by Close
+func (a *Artifact) Manifest() specsv1.Manifest {
+	return a.manifest
+}
+
+func (a *Artifact) AddAnnotation(key, value string) {
+	a.manifest.Annotations[key] = value
+}
+
+// SetConfig stores data as the config blob of the artifact
+func (a *Artifact) SetConfig(mediaType string, data []byte) error {
+	descr, err := writeBlob(a.blobs, mediaType, data)
+	if err != nil {
+		return err
+	}
+
+	a.manifest.Config = descr
+	return nil
+}
+
+// AddBlob adds the content of r as a layer of the artifact
+func (a *Artifact) AddBlob(mediaType string, r io.Reader, annotations map[string]string) (specsv1.Descriptor, error) {
+	descWr, err := NewDescriptorWriter(a.blobs, mediaType, digest.Canonical, nil)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	if _, err := io.Copy(descWr, r); err != nil {
+		_ = descWr.Abort()
+		return specsv1.Descriptor{}, err
+	}
+
+	descr, err := descWr.Close()
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	descr.Annotations = annotations
+	a.manifest.Layers = append(a.manifest.Layers, descr)
+
+	return descr, nil
+}
+
+// AddJSON adds data encoded as JSON as a layer of the artifact
+func (a *Artifact) AddJSON(mediaType string, data interface{}, annotations map[string]string) (specsv1.Descriptor, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	return a.AddBlob(mediaType, bytes.NewReader(encoded), annotations)
+}
+
+// Close writes the manifest of the artifact and returns its descriptor
+func (a *Artifact) Close() (specsv1.Descriptor, error) {
+	if a.manifest.Config.Digest == specsv1.DescriptorEmptyJSON.Digest {
+		if _, err := writeBlob(a.blobs, specsv1.MediaTypeEmptyJSON, specsv1.DescriptorEmptyJSON.Data); err != nil {
+			return specsv1.Descriptor{}, err
+		}
+	}
+
+	// An artifact needs at least one layer, the empty descriptor stands in if there is no content
+	// https://github.com/opencontainers/image-spec/blob/main/manifest.md#guidance-for-an-empty-descriptor
+	if len(a.manifest.Layers) == 0 {
+		a.manifest.Layers = append(a.manifest.Layers, specsv1.DescriptorEmptyJSON)
+	}
+
+	if len(a.manifest.Annotations) == 0 {
+		a.manifest.Annotations = nil
+	}
+
+	data, err := json.Marshal(a.manifest)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	descr, err := writeBlob(a.blobs, specsv1.MediaTypeImageManifest, data)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	descr.ArtifactType = a.manifest.ArtifactType
+	descr.Annotations = a.manifest.Annotations
+
+	return descr, nil
+}
+
+// SaveArtifact writes the artifact and adds it to the index of the layout so it is found by Referrers
+func (layout *ImageLayout) SaveArtifact(a *Artifact) (specsv1.Descriptor, error) {
+	descr, err := a.Close()
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	layout.AddManifest(descr)
+
+	return descr, nil
+}
+
+// Referrers returns the descriptors of all manifests in the index of the layout whose subject is the manifest
+// with the given digest. If artifactType is not empty only referrers of that type are returned.
+// The descriptors carry artifactType and annotations like the referrers API of a registry.
+func (layout *ImageLayout) Referrers(subject digest.Digest, artifactType string) ([]specsv1.Descriptor, error) {
+	referrers := make([]specsv1.Descriptor, 0)
+	for _, descr := range layout.index.Manifests {
+		if descr.MediaType != "" && descr.MediaType != specsv1.MediaTypeImageManifest && descr.MediaType != specsv1.MediaTypeImageIndex {
+			continue
+		}
+
+		manifest, err := layout.readReferrerFields(descr.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if manifest.Subject == nil || manifest.Subject.Digest != subject {
+			continue
+		}
+
+		referrer := specsv1.Descriptor{
+			MediaType:    descr.MediaType,
+			Digest:       descr.Digest,
+			Size:         descr.Size,
+			ArtifactType: manifest.ArtifactType,
+			Annotations:  manifest.Annotations,
+		}
+		if referrer.MediaType == "" {
+			referrer.MediaType = manifest.MediaType
+		}
+		if referrer.ArtifactType == "" && manifest.Config != nil {
+			referrer.ArtifactType = manifest.Config.MediaType
+		}
+
+		if artifactType != "" && referrer.ArtifactType != artifactType {
+			continue
+		}
+
+		referrers = append(referrers, referrer)
+	}
+
+	return referrers, nil
+}
+
+// referrerFields are the fields manifests and indexes share which are needed to answer a referrers query
+type referrerFields struct {
+	MediaType    string              `json:"mediaType,omitempty"`
+	ArtifactType string              `json:"artifactType,omitempty"`
+	Config       *specsv1.Descriptor `json:"config,omitempty"`
+	Subject      *specsv1.Descriptor `json:"subject,omitempty"`
+	Annotations  map[string]string   `json:"annotations,omitempty"`
+}
+
+func (layout *ImageLayout) readReferrerFields(d digest.Digest) (referrerFields, error) {
+	var fields referrerFields
+	rd, err := layout.OpenBlob(d)
+	if err != nil {
+		return fields, err
+	}
+	defer rd.Close()
+
+	if err := rd.Decode(&fields); err != nil {
+		return fields, fmt.Errorf("manifest %s cannot be read: %w", d, err)
+	}
+
+	return fields, nil
+}
+
+// SetSubject makes the image refer to another manifest, for example an image it was derived from
+func (img *Image) SetSubject(subject specsv1.Descriptor) {
+	img.manifest.Subject = referrerSubject(&subject)
+}
+
+// referrerSubject strips everything from a descriptor a subject does not need
+func referrerSubject(subject *specsv1.Descriptor) *specsv1.Descriptor {
+	if subject == nil {
+		return nil
+	}
+
+	return &specsv1.Descriptor{
+		MediaType: subject.MediaType,
+		Digest:    subject.Digest,
+		Size:      subject.Size,
+	}
+}
+
+// writeBlob stores data as a blob unless it exists already
+func writeBlob(store BlobStore, mediaType string, data []byte) (specsv1.Descriptor, error) {
+	descr := specsv1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	if exists, err := blobExists(store, descr.Digest); err == nil && exists {
+		return descr, nil
+	}
+
+	descWr, err := NewDescriptorWriter(store, mediaType, digest.Canonical, nil)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	if _, err := descWr.Write(data); err != nil {
+		_ = descWr.Abort()
+		return specsv1.Descriptor{}, err
+	}
+
+	return descWr.Commit(descr.Digest)
+}
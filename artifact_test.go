@@ -0,0 +1,54 @@
+package oci
+
+import (
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestArtifactReferrers() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), layout.SaveImage(img))
+	subject, err := layout.Resolve("latest")
+	require.NoError(suite.T(), err)
+
+	sbom := layout.CreateArtifact("application/spdx+json", &subject)
+	_, err = sbom.AddBlob("application/spdx+json", strings.NewReader(`{"spdxVersion":"SPDX-2.3"}`), nil)
+	require.NoError(suite.T(), err)
+	sbom.AddAnnotation(specsv1.AnnotationCreated, "2021-01-01T00:00:00Z")
+	sbomDescr, err := layout.SaveArtifact(sbom)
+	require.NoError(suite.T(), err)
+
+	empty := layout.CreateArtifact("application/vnd.example.empty", &subject)
+	_, err = layout.SaveArtifact(empty)
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), layout.HasBlob(specsv1.DescriptorEmptyJSON.Digest))
+	assert.Equal(suite.T(), []specsv1.Descriptor{specsv1.DescriptorEmptyJSON}, empty.Manifest().Layers)
+
+	// Saving the same artifact again does not add it twice
+	_, err = layout.SaveArtifact(layout.CreateArtifact("application/vnd.example.empty", &subject))
+	require.NoError(suite.T(), err)
+
+	referrers, err := layout.Referrers(subject.Digest, "")
+	require.NoError(suite.T(), err)
+	assert.Len(suite.T(), referrers, 2)
+	assert.Len(suite.T(), layout.index.Manifests, 3)
+
+	referrers, err = layout.Referrers(subject.Digest, "application/spdx+json")
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), referrers, 1)
+	assert.Equal(suite.T(), sbomDescr.Digest, referrers[0].Digest)
+	assert.Equal(suite.T(), "application/spdx+json", referrers[0].ArtifactType)
+	assert.Equal(suite.T(), "2021-01-01T00:00:00Z", referrers[0].Annotations[specsv1.AnnotationCreated])
+
+	referrers, err = layout.Referrers(sbomDescr.Digest, "")
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), referrers)
+}
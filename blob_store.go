@@ -0,0 +1,266 @@
+package oci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/afero"
+)
+
+// BlobStore keeps content addressed blobs. Missing blobs are reported with errors for which os.IsNotExist is true.
+type BlobStore interface {
+	// Stat returns the size of the blob
+	Stat(d digest.Digest) (int64, error)
+	// Reader opens the blob for reading
+	Reader(d digest.Digest) (BlobReader, error)
+	// Writer starts a new blob which is stored once it is committed
+	Writer() (BlobWriter, error)
+	// Delete removes the blob
+	Delete(d digest.Digest) error
+	// Walk calls fn for every blob in the store
+	Walk(fn func(d digest.Digest, size int64) error) error
+}
+
+// BlobReader reads the content of a blob
+type BlobReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// BlobWriter receives the content of a new blob. Its digest is verified by the DescriptorWriter before Commit.
+type BlobWriter interface {
+	io.Writer
+	// Commit stores the written content as the blob d
+	Commit(d digest.Digest) error
+	// Abort discards the written content
+	Abort() error
+}
+
+// fsBlobStore stores blobs in the blobs directory of an image layout like blobs/<algorithm>/<encoded>
+type fsBlobStore struct {
+	fs afero.Fs
+	// tempDir receives the content of blobs until they are committed
+	tempDir string
+}
+
+// NewFsBlobStore returns the store of an image layout whose root is the root of fs
+func NewFsBlobStore(fs afero.Fs) BlobStore {
+	return &fsBlobStore{fs: fs, tempDir: "/"}
+}
+
+func (s *fsBlobStore) Stat(d digest.Digest) (int64, error) {
+	info, err := s.fs.Stat(blobPath(d))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *fsBlobStore) Reader(d digest.Digest) (BlobReader, error) {
+	return s.fs.Open(blobPath(d))
+}
+
+func (s *fsBlobStore) Writer() (BlobWriter, error) {
+	f, err := afero.TempFile(s.fs, s.tempDir, ".tmp.*")
+	if err != nil {
+		return nil, err
+	}
+	return &fsBlobWriter{File: f, fs: s.fs}, nil
+}
+
+func (s *fsBlobStore) Delete(d digest.Digest) error {
+	return s.fs.Remove(blobPath(d))
+}
+
+func (s *fsBlobStore) Walk(fn func(d digest.Digest, size int64) error) error {
+	exists, err := afero.DirExists(s.fs, blobsDirectory)
+	if err != nil || !exists {
+		return err
+	}
+
+	return afero.Walk(s.fs, blobsDirectory, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(blobsDirectory, name)
+		if err != nil {
+			return err
+		}
+
+		alg, encoded, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		d := digest.NewDigestFromEncoded(digest.Algorithm(alg), encoded)
+		if !ok || d.Validate() != nil {
+			return nil
+		}
+
+		return fn(d, info.Size())
+	})
+}
+
+// fsBlobWriter writes into a temporary file which is moved into the blobs directory on commit.
+// The file may be kept to resume writing later, see ResumeDescriptorWriterFs.
+type fsBlobWriter struct {
+	afero.File
+	fs afero.Fs
+}
+
+func (w *fsBlobWriter) Commit(d digest.Digest) error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+
+	target := blobPath(d)
+	if err := w.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	return w.fs.Rename(w.Name(), target)
+}
+
+func (w *fsBlobWriter) Abort() error {
+	closeErr := w.File.Close()
+	if err := w.fs.Remove(w.Name()); err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// Suspend closes the temporary file without removing it
+func (w *fsBlobWriter) Suspend() error {
+	return w.File.Close()
+}
+
+// Truncate discards the content written so far
+func (w *fsBlobWriter) Truncate() error {
+	if err := w.File.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := w.File.Seek(0, io.SeekStart)
+	return err
+}
+
+// memoryBlobStore keeps blobs in memory
+type memoryBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[digest.Digest][]byte
+}
+
+// NewMemoryBlobStore returns a store which keeps all blobs in memory, for example for tests or short lived images
+func NewMemoryBlobStore() BlobStore {
+	return &memoryBlobStore{blobs: make(map[digest.Digest][]byte)}
+}
+
+func (s *memoryBlobStore) Stat(d digest.Digest) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[d]
+	if !ok {
+		return 0, notExist("stat", d)
+	}
+	return int64(len(data)), nil
+}
+
+func (s *memoryBlobStore) Reader(d digest.Digest) (BlobReader, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[d]
+	if !ok {
+		return nil, notExist("open", d)
+	}
+	return memoryBlobReader{bytes.NewReader(data)}, nil
+}
+
+func (s *memoryBlobStore) Writer() (BlobWriter, error) {
+	return &memoryBlobWriter{store: s}, nil
+}
+
+func (s *memoryBlobStore) Delete(d digest.Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blobs[d]; !ok {
+		return notExist("remove", d)
+	}
+	delete(s.blobs, d)
+	return nil
+}
+
+func (s *memoryBlobStore) Walk(fn func(d digest.Digest, size int64) error) error {
+	s.mu.RLock()
+	digests := make([]digest.Digest, 0, len(s.blobs))
+	sizes := make(map[digest.Digest]int64, len(s.blobs))
+	for d, data := range s.blobs {
+		digests = append(digests, d)
+		sizes[d] = int64(len(data))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(digests, func(i, j int) bool { return digests[i] < digests[j] })
+	for _, d := range digests {
+		if err := fn(d, sizes[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryBlobReader struct {
+	*bytes.Reader
+}
+
+func (memoryBlobReader) Close() error {
+	return nil
+}
+
+type memoryBlobWriter struct {
+	bytes.Buffer
+	store *memoryBlobStore
+}
+
+func (w *memoryBlobWriter) Commit(d digest.Digest) error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	w.store.blobs[d] = w.Bytes()
+	return nil
+}
+
+func (w *memoryBlobWriter) Abort() error {
+	w.Reset()
+	return nil
+}
+
+// Truncate discards the content written so far
+func (w *memoryBlobWriter) Truncate() error {
+	w.Reset()
+	return nil
+}
+
+func notExist(op string, d digest.Digest) error {
+	return &os.PathError{Op: op, Path: d.String(), Err: os.ErrNotExist}
+}
+
+// blobExists reports whether the store has the blob d
+func blobExists(store BlobStore, d digest.Digest) (bool, error) {
+	_, err := store.Stat(d)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, blobError("stat blob", d, err)
+	}
+	return true, nil
+}
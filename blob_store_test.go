@@ -0,0 +1,120 @@
+package oci
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestBlobStores() {
+	for name, store := range map[string]BlobStore{
+		"fs":     NewFsBlobStore(afero.NewMemMapFs()),
+		"memory": NewMemoryBlobStore(),
+	} {
+		content := "blob content"
+		wr, err := NewDescriptorWriter(store, "text/plain", digest.Canonical, nil)
+		require.NoError(suite.T(), err, name)
+		_, err = wr.Write([]byte(content))
+		require.NoError(suite.T(), err, name)
+		_, err = wr.Commit(digest.FromString("other"))
+		assert.True(suite.T(), errors.Is(err, ErrDigestMismatch), name)
+
+		_, err = store.Stat(digest.FromString(content))
+		assert.True(suite.T(), os.IsNotExist(err), name)
+
+		wr, err = NewDescriptorWriter(store, "text/plain", digest.Canonical, nil)
+		require.NoError(suite.T(), err, name)
+		_, err = wr.Write([]byte(content))
+		require.NoError(suite.T(), err, name)
+		descr, err := wr.Close()
+		require.NoError(suite.T(), err, name)
+		assert.Equal(suite.T(), digest.FromString(content), descr.Digest, name)
+
+		size, err := store.Stat(descr.Digest)
+		require.NoError(suite.T(), err, name)
+		assert.Equal(suite.T(), int64(len(content)), size, name)
+
+		rd, err := NewDescriptorReader(store, descr)
+		require.NoError(suite.T(), err, name)
+		data, err := ioutil.ReadAll(rd)
+		require.NoError(suite.T(), err, name)
+		require.NoError(suite.T(), rd.Close(), name)
+		assert.Equal(suite.T(), content, string(data), name)
+
+		var walked []digest.Digest
+		require.NoError(suite.T(), store.Walk(func(d digest.Digest, size int64) error {
+			walked = append(walked, d)
+			return nil
+		}), name)
+		assert.Equal(suite.T(), []digest.Digest{descr.Digest}, walked, name)
+
+		require.NoError(suite.T(), store.Delete(descr.Digest), name)
+		_, err = store.Reader(descr.Digest)
+		assert.True(suite.T(), os.IsNotExist(err), name)
+		assert.True(suite.T(), os.IsNotExist(store.Delete(descr.Digest)), name)
+	}
+}
+
+func (suite *OCITestSuite) TestImageInMemoryBlobStore() {
+	store := NewMemoryBlobStore()
+	img := NewImage(store, "latest")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"etc/motd": "hello"}), specsv1.History{CreatedBy: "motd"}))
+	img.SetEnv("A", "1")
+	manifest, err := img.Close()
+	require.NoError(suite.T(), err)
+
+	mergedFs, err := img.Fs()
+	require.NoError(suite.T(), err)
+	data, err := afero.ReadFile(mergedFs, "/etc/motd")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "hello", string(data))
+
+	// A layout keeps its index on disk and its blobs in memory
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("memory")
+	require.NoError(suite.T(), err)
+	layout.SetBlobStore(store)
+	manifest.Annotations = map[string]string{specsv1.AnnotationRefName: "latest"}
+	layout.AddManifest(manifest)
+
+	opened, err := layout.OpenImage("latest")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), img.Config.RootFS.DiffIDs, opened.Config.RootFS.DiffIDs)
+	assert.True(suite.T(), layout.HasBlob(img.manifest.Layers[0].Digest))
+	exists, err := afero.DirExists(suite.fs, "test/memory/blobs/sha256")
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), exists)
+
+	onDisk, err := repo.CreateImageLayout("disk")
+	require.NoError(suite.T(), err)
+	result, err := Copy(layout, "latest", onDisk, "latest", CopyOptions{})
+	require.NoError(suite.T(), err)
+	assert.Len(suite.T(), result.Copied, 3)
+	assert.True(suite.T(), onDisk.HasBlob(img.manifest.Layers[0].Digest))
+
+	// Blobs of other stores can be reset but not resumed
+	wr, err := layout.NewBlobWriter("text/plain", digest.Canonical)
+	require.NoError(suite.T(), err)
+	_, err = wr.Write([]byte("discarded"))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), wr.Reset())
+	_, err = wr.Write([]byte("kept"))
+	require.NoError(suite.T(), err)
+	_, err = wr.Commit(digest.FromString("kept"))
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), layout.HasBlob(digest.FromString("kept")))
+
+	wr, err = layout.NewResumableBlobWriter(specsv1.Descriptor{MediaType: "text/plain", Digest: digest.FromString("partial")})
+	require.NoError(suite.T(), err)
+	_, err = wr.Write([]byte(strings.Repeat("x", 3)))
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), errors.Is(wr.Suspend(), ErrNotResumable))
+}
@@ -0,0 +1,114 @@
+package oci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/afero"
+)
+
+// BuildSource is a directory a layer of the image was built from
+type BuildSource struct {
+	// Path of the directory on the build host
+	Path string `json:"path"`
+	// Digest over the names, modes, link targets and contents of everything in the directory
+	Digest digest.Digest `json:"digest"`
+	// Layer is the digest of the layer built from the directory
+	Layer digest.Digest `json:"layer"`
+	// Recorded is when the layer was added
+	Recorded time.Time `json:"recorded"`
+}
+
+// RecordSources makes AddTree and AddDiff hash the directories they read and remember them as BuildSource.
+// Hashing reads every file a second time thus it is off by default. The build is considered started by the
+// first call. Sources and start are only kept in memory, they are lost once the image is saved and reopened.
+func (img *Image) RecordSources() {
+	if !img.recordSources {
+		img.buildStarted = time.Now().UTC()
+	}
+	img.recordSources = true
+}
+
+// Sources returns the directories recorded since RecordSources was called
+func (img *Image) Sources() []BuildSource {
+	return append([]BuildSource(nil), img.sources...)
+}
+
+// BuildStarted returns when RecordSources was first called, the zero time if it was not
+func (img *Image) BuildStarted() time.Time {
+	return img.buildStarted
+}
+
+func (img *Image) recordSource(fs afero.Fs, root string, layer digest.Digest) error {
+	if !img.recordSources {
+		return nil
+	}
+
+	d, err := hashTree(fs, root)
+	if err != nil {
+		return fmt.Errorf("cannot hash build source %s: %w", root, err)
+	}
+
+	img.sources = append(img.sources, BuildSource{
+		Path:     root,
+		Digest:   d,
+		Layer:    layer,
+		Recorded: time.Now().UTC(),
+	})
+
+	return nil
+}
+
+// hashTree computes a digest over a directory tree. Entries are visited in lexical order and each contributes
+// its relative path, mode, link target and the digest of its content.
+func hashTree(fs afero.Fs, root string) (digest.Digest, error) {
+	digester := digest.Canonical.Digester()
+	err := afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// The walk cleans the paths it visits thus root may be spelled differently than its children
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		} else {
+			rel = "/" + filepath.ToSlash(rel)
+		}
+		var content string
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if linker, ok := fs.(afero.LinkReader); ok {
+				if content, err = linker.ReadlinkIfPossible(path); err != nil {
+					return err
+				}
+			}
+		case info.Mode().IsRegular():
+			f, err := fs.Open(path)
+			if err != nil {
+				return err
+			}
+			d, err := digest.Canonical.FromReader(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			content = d.String()
+		}
+
+		_, err = io.WriteString(digester.Hash(), fmt.Sprintf("%q %o %q\n", rel, uint32(info.Mode()), content))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return digester.Digest(), nil
+}
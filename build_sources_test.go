@@ -0,0 +1,37 @@
+package oci
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestHashTree() {
+	require.NoError(suite.T(), afero.WriteFile(suite.fs, "src/a", []byte("a"), 0644))
+	require.NoError(suite.T(), afero.WriteFile(suite.fs, "src/b/c", []byte("c"), 0644))
+	require.NoError(suite.T(), afero.WriteFile(suite.fs, "other/a", []byte("a"), 0644))
+	require.NoError(suite.T(), afero.WriteFile(suite.fs, "other/b/d", []byte("c"), 0644))
+
+	expected, err := hashTree(suite.fs, "src")
+	require.NoError(suite.T(), err)
+	for _, root := range []string{"src/", "./src", "./src/", "src/b/.."} {
+		d, err := hashTree(suite.fs, root)
+		require.NoError(suite.T(), err, root)
+		assert.Equal(suite.T(), expected, d, root)
+	}
+
+	renamed, err := hashTree(suite.fs, "other")
+	require.NoError(suite.T(), err)
+	assert.NotEqual(suite.T(), expected, renamed)
+
+	// The same tree on disk hashes the same with a trailing slash
+	dir := suite.writeTree(map[string]string{"a": "a", "b/c": "c"})
+	osFs := afero.NewOsFs()
+	onDisk, err := hashTree(osFs, dir)
+	require.NoError(suite.T(), err)
+	d, err := hashTree(osFs, dir+string(filepath.Separator))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), onDisk, d)
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/toasterson/oci"
+)
+
+func (c *cli) openRepository() (*oci.Repository, error) {
+	return oci.OpenRepository(c.repo)
+}
+
+func (c *cli) openLayout(name string) (*oci.ImageLayout, error) {
+	repo, err := c.openRepository()
+	if err != nil {
+		return nil, err
+	}
+	return repo.OpenImageLayout(name)
+}
+
+// saveImage stores img in layout and returns the descriptor it was added to the index with
+func saveImage(layout *oci.ImageLayout, img *oci.Image, reference string) (specsv1.Descriptor, error) {
+	if err := layout.SaveImage(img); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+	if err := layout.Close(); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+	return layout.Resolve(reference)
+}
+
+func (c *cli) printDescriptor(descr specsv1.Descriptor) error {
+	return c.output(descr, func(w io.Writer) {
+		fmt.Fprintln(w, descr.Digest)
+	})
+}
+
+// parsePlatform parses OS/ARCH[/VARIANT]
+func parsePlatform(value string) (*specsv1.Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, &errUsage{msg: fmt.Sprintf("platform %q is not OS/ARCH[/VARIANT]", value)}
+	}
+
+	platform := &specsv1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+func history(createdBy string) specsv1.History {
+	now := time.Now()
+	return specsv1.History{Created: &now, CreatedBy: createdBy}
+}
+
+func runInit(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	path := c.repo
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	if _, err := oci.CreateRepository(path); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return c.output(map[string]string{"repository": path}, func(w io.Writer) {
+		fmt.Fprintf(w, "created repository %s\n", path)
+	})
+}
+
+func runLayoutCreate(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := c.openRepository()
+	if err != nil {
+		return err
+	}
+	if _, err := repo.CreateImageLayout(args[0]); err != nil {
+		return err
+	}
+
+	return c.output(map[string]string{"layout": args[0]}, func(w io.Writer) {
+		fmt.Fprintf(w, "created layout %s\n", args[0])
+	})
+}
+
+type layoutListing struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func runLayoutList(c *cli, args []string) error {
+	if _, err := c.parse(c.flags(), args, 0, 0); err != nil {
+		return err
+	}
+
+	repo, err := c.openRepository()
+	if err != nil {
+		return err
+	}
+	names, err := repo.ListImageLayouts()
+	if err != nil {
+		return err
+	}
+
+	layouts := make([]layoutListing, 0, len(names))
+	for _, name := range names {
+		layout, err := repo.OpenImageLayout(name)
+		if err != nil {
+			return err
+		}
+		layouts = append(layouts, layoutListing{Name: name, Tags: layout.Tags()})
+	}
+
+	return c.output(layouts, func(w io.Writer) {
+		for _, layout := range layouts {
+			fmt.Fprintf(w, "%s\t%s\n", layout.Name, strings.Join(layout.Tags, ","))
+		}
+	})
+}
+
+func runLayoutRemove(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	repo, err := c.openRepository()
+	if err != nil {
+		return err
+	}
+	if err := repo.RemoveImageLayout(args[0]); err != nil {
+		return err
+	}
+
+	return c.output(map[string]string{"layout": args[0]}, func(w io.Writer) {
+		fmt.Fprintf(w, "removed layout %s\n", args[0])
+	})
+}
+
+func runImageCreate(c *cli, args []string) error {
+	flags := c.flags()
+	platform := flags.String("platform", "", "platform of the image as OS/ARCH[/VARIANT], defaults to the running system")
+	args, err := c.parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	var p *specsv1.Platform
+	if *platform != "" {
+		if p, err = parsePlatform(*platform); err != nil {
+			return err
+		}
+	}
+
+	layout, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+
+	img := layout.CreateImage(args[1])
+	if p != nil {
+		img.Config.Platform = *p
+	}
+
+	descr, err := saveImage(layout, img, args[1])
+	if err != nil {
+		return err
+	}
+	return c.printDescriptor(descr)
+}
+
+func runImageAddTree(c *cli, args []string) error {
+	flags := c.flags()
+	createdBy := flags.String("created-by", "", "history entry of the layer, defaults to the command")
+	args, err := c.parse(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	if *createdBy == "" {
+		*createdBy = "oci image add-tree " + args[2]
+	}
+
+	layout, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+	img, err := layout.OpenImage(args[1])
+	if err != nil {
+		return err
+	}
+
+	if err := img.AddTreeContext(c.ctx, args[2], history(*createdBy)); err != nil {
+		return err
+	}
+
+	descr, err := saveImage(layout, img, args[1])
+	if err != nil {
+		return err
+	}
+	return c.printDescriptor(descr)
+}
+
+func runImageAddDiff(c *cli, args []string) error {
+	flags := c.flags()
+	createdBy := flags.String("created-by", "", "history entry of the layer, defaults to the command")
+	hashOnly := flags.Bool("hash-only", false, "compare the content of all files instead of trusting size and modification time")
+	args, err := c.parse(flags, args, 4, 4)
+	if err != nil {
+		return err
+	}
+	if *createdBy == "" {
+		*createdBy = fmt.Sprintf("oci image add-diff %s %s", args[2], args[3])
+	}
+
+	layout, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+	img, err := layout.OpenImage(args[1])
+	if err != nil {
+		return err
+	}
+
+	osFs := afero.NewOsFs()
+	err = img.AddDiffWithOptionsContext(c.ctx, osFs, osFs, args[2], args[3], history(*createdBy), oci.DiffOptions{HashOnly: *hashOnly})
+	if err != nil {
+		return err
+	}
+
+	descr, err := saveImage(layout, img, args[1])
+	if err != nil {
+		return err
+	}
+	return c.printDescriptor(descr)
+}
+
+func runImageExtract(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	layout, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+	descr, err := layout.Resolve(args[1])
+	if err != nil {
+		return err
+	}
+	img, err := layout.OpenImage(args[1])
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(args[2], 0755); err != nil {
+		return err
+	}
+	if err := img.ExtractIntoContext(c.ctx, afero.NewOsFs(), args[2]); err != nil {
+		return err
+	}
+
+	result := map[string]string{"reference": args[1], "digest": descr.Digest.String(), "directory": args[2]}
+	return c.output(result, func(w io.Writer) {
+		fmt.Fprintf(w, "extracted %s into %s\n", descr.Digest, args[2])
+	})
+}
+
+type imageInspection struct {
+	Descriptor specsv1.Descriptor `json:"descriptor"`
+	Manifest   specsv1.Manifest   `json:"manifest"`
+	Config     specsv1.Image      `json:"config"`
+}
+
+func runImageInspect(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	layout, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+	descr, err := layout.Resolve(args[1])
+	if err != nil {
+		return err
+	}
+	img, err := layout.OpenImage(args[1])
+	if err != nil {
+		return err
+	}
+
+	inspection := imageInspection{Descriptor: descr, Manifest: img.Manifest(), Config: img.Config}
+	return c.output(inspection, func(w io.Writer) {
+		config := img.Config
+		fmt.Fprintf(w, "Digest:   %s\n", descr.Digest)
+		fmt.Fprintf(w, "Platform: %s/%s\n", config.OS, config.Architecture)
+		if config.Created != nil {
+			fmt.Fprintf(w, "Created:  %s\n", config.Created.Format(time.RFC3339))
+		}
+		if len(config.Config.Entrypoint) > 0 || len(config.Config.Cmd) > 0 {
+			fmt.Fprintf(w, "Command:  %s\n", strings.Join(append(append([]string{}, config.Config.Entrypoint...), config.Config.Cmd...), " "))
+		}
+		fmt.Fprintln(w, "Layers:")
+		for _, layer := range inspection.Manifest.Layers {
+			fmt.Fprintf(w, "  %s %s\n", layer.Digest, humanize.Bytes(uint64(layer.Size)))
+		}
+		fmt.Fprintln(w, "History:")
+		for _, h := range config.History {
+			empty := ""
+			if h.EmptyLayer {
+				empty = " (no layer)"
+			}
+			fmt.Fprintf(w, "  %s%s\n", h.CreatedBy, empty)
+		}
+	})
+}
+
+func runTag(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	layout, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+	descr, err := layout.Resolve(args[1])
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{specsv1.AnnotationRefName: args[2]}
+	for key, value := range descr.Annotations {
+		if key != specsv1.AnnotationRefName {
+			annotations[key] = value
+		}
+	}
+	descr.Annotations = annotations
+	layout.AddManifest(descr)
+	if err := layout.Close(); err != nil {
+		return err
+	}
+
+	return c.printDescriptor(descr)
+}
+
+func runGC(c *cli, args []string) error {
+	if _, err := c.parse(c.flags(), args, 0, 0); err != nil {
+		return err
+	}
+
+	repo, err := c.openRepository()
+	if err != nil {
+		return err
+	}
+	result, err := repo.GC()
+	if err != nil {
+		return err
+	}
+
+	return c.output(result, func(w io.Writer) {
+		fmt.Fprintf(w, "removed %d blobs, freed %s\n", len(result.Removed), humanize.Bytes(uint64(result.Freed)))
+	})
+}
+
+func runFsck(c *cli, args []string) error {
+	if _, err := c.parse(c.flags(), args, 0, 0); err != nil {
+		return err
+	}
+
+	repo, err := c.openRepository()
+	if err != nil {
+		return err
+	}
+	report, err := repo.Fsck()
+	if err != nil {
+		return err
+	}
+
+	err = c.output(report, func(w io.Writer) {
+		for _, problem := range report.Problems {
+			if problem.Digest != "" {
+				fmt.Fprintf(w, "%s: %s: %s\n", problem.Layout, problem.Digest, problem.Problem)
+			} else {
+				fmt.Fprintf(w, "%s: %s\n", problem.Layout, problem.Problem)
+			}
+		}
+		fmt.Fprintf(w, "checked %d layouts with %d blobs, %d problems\n", report.Layouts, report.Blobs, len(report.Problems))
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(report.Problems) > 0 {
+		return errReported
+	}
+	return nil
+}
+
+func runCopy(c *cli, args []string) error {
+	flags := c.flags()
+	destRepo := flags.String("dest-repo", "", "repository of the destination layout, defaults to the -repo path")
+	allPlatforms := flags.Bool("all-platforms", false, "copy indexes with the manifests of all platforms")
+	platform := flags.String("platform", "", "platform to select from an index as OS/ARCH[/VARIANT], defaults to the running system")
+	args, err := c.parse(flags, args, 4, 4)
+	if err != nil {
+		return err
+	}
+
+	opts := oci.CopyOptions{AllPlatforms: *allPlatforms}
+	if *platform != "" {
+		if opts.Platform, err = parsePlatform(*platform); err != nil {
+			return err
+		}
+	}
+
+	src, err := c.openLayout(args[0])
+	if err != nil {
+		return err
+	}
+
+	dstPath := c.repo
+	if *destRepo != "" {
+		dstPath = *destRepo
+	}
+	dstRepo, err := oci.OpenRepository(dstPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dstPath, err)
+	}
+	var dst *oci.ImageLayout
+	if dstRepo.HasImageLayout(args[2]) {
+		dst, err = dstRepo.OpenImageLayout(args[2])
+	} else {
+		dst, err = dstRepo.CreateImageLayout(args[2])
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := oci.Copy(src, args[1], dst, args[3], opts)
+	if err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return c.output(result, func(w io.Writer) {
+		fmt.Fprintf(w, "copied %d blobs, skipped %d, %d referrers\n", len(result.Copied), len(result.Skipped), len(result.Referrers))
+		fmt.Fprintln(w, result.Descriptor.Digest)
+	})
+}
@@ -0,0 +1,214 @@
+// Command oci manages repositories of OCI image layouts.
+//
+// Every command prints a short summary or, with -json, a JSON document for scripts. The exit code is 0 on success,
+// 1 if the command failed or fsck found problems and 2 if it was called wrongly.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a subcommand like "layout create"
+type command struct {
+	args string
+	help string
+	run  func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"init":           {"[PATH]", "create a repository at PATH or the -repo path", runInit},
+	"layout create":  {"NAME", "create an empty image layout", runLayoutCreate},
+	"layout ls":      {"", "list the image layouts with their tags", runLayoutList},
+	"layout rm":      {"NAME", "remove an image layout with its blobs", runLayoutRemove},
+	"image create":   {"[-platform OS/ARCH] LAYOUT REF", "create an image without layers", runImageCreate},
+	"image add-tree": {"[-created-by TEXT] LAYOUT REF DIR", "add the directory DIR as layer", runImageAddTree},
+	"image add-diff": {"[-created-by TEXT] [-hash-only] LAYOUT REF LOWER UPPER", "add the changes from LOWER to UPPER as layer", runImageAddDiff},
+	"image extract":  {"LAYOUT REF DIR", "extract all layers of the image into DIR", runImageExtract},
+	"image inspect":  {"LAYOUT REF", "show the manifest and configuration of an image", runImageInspect},
+	"tag":            {"LAYOUT REF TAG", "add TAG as another reference name of REF", runTag},
+	"gc":             {"", "remove blobs no index references", runGC},
+	"fsck":           {"", "verify the blobs of all layouts", runFsck},
+	"copy":           {"[-dest-repo PATH] [-all-platforms] [-platform OS/ARCH] SRC_LAYOUT SRC_REF DST_LAYOUT DST_REF", "copy an image with its referrers", runCopy},
+}
+
+// errUsage reports a command called with wrong arguments
+type errUsage struct {
+	msg string
+}
+
+func (e *errUsage) Error() string {
+	return e.msg
+}
+
+// errReported is returned by commands whose output already explains why they failed
+var errReported = errors.New("failed")
+
+type cli struct {
+	ctx    context.Context
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+	repo   string
+	// name and command running for usage messages
+	name string
+	cmd  command
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	c := &cli{ctx: ctx, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("oci", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.repo, "repo", envOr("OCI_REPOSITORY", "."), "path of the repository, $OCI_REPOSITORY if set")
+	flags.BoolVar(&c.json, "json", false, "print JSON instead of text")
+	flags.Usage = func() { c.usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		c.usage(flags)
+		return exitUsage
+	}
+
+	c.name = args[0]
+	cmd, ok := commands[c.name]
+	if !ok && len(args) > 1 {
+		c.name = args[0] + " " + args[1]
+		cmd, ok = commands[c.name]
+		args = args[1:]
+	}
+	if !ok {
+		fmt.Fprintf(stderr, "oci: unknown command %q\n", strings.Join(args, " "))
+		c.usage(flags)
+		return exitUsage
+	}
+
+	c.cmd = cmd
+	err := cmd.run(c, args[1:])
+	var usageErr *errUsage
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "oci %s: %s\nusage: oci %s %s\n", c.name, err, c.name, cmd.args)
+		return exitUsage
+	case errors.Is(err, errReported):
+		return exitFailure
+	default:
+		c.fail(err)
+		return exitFailure
+	}
+}
+
+func (c *cli) usage(flags *flag.FlagSet) {
+	fmt.Fprintln(c.stderr, "usage: oci [-repo PATH] [-json] COMMAND [ARGUMENTS]")
+	flags.PrintDefaults()
+	fmt.Fprintln(c.stderr, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-15s %s\n", name, commands[name].help)
+	}
+}
+
+// flags returns the flag set of the running command. Parsing errors are usage errors.
+func (c *cli) flags() *flag.FlagSet {
+	flags := flag.NewFlagSet("oci "+c.name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: oci %s %s\n%s\n", c.name, c.cmd.args, c.cmd.help)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses the flags of the command and checks the number of positional arguments
+func (c *cli) parse(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, &errUsage{msg: err.Error()}
+	}
+
+	positional := flags.Args()
+	if len(positional) < min || len(positional) > max {
+		return nil, &errUsage{msg: fmt.Sprintf("expected %s", argumentCount(min, max))}
+	}
+	return positional, nil
+}
+
+func argumentCount(min, max int) string {
+	switch {
+	case max == 0:
+		return "no arguments"
+	case min == 1 && max == 1:
+		return "1 argument"
+	case min == max:
+		return fmt.Sprintf("%d arguments", min)
+	default:
+		return fmt.Sprintf("%d to %d arguments", min, max)
+	}
+}
+
+// output prints v as JSON or calls text to print it for humans
+func (c *cli) output(v interface{}, text func(w io.Writer)) error {
+	if !c.json {
+		text(c.stdout)
+		return nil
+	}
+
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) fail(err error) {
+	if c.json {
+		_ = json.NewEncoder(c.stderr).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+	fmt.Fprintf(c.stderr, "oci %s: %s\n", c.name, err)
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+// ociRun runs the command line against repo and returns the exit code with stdout and stderr
+func ociRun(repo string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"-repo", repo}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func ociJSON(t *testing.T, repo string, v interface{}, args ...string) {
+	code, stdout, stderr := ociRun(repo, append([]string{"-json"}, args...)...)
+	require.Equal(t, exitOK, code, stderr)
+	require.NoError(t, json.Unmarshal([]byte(stdout), v), stdout)
+}
+
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestImageLifecycle(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "repo")
+	code, _, stderr := ociRun(repo, "init")
+	require.Equal(t, exitOK, code, stderr)
+	code, _, stderr = ociRun(repo, "layout", "create", "app")
+	require.Equal(t, exitOK, code, stderr)
+
+	var created specsv1.Descriptor
+	ociJSON(t, repo, &created, "image", "create", "-platform", "linux/arm64", "app", "latest")
+	assert.NoError(t, created.Digest.Validate())
+
+	lower := writeTree(t, map[string]string{"etc/motd": "hello", "bin/app": "v1"})
+	upper := writeTree(t, map[string]string{"etc/motd": "hello", "bin/app": "v2"})
+	code, _, stderr = ociRun(repo, "image", "add-tree", "-created-by", "base", "app", "latest", lower)
+	require.Equal(t, exitOK, code, stderr)
+	var updated specsv1.Descriptor
+	ociJSON(t, repo, &updated, "image", "add-diff", "-hash-only", "app", "latest", lower, upper)
+	assert.NotEqual(t, created.Digest, updated.Digest)
+
+	var inspection imageInspection
+	ociJSON(t, repo, &inspection, "image", "inspect", "app", "latest")
+	assert.Equal(t, updated.Digest, inspection.Descriptor.Digest)
+	assert.Len(t, inspection.Manifest.Layers, 2)
+	assert.Equal(t, "arm64", inspection.Config.Architecture)
+	assert.Equal(t, "base", inspection.Config.History[0].CreatedBy)
+
+	code, stdout, _ := ociRun(repo, "image", "inspect", "app", "latest")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Platform: linux/arm64")
+
+	target := filepath.Join(t.TempDir(), "rootfs")
+	code, _, stderr = ociRun(repo, "image", "extract", "app", "latest", target)
+	require.Equal(t, exitOK, code, stderr)
+	content, err := ioutil.ReadFile(filepath.Join(target, "bin/app"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(content))
+
+	code, _, stderr = ociRun(repo, "tag", "app", "latest", "v2")
+	require.Equal(t, exitOK, code, stderr)
+	var layouts []layoutListing
+	ociJSON(t, repo, &layouts, "layout", "ls")
+	assert.Equal(t, []layoutListing{{Name: "app", Tags: []string{"latest", "v2"}}}, layouts)
+
+	other := filepath.Join(t.TempDir(), "other")
+	code, _, stderr = ociRun(other, "init")
+	require.Equal(t, exitOK, code, stderr)
+	var copied oci.CopyResult
+	ociJSON(t, repo, &copied, "copy", "-dest-repo", other, "app", "v2", "release", "1.0")
+	assert.Equal(t, updated.Digest, copied.Descriptor.Digest)
+	assert.Len(t, copied.Copied, 4)
+
+	var report oci.FsckReport
+	ociJSON(t, other, &report, "fsck")
+	assert.Equal(t, 1, report.Layouts)
+	assert.Empty(t, report.Problems)
+
+	// The manifest and config of the image without layers and the first layer are unreferenced
+	var gc oci.GCResult
+	ociJSON(t, repo, &gc, "gc")
+	assert.Len(t, gc.Removed, 4)
+
+	code, _, stderr = ociRun(repo, "layout", "rm", "app")
+	require.Equal(t, exitOK, code, stderr)
+	ociJSON(t, repo, &layouts, "layout", "ls")
+	assert.Empty(t, layouts)
+}
+
+func TestExitCodes(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "repo")
+	code, _, _ := ociRun(repo)
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = ociRun(repo, "unknown")
+	assert.Equal(t, exitUsage, code)
+	code, _, stderr := ociRun(repo, "layout", "create")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: oci layout create NAME")
+	code, _, _ = ociRun(repo, "image", "create", "-platform", "linux", "app", "latest")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = ociRun(repo, "gc", "-h")
+	assert.Equal(t, exitOK, code)
+
+	code, _, _ = ociRun(repo, "gc")
+	assert.Equal(t, exitFailure, code)
+	code, _, _ = ociRun(repo, "init")
+	require.Equal(t, exitOK, code)
+	code, _, _ = ociRun(repo, "init")
+	assert.Equal(t, exitFailure, code)
+
+	code, _, _ = ociRun(repo, "layout", "create", "app")
+	require.Equal(t, exitOK, code)
+	code, _, stderr = ociRun(repo, "-json", "image", "inspect", "app", "missing")
+	assert.Equal(t, exitFailure, code)
+	var failure map[string]string
+	require.NoError(t, json.Unmarshal([]byte(stderr), &failure), stderr)
+	assert.Contains(t, failure["error"], "image not found")
+
+	code, _, _ = ociRun(repo, "image", "create", "app", "latest")
+	require.Equal(t, exitOK, code)
+	layout, err := oci.OpenRepository(repo)
+	require.NoError(t, err)
+	app, err := layout.OpenImageLayout("app")
+	require.NoError(t, err)
+	descr, err := app.Resolve("latest")
+	require.NoError(t, err)
+	blob := filepath.Join(repo, "app", "blobs", descr.Digest.Algorithm().String(), descr.Digest.Encoded())
+	require.NoError(t, ioutil.WriteFile(blob, []byte("corrupt"), 0644))
+
+	code, stdout, _ := ociRun(repo, "fsck")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, descr.Digest.String())
+}
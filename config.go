@@ -0,0 +1,146 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The methods below change the runtime configuration of the image. Each of them records the change as history entry
+// without layer, in the form of the Dockerfile instruction doing the same, and returns the image to chain calls.
+
+// SetEnv sets the environment variable key to value replacing an existing value
+func (img *Image) SetEnv(key, value string) *Image {
+	img.setEnv(key, value)
+	return img.recordConfigChange(fmt.Sprintf("ENV %s=%s", key, value))
+}
+
+// AppendEnv appends value to the list in the environment variable key separated by a colon like in PATH.
+// A value already in the list is not added again.
+func (img *Image) AppendEnv(key, value string) *Image {
+	current, ok := img.env(key)
+	if ok && current != "" {
+		for _, v := range strings.Split(current, ":") {
+			if v == value {
+				return img
+			}
+		}
+		value = current + ":" + value
+	}
+
+	img.setEnv(key, value)
+	return img.recordConfigChange(fmt.Sprintf("ENV %s=%s", key, value))
+}
+
+// SetEntrypoint sets the arguments always executed when a container starts
+func (img *Image) SetEntrypoint(args ...string) *Image {
+	img.Config.Config.Entrypoint = args
+	return img.recordConfigChange("ENTRYPOINT " + execForm(args))
+}
+
+// SetCmd sets the default arguments to the entrypoint
+func (img *Image) SetCmd(args ...string) *Image {
+	img.Config.Config.Cmd = args
+	return img.recordConfigChange("CMD " + execForm(args))
+}
+
+// SetWorkingDir sets the current working directory of the entrypoint
+func (img *Image) SetWorkingDir(dir string) *Image {
+	img.Config.Config.WorkingDir = dir
+	return img.recordConfigChange("WORKDIR " + dir)
+}
+
+// SetUser sets the user name or UID and optionally the group the process runs as in the form user[:group]
+func (img *Image) SetUser(user string) *Image {
+	img.Config.Config.User = user
+	return img.recordConfigChange("USER " + user)
+}
+
+// AddExposedPort adds a port in the form port[/protocol], the protocol defaults to tcp
+func (img *Image) AddExposedPort(port string) *Image {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+
+	if img.Config.Config.ExposedPorts == nil {
+		img.Config.Config.ExposedPorts = make(map[string]struct{})
+	}
+	img.Config.Config.ExposedPorts[port] = struct{}{}
+	return img.recordConfigChange("EXPOSE " + port)
+}
+
+// AddVolume adds a directory the container writes data to which should be kept
+func (img *Image) AddVolume(dir string) *Image {
+	if img.Config.Config.Volumes == nil {
+		img.Config.Config.Volumes = make(map[string]struct{})
+	}
+	img.Config.Config.Volumes[dir] = struct{}{}
+	return img.recordConfigChange("VOLUME " + execForm([]string{dir}))
+}
+
+// SetLabel sets the label key to value. Labels describe the container, use AddAnnotation to describe the manifest.
+func (img *Image) SetLabel(key, value string) *Image {
+	if img.Config.Config.Labels == nil {
+		img.Config.Config.Labels = make(map[string]string)
+	}
+	img.Config.Config.Labels[key] = value
+	return img.recordConfigChange(fmt.Sprintf("LABEL %s=%s", key, value))
+}
+
+// SetStopSignal sets the signal sent to stop the container, either by name like SIGTERM or by number
+func (img *Image) SetStopSignal(signal string) *Image {
+	img.Config.Config.StopSignal = signal
+	return img.recordConfigChange("STOPSIGNAL " + signal)
+}
+
+func (img *Image) env(key string) (string, bool) {
+	for _, e := range img.Config.Config.Env {
+		if k, v, _ := strings.Cut(e, "="); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// setEnv replaces the first definition of key and drops any other
+func (img *Image) setEnv(key, value string) {
+	entry := key + "=" + value
+	env := make([]string, 0, len(img.Config.Config.Env)+1)
+	found := false
+	for _, e := range img.Config.Config.Env {
+		if k, _, _ := strings.Cut(e, "="); k == key {
+			if !found {
+				env = append(env, entry)
+				found = true
+			}
+			continue
+		}
+		env = append(env, e)
+	}
+	if !found {
+		env = append(env, entry)
+	}
+	img.Config.Config.Env = env
+}
+
+func (img *Image) recordConfigChange(createdBy string) *Image {
+	now := time.Now().UTC()
+	img.Config.History = append(img.Config.History, specsv1.History{
+		Created:    &now,
+		CreatedBy:  createdBy,
+		EmptyLayer: true,
+	})
+	return img
+}
+
+// execForm formats args as JSON array like the exec form of Dockerfile instructions
+func execForm(args []string) string {
+	if args == nil {
+		args = []string{}
+	}
+	data, _ := json.Marshal(args)
+	return string(data)
+}
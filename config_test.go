@@ -0,0 +1,67 @@
+package oci
+
+import (
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestConfigMutation() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"bin/app": "app"}), specsv1.History{CreatedBy: "app"}))
+
+	img.SetEnv("PATH", "/usr/bin").
+		AppendEnv("PATH", "/opt/bin").
+		AppendEnv("PATH", "/usr/bin").
+		AppendEnv("LANG", "C").
+		SetEnv("LANG", "C.UTF-8").
+		SetEntrypoint("/bin/app", "--serve").
+		SetCmd().
+		SetWorkingDir("/srv").
+		SetUser("app:app").
+		AddExposedPort("8080").
+		AddExposedPort("53/udp").
+		AddVolume("/data").
+		SetLabel("org.example.team", "core").
+		SetStopSignal("SIGINT")
+
+	config := img.Config.Config
+	assert.Equal(suite.T(), []string{"PATH=/usr/bin:/opt/bin", "LANG=C.UTF-8"}, config.Env)
+	assert.Equal(suite.T(), []string{"/bin/app", "--serve"}, config.Entrypoint)
+	assert.Empty(suite.T(), config.Cmd)
+	assert.Equal(suite.T(), "/srv", config.WorkingDir)
+	assert.Equal(suite.T(), "app:app", config.User)
+	assert.Equal(suite.T(), map[string]struct{}{"8080/tcp": {}, "53/udp": {}}, config.ExposedPorts)
+	assert.Equal(suite.T(), map[string]struct{}{"/data": {}}, config.Volumes)
+	assert.Equal(suite.T(), map[string]string{"org.example.team": "core"}, config.Labels)
+	assert.Equal(suite.T(), "SIGINT", config.StopSignal)
+
+	createdBy := make([]string, 0, len(img.Config.History))
+	for _, h := range img.Config.History[1:] {
+		assert.True(suite.T(), h.EmptyLayer)
+		assert.NotNil(suite.T(), h.Created)
+		createdBy = append(createdBy, h.CreatedBy)
+	}
+	assert.Equal(suite.T(), []string{
+		"ENV PATH=/usr/bin",
+		"ENV PATH=/usr/bin:/opt/bin",
+		"ENV LANG=C",
+		"ENV LANG=C.UTF-8",
+		`ENTRYPOINT ["/bin/app","--serve"]`,
+		"CMD []",
+		"WORKDIR /srv",
+		"USER app:app",
+		"EXPOSE 8080/tcp",
+		"EXPOSE 53/udp",
+		`VOLUME ["/data"]`,
+		"LABEL org.example.team=core",
+		"STOPSIGNAL SIGINT",
+	}, createdBy)
+
+	assert.Equal(suite.T(), []int{0}, img.layerHistoryIndices())
+}
@@ -0,0 +1,42 @@
+package oci
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails reads once the context is done so long copies stop on cancellation
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// contextWriter fails writes once the context is done. Archives write every header through it thus
+// cancellation is noticed between entries as well as while copying their content.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// contextErr returns the error of ctx if it is done, otherwise err. Errors caused by cancellation
+// are often wrapped by the code in between, the caller should see the context error itself.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelOnCreateFs cancels a context as soon as the first file is created in it
+type cancelOnCreateFs struct {
+	afero.Fs
+	cancel context.CancelFunc
+}
+
+func (fs *cancelOnCreateFs) Create(name string) (afero.File, error) {
+	fs.cancel()
+	return fs.Fs.Create(name)
+}
+
+func (suite *OCITestSuite) TestContextCancellation() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	tree := suite.writeTree(map[string]string{"a": "a", "b": "b", "dir/c": "c"})
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(tree, specsv1.History{CreatedBy: "tree"}))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = img.AddTreeContext(cancelled, tree, specsv1.History{CreatedBy: "cancelled"})
+	assert.True(suite.T(), errors.Is(err, context.Canceled), "%v", err)
+	err = img.AddDiffContext(cancelled, afero.NewOsFs(), afero.NewOsFs(), tree, suite.writeTree(map[string]string{"a": "changed"}), specsv1.History{CreatedBy: "cancelled"})
+	assert.True(suite.T(), errors.Is(err, context.Canceled), "%v", err)
+	assert.Len(suite.T(), img.Manifest().Layers, 1)
+	assert.Len(suite.T(), img.Config.History, 1)
+
+	require.NoError(suite.T(), afero.Walk(suite.fs, "test", func(path string, info os.FileInfo, err error) error {
+		require.NoError(suite.T(), err)
+		assert.False(suite.T(), strings.HasPrefix(info.Name(), ".tmp."), "temporary file %s left behind", path)
+		return nil
+	}))
+
+	err = img.ExtractIntoContext(cancelled, afero.NewMemMapFs(), "/")
+	assert.True(suite.T(), errors.Is(err, context.Canceled), "%v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := afero.NewMemMapFs()
+	err = img.ExtractIntoContext(ctx, &cancelOnCreateFs{Fs: target, cancel: cancel}, "/")
+	assert.True(suite.T(), errors.Is(err, context.Canceled), "%v", err)
+	files := 0
+	for _, name := range []string{"a", "b", "dir/c"} {
+		if exists, _ := afero.Exists(target, name); exists {
+			files++
+		}
+	}
+	assert.Less(suite.T(), files, 3)
+}
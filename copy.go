@@ -0,0 +1,308 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CopyOptions controls which content Copy transfers
+type CopyOptions struct {
+	// AllPlatforms copies an index with all manifests it references. Otherwise only the manifest matching
+	// Platform is copied and tagged in place of the index.
+	AllPlatforms bool
+	// Platform selects the manifest of an index, the platform of the running program is used if it is nil
+	Platform *specsv1.Platform
+	// Progress is called for every blob once it is copied or found in the target layout
+	Progress func(CopyProgress)
+	// Reporter receives the bytes copied of every blob missing in the target layout
+	Reporter ProgressReporter
+}
+
+// CopyProgress reports a single blob handled by Copy
+type CopyProgress struct {
+	Descriptor specsv1.Descriptor
+	// Skipped is set if the blob was present in the target layout already
+	Skipped bool
+}
+
+// CopyResult lists what Copy did with the blobs of an image
+type CopyResult struct {
+	// Descriptor of the copied manifest or index as added to the index of the target layout
+	Descriptor specsv1.Descriptor `json:"descriptor"`
+	// Copied blobs were missing in the target layout
+	Copied []specsv1.Descriptor `json:"copied"`
+	// Skipped blobs were present in the target layout already
+	Skipped []specsv1.Descriptor `json:"skipped"`
+	// Referrers are the manifests referring to the copied ones which were copied along and added to the index
+	Referrers []specsv1.Descriptor `json:"referrers"`
+}
+
+// manifestLinks are the fields of manifests and indexes which point to other blobs
+type manifestLinks struct {
+	MediaType string               `json:"mediaType,omitempty"`
+	Config    *specsv1.Descriptor  `json:"config,omitempty"`
+	Layers    []specsv1.Descriptor `json:"layers,omitempty"`
+	Manifests []specsv1.Descriptor `json:"manifests,omitempty"`
+}
+
+// Copy copies the manifest or index srcRef of src with everything it references into dst and adds it to the
+// index of dst under dstRef. Manifests referring to the copied ones, like signatures or SBOMs, are copied as well.
+// Blobs present in dst are skipped, all others are verified against their digest.
+// The layouts may be in different repositories or filesystems. Close dst to persist its index.
+func Copy(src *ImageLayout, srcRef string, dst *ImageLayout, dstRef string, opts CopyOptions) (*CopyResult, error) {
+	descr, err := src.Resolve(srcRef)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &layoutCopy{
+		src:    src,
+		dst:    dst,
+		opts:   opts,
+		result: &CopyResult{},
+		seen:   make(map[digest.Digest]bool),
+	}
+
+	if !opts.AllPlatforms {
+		descr, err = c.selectPlatform(descr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.copyManifest(descr); err != nil {
+		return nil, err
+	}
+
+	target := specsv1.Descriptor{
+		MediaType:    descr.MediaType,
+		Digest:       descr.Digest,
+		Size:         descr.Size,
+		Platform:     descr.Platform,
+		ArtifactType: descr.ArtifactType,
+		Annotations:  make(map[string]string),
+	}
+	for key, value := range descr.Annotations {
+		target.Annotations[key] = value
+	}
+	delete(target.Annotations, specsv1.AnnotationRefName)
+	if dstRef != "" {
+		target.Annotations[specsv1.AnnotationRefName] = dstRef
+	}
+	if len(target.Annotations) == 0 {
+		target.Annotations = nil
+	}
+
+	dst.AddManifest(target)
+	c.result.Descriptor = target
+
+	return c.result, nil
+}
+
+type layoutCopy struct {
+	src    *ImageLayout
+	dst    *ImageLayout
+	opts   CopyOptions
+	result *CopyResult
+	seen   map[digest.Digest]bool
+}
+
+// selectPlatform returns the manifest of the index descr matching the requested platform
+func (c *layoutCopy) selectPlatform(descr specsv1.Descriptor) (specsv1.Descriptor, error) {
+	data, err := c.readManifest(descr)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	var links manifestLinks
+	if err := json.Unmarshal(data, &links); err != nil {
+		return specsv1.Descriptor{}, fmt.Errorf("manifest %s cannot be read: %w", descr.Digest, err)
+	}
+	if links.Manifests == nil {
+		return descr, nil
+	}
+
+	platform := c.opts.Platform
+	if platform == nil {
+		platform = &specsv1.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	}
+
+	for _, child := range links.Manifests {
+		if child.Platform != nil && matchPlatform(platform, child.Platform) {
+			return c.selectPlatform(child)
+		}
+	}
+
+	return specsv1.Descriptor{}, fmt.Errorf("index %s has no manifest for platform %s/%s", descr.Digest, platform.OS, platform.Architecture)
+}
+
+// copyManifest copies the blobs referenced by the manifest before the manifest itself so the target layout never
+// contains a manifest with missing blobs
+func (c *layoutCopy) copyManifest(descr specsv1.Descriptor) error {
+	if c.seen[descr.Digest] {
+		return nil
+	}
+
+	data, err := c.readManifest(descr)
+	if err != nil {
+		return err
+	}
+
+	var links manifestLinks
+	if err := json.Unmarshal(data, &links); err != nil {
+		return fmt.Errorf("manifest %s cannot be read: %w", descr.Digest, err)
+	}
+
+	for _, child := range links.Manifests {
+		if err := c.copyManifest(child); err != nil {
+			return err
+		}
+	}
+
+	blobs := links.Layers
+	if links.Config != nil {
+		blobs = append([]specsv1.Descriptor{*links.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		if err := c.copyBlob(blob, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.copyBlob(descr, data); err != nil {
+		return err
+	}
+
+	return c.copyReferrers(descr)
+}
+
+// copyReferrers copies the manifests referring to descr and adds them to the index of the target layout
+func (c *layoutCopy) copyReferrers(descr specsv1.Descriptor) error {
+	referrers, err := c.src.Referrers(descr.Digest, "")
+	if err != nil {
+		return err
+	}
+
+	for _, referrer := range referrers {
+		if c.seen[referrer.Digest] {
+			continue
+		}
+
+		if err := c.copyManifest(referrer); err != nil {
+			return err
+		}
+
+		indexed, err := c.src.Resolve(referrer.Digest.String())
+		if err != nil {
+			return err
+		}
+		c.dst.AddManifest(indexed)
+		c.result.Referrers = append(c.result.Referrers, indexed)
+	}
+
+	return nil
+}
+
+// copyBlob copies a blob unless it was handled already. The content of manifests is passed as they are read before.
+func (c *layoutCopy) copyBlob(descr specsv1.Descriptor, data []byte) error {
+	if c.seen[descr.Digest] {
+		return nil
+	}
+	c.seen[descr.Digest] = true
+
+	var copied bool
+	var err error
+	if data != nil {
+		copied, err = writeBlobFrom(c.dst.blobs, descr, bytes.NewReader(data), c.opts.Reporter)
+	} else {
+		copied, err = copyBlob(c.src.blobs, c.dst.blobs, descr, c.opts.Reporter)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot copy blob %s: %w", descr.Digest, err)
+	}
+
+	if copied {
+		c.result.Copied = append(c.result.Copied, descr)
+	} else {
+		c.result.Skipped = append(c.result.Skipped, descr)
+	}
+	if c.opts.Progress != nil {
+		c.opts.Progress(CopyProgress{Descriptor: descr, Skipped: !copied})
+	}
+
+	return nil
+}
+
+func (c *layoutCopy) readManifest(descr specsv1.Descriptor) ([]byte, error) {
+	rd, err := c.src.OpenBlob(descr.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	if d := descr.Digest.Algorithm().FromBytes(data); d != descr.Digest {
+		return nil, &Error{Op: "read manifest", Digest: descr.Digest, Err: fmt.Errorf("%w: got %s", ErrDigestMismatch, d)}
+	}
+
+	return data, nil
+}
+
+// matchPlatform reports whether the platform of a manifest satisfies the requested one. Fields not requested match any value.
+func matchPlatform(want, have *specsv1.Platform) bool {
+	return want.OS == have.OS && want.Architecture == have.Architecture &&
+		(want.Variant == "" || want.Variant == have.Variant) &&
+		(want.OSVersion == "" || want.OSVersion == have.OSVersion)
+}
+
+// copyBlob copies the blob of descr from src to dst unless dst has it already and reports whether it copied it.
+// The progress of the copy is reported to reporter if it is not nil.
+func copyBlob(src, dst BlobStore, descr specsv1.Descriptor, reporter ProgressReporter) (bool, error) {
+	if exists, err := blobExists(dst, descr.Digest); err != nil || exists {
+		return false, err
+	}
+
+	r, err := NewDescriptorReader(src, descr)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	return writeBlobFrom(dst, descr, r, reporter)
+}
+
+// writeBlobFrom stores the content of r as the blob descr unless it exists already. The content has to match the digest.
+func writeBlobFrom(store BlobStore, descr specsv1.Descriptor, r io.Reader, reporter ProgressReporter) (bool, error) {
+	if exists, err := blobExists(store, descr.Digest); err != nil || exists {
+		return false, err
+	}
+
+	descWr, err := NewDescriptorWriter(store, descr.MediaType, descr.Digest.Algorithm(), nil)
+	if err != nil {
+		return false, err
+	}
+	descWr.progress.event = ProgressEvent{Operation: ProgressCopyBlob, Digest: descr.Digest, Total: descr.Size}
+	descWr.SetProgress(reporter)
+
+	if _, err := io.Copy(descWr, r); err != nil {
+		_ = descWr.Abort()
+		return false, err
+	}
+
+	if _, err := descWr.Commit(descr.Digest); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
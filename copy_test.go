@@ -0,0 +1,116 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestCopy() {
+	srcRepo, err := CreateRepositoryFS(suite.fs, "src")
+	require.NoError(suite.T(), err)
+	src, err := srcRepo.CreateImageLayout("build")
+	require.NoError(suite.T(), err)
+	dstRepo, err := CreateRepositoryFS(afero.NewMemMapFs(), "dst")
+	require.NoError(suite.T(), err)
+	dst, err := dstRepo.CreateImageLayout("release")
+	require.NoError(suite.T(), err)
+
+	shared := suite.writeTree(map[string]string{"bin/sh": "sh"})
+	index := specsv1.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: specsv1.MediaTypeImageIndex}
+	images := make(map[string]*Image)
+	for _, arch := range []string{"amd64", "arm64"} {
+		img := src.CreateImage(arch)
+		img.Config.Platform.Architecture = arch
+		require.NoError(suite.T(), img.AddTree(shared, specsv1.History{CreatedBy: "shared"}))
+		require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"arch": arch}), specsv1.History{CreatedBy: arch}))
+		require.NoError(suite.T(), src.SaveImage(img))
+		descr, err := src.Resolve(arch)
+		require.NoError(suite.T(), err)
+		index.Manifests = append(index.Manifests, specsv1.Descriptor{
+			MediaType: specsv1.MediaTypeImageManifest,
+			Digest:    descr.Digest,
+			Size:      descr.Size,
+			Platform:  &specsv1.Platform{OS: "linux", Architecture: arch},
+		})
+		images[arch] = img
+	}
+	data, err := json.Marshal(index)
+	require.NoError(suite.T(), err)
+	indexDescr, err := writeBlob(src.blobs, specsv1.MediaTypeImageIndex, data)
+	require.NoError(suite.T(), err)
+	indexDescr.Annotations = map[string]string{specsv1.AnnotationRefName: "multi"}
+	src.AddManifest(indexDescr)
+
+	amd64 := index.Manifests[0]
+	sbom := src.CreateArtifact("application/spdx+json", &amd64)
+	_, err = sbom.AddBlob("application/spdx+json", strings.NewReader(`{"spdxVersion":"SPDX-2.3"}`), nil)
+	require.NoError(suite.T(), err)
+	sbomDescr, err := src.SaveArtifact(sbom)
+	require.NoError(suite.T(), err)
+
+	var progress []CopyProgress
+	result, err := Copy(src, "multi", dst, "v1", CopyOptions{
+		AllPlatforms: true,
+		Progress:     func(p CopyProgress) { progress = append(progress, p) },
+	})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), indexDescr.Digest, result.Descriptor.Digest)
+	assert.Empty(suite.T(), result.Skipped)
+	assert.Len(suite.T(), progress, len(result.Copied))
+	// Index, two manifests, two configs, three layers and the artifact with its config and blob
+	assert.Len(suite.T(), result.Copied, 11)
+	require.Len(suite.T(), result.Referrers, 1)
+	assert.Equal(suite.T(), sbomDescr.Digest, result.Referrers[0].Digest)
+
+	resolved, err := dst.Resolve("v1")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), specsv1.MediaTypeImageIndex, resolved.MediaType)
+	for _, descr := range result.Copied {
+		assert.True(suite.T(), dst.HasBlob(descr.Digest))
+	}
+	referrers, err := dst.Referrers(amd64.Digest, "")
+	require.NoError(suite.T(), err)
+	assert.Len(suite.T(), referrers, 1)
+
+	arm64, err := dst.OpenImage(index.Manifests[1].Digest.String())
+	assert.Error(suite.T(), err, "manifests of an index are not tagged on their own")
+	assert.Nil(suite.T(), arm64)
+
+	// Everything is present already
+	result, err = Copy(src, "multi", dst, "v1-again", CopyOptions{AllPlatforms: true})
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), result.Copied)
+	assert.Len(suite.T(), result.Skipped, 11)
+
+	// A single platform is tagged in place of the index
+	single, err := dstRepo.CreateImageLayout("single")
+	require.NoError(suite.T(), err)
+	result, err = Copy(src, "multi", single, "arm64", CopyOptions{Platform: &specsv1.Platform{OS: "linux", Architecture: "arm64"}})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), index.Manifests[1].Digest, result.Descriptor.Digest)
+	assert.Len(suite.T(), result.Copied, 4)
+	assert.Empty(suite.T(), result.Referrers)
+	assert.False(suite.T(), single.HasBlob(images["amd64"].manifest.Layers[1].Digest))
+	img, err := single.OpenImage("arm64")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "arm64", img.Config.Platform.Architecture)
+
+	_, err = Copy(src, "multi", single, "riscv", CopyOptions{Platform: &specsv1.Platform{OS: "linux", Architecture: "riscv64"}})
+	assert.Error(suite.T(), err)
+
+	// Corrupted blobs are not copied
+	corrupt, err := dstRepo.CreateImageLayout("corrupt")
+	require.NoError(suite.T(), err)
+	layer := images["amd64"].manifest.Layers[1]
+	require.NoError(suite.T(), afero.WriteFile(src.fs, blobPath(layer.Digest), []byte("corrupt"), 0644))
+	_, err = Copy(src, "amd64", corrupt, "amd64", CopyOptions{})
+	assert.True(suite.T(), errors.Is(err, ErrDigestMismatch))
+	assert.False(suite.T(), corrupt.HasBlob(layer.Digest))
+}
@@ -10,42 +10,48 @@ import (
 )
 
 type DescriptorReader struct {
-	backingFile afero.File
-	fs          afero.Fs
+	blob BlobReader
 }
 
 func (d *DescriptorReader) Read(p []byte) (n int, err error) {
-	return d.backingFile.Read(p)
+	return d.blob.Read(p)
+}
+
+func (d *DescriptorReader) ReadAt(p []byte, off int64) (n int, err error) {
+	return d.blob.ReadAt(p, off)
+}
+
+func (d *DescriptorReader) Seek(offset int64, whence int) (int64, error) {
+	return d.blob.Seek(offset, whence)
 }
 
 func (d *DescriptorReader) Close() error {
-	return d.backingFile.Close()
+	return d.blob.Close()
 }
 
 func (d *DescriptorReader) Decode(ptr interface{}) error {
 	return json.NewDecoder(d).Decode(ptr)
 }
 
-func NewDescriptorReaderFsDigest(fs afero.Fs, digest digest.Digest) (*DescriptorReader, error) {
-	file, err := fs.Open(filepath.Join(blobsDirectory, digest.Algorithm().String(), digest.Encoded()))
+// blobPath returns the path of a blob relative to the root of an image layout
+func blobPath(d digest.Digest) string {
+	return filepath.Join(blobsDirectory, d.Algorithm().String(), d.Encoded())
+}
+
+// NewDescriptorReader opens the blob of the descriptor in store
+func NewDescriptorReader(store BlobStore, descriptor specsv1.Descriptor) (*DescriptorReader, error) {
+	blob, err := store.Reader(descriptor.Digest)
 	if err != nil {
-		return nil, err
+		return nil, blobError("open blob", descriptor.Digest, err)
 	}
 
-	return &DescriptorReader{
-		fs:          fs,
-		backingFile: file,
-	}, nil
+	return &DescriptorReader{blob: blob}, nil
 }
 
-func NewDescriptorReaderFs(fs afero.Fs, descriptor specsv1.Descriptor) (*DescriptorReader, error) {
-	file, err := fs.Open(filepath.Join(blobsDirectory, descriptor.Digest.Algorithm().String(), descriptor.Digest.Encoded()))
-	if err != nil {
-		return nil, err
-	}
+func NewDescriptorReaderFsDigest(fs afero.Fs, digest digest.Digest) (*DescriptorReader, error) {
+	return NewDescriptorReader(NewFsBlobStore(fs), specsv1.Descriptor{Digest: digest})
+}
 
-	return &DescriptorReader{
-		fs:          fs,
-		backingFile: file,
-	}, nil
+func NewDescriptorReaderFs(fs afero.Fs, descriptor specsv1.Descriptor) (*DescriptorReader, error) {
+	return NewDescriptorReader(NewFsBlobStore(fs), descriptor)
 }
@@ -2,8 +2,9 @@ package oci
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
-	"path/filepath"
+	"os"
 
 	"github.com/opencontainers/go-digest"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -11,33 +12,114 @@ import (
 )
 
 type DescriptorWriter struct {
-	backingFile afero.File
-	writer      io.Writer
-	digester    digest.Digester
-	descriptor  specsv1.Descriptor
-	fs          afero.Fs
+	blob       BlobWriter
+	writer     io.Writer
+	algorithm  digest.Algorithm
+	digester   digest.Digester
+	descriptor specsv1.Descriptor
+	progress   *progressTracker
+}
+
+// suspendableBlobWriter is a BlobWriter whose content survives Suspend
+type suspendableBlobWriter interface {
+	Suspend() error
+}
+
+// truncatableBlobWriter is a BlobWriter whose content written so far can be discarded
+type truncatableBlobWriter interface {
+	Truncate() error
 }
 
 func (d *DescriptorWriter) Close() (specsv1.Descriptor, error) {
-	if err := d.backingFile.Close(); err != nil {
+	d.descriptor.Digest = d.digester.Digest()
+
+	if err := d.blob.Commit(d.descriptor.Digest); err != nil {
 		return specsv1.Descriptor{}, err
 	}
+	d.finishProgress()
 
+	return d.descriptor, nil
+}
+
+// Commit closes the writer like Close but only stores the content as blob if its digest matches
+// the expected one. On mismatch the content is discarded.
+func (d *DescriptorWriter) Commit(expected digest.Digest) (specsv1.Descriptor, error) {
 	d.descriptor.Digest = d.digester.Digest()
 
-	if err := d.fs.Rename(d.backingFile.Name(), filepath.Join(blobsDirectory, d.descriptor.Digest.Algorithm().String(), d.descriptor.Digest.Encoded())); err != nil {
+	if d.descriptor.Digest != expected {
+		_ = d.blob.Abort()
+		return specsv1.Descriptor{}, &Error{Op: "commit blob", Digest: expected, Err: fmt.Errorf("%w: got %s", ErrDigestMismatch, d.descriptor.Digest)}
+	}
+
+	if err := d.blob.Commit(d.descriptor.Digest); err != nil {
 		return specsv1.Descriptor{}, err
 	}
+	d.finishProgress()
 
 	return d.descriptor, nil
 }
 
+// Abort closes the writer and discards the content without committing anything
+func (d *DescriptorWriter) Abort() error {
+	return d.blob.Abort()
+}
+
+// Suspend closes the writer but keeps the temporary file so a later ResumeDescriptorWriterFs can continue writing.
+// Writers of stores which cannot keep unfinished blobs are aborted.
+func (d *DescriptorWriter) Suspend() error {
+	suspendable, ok := d.blob.(suspendableBlobWriter)
+	if !ok {
+		if err := d.blob.Abort(); err != nil {
+			return err
+		}
+		return ErrNotResumable
+	}
+
+	return suspendable.Suspend()
+}
+
+// Reset discards everything written so far
+func (d *DescriptorWriter) Reset() error {
+	if d.descriptor.Size > 0 {
+		truncatable, ok := d.blob.(truncatableBlobWriter)
+		if !ok {
+			return ErrNotResumable
+		}
+		if err := truncatable.Truncate(); err != nil {
+			return err
+		}
+	}
+
+	d.digester = d.algorithm.Digester()
+	d.writer = io.MultiWriter(d.blob, d.digester.Hash())
+	d.descriptor.Size = 0
+	d.progress.event.Bytes = 0
+
+	return nil
+}
+
+// SetProgress reports the bytes written to reporter. The last event carries the digest of the blob.
+func (d *DescriptorWriter) SetProgress(reporter ProgressReporter) {
+	d.progress.setReporter(reporter)
+}
+
+func (d *DescriptorWriter) finishProgress() {
+	d.progress.event.Digest = d.descriptor.Digest
+	d.progress.done()
+}
+
+// Size returns the number of bytes written so far including the content a resumed writer started with
+func (d *DescriptorWriter) Size() int64 {
+	return d.descriptor.Size
+}
+
 func (d *DescriptorWriter) Write(p []byte) (n int, err error) {
 	written, err := d.writer.Write(p)
 	if err != nil {
 		return written, err
 	}
 	d.descriptor.Size += int64(written)
+	d.progress.add(written)
 
 	return written, nil
 }
@@ -46,26 +128,60 @@ func (d *DescriptorWriter) Encode(data interface{}) error {
 	return json.NewEncoder(d).Encode(data)
 }
 
-func NewDescriptorWriterFs(fs afero.Fs, path string, mediaType string, alg digest.Algorithm, plat *specsv1.Platform) (*DescriptorWriter, error) {
-	backingFile, err := afero.TempFile(fs, path, ".tmp.*")
+// NewDescriptorWriter starts a new blob in store
+func NewDescriptorWriter(store BlobStore, mediaType string, alg digest.Algorithm, plat *specsv1.Platform) (*DescriptorWriter, error) {
+	blob, err := store.Writer()
 	if err != nil {
 		return nil, err
 	}
 
+	return newDescriptorWriter(blob, mediaType, alg, plat), nil
+}
+
+// NewDescriptorWriterFs starts a new blob in the image layout at the root of fs. The content is kept in a temporary
+// file in the directory path until it is committed.
+func NewDescriptorWriterFs(fs afero.Fs, path string, mediaType string, alg digest.Algorithm, plat *specsv1.Platform) (*DescriptorWriter, error) {
+	return NewDescriptorWriter(&fsBlobStore{fs: fs, tempDir: path}, mediaType, alg, plat)
+}
+
+func newDescriptorWriter(blob BlobWriter, mediaType string, alg digest.Algorithm, plat *specsv1.Platform) *DescriptorWriter {
 	if !alg.Available() {
 		alg = digest.Canonical
 	}
 
 	desc := &DescriptorWriter{
-		backingFile: backingFile,
-		digester:    alg.Digester(),
+		blob:      blob,
+		algorithm: alg,
+		digester:  alg.Digester(),
+		progress:  newProgressTracker(ProgressWriteBlob),
 		descriptor: specsv1.Descriptor{
 			MediaType: mediaType,
 			Platform:  plat,
 		},
 	}
 
-	desc.writer = io.MultiWriter(desc.backingFile, desc.digester.Hash())
+	desc.writer = io.MultiWriter(desc.blob, desc.digester.Hash())
+
+	return desc
+}
+
+// ResumeDescriptorWriterFs opens the temporary file name or creates it if it does not exist.
+// Content already in the file is hashed so writing continues where a suspended writer stopped.
+func ResumeDescriptorWriterFs(fs afero.Fs, name string, mediaType string, alg digest.Algorithm) (*DescriptorWriter, error) {
+	backingFile, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := newDescriptorWriter(&fsBlobWriter{File: backingFile, fs: fs}, mediaType, alg, nil)
+
+	// Reading the existing content leaves the offset at the end of the file
+	desc.descriptor.Size, err = io.Copy(desc.digester.Hash(), backingFile)
+	if err != nil {
+		backingFile.Close()
+		return nil, err
+	}
+	desc.progress.event.Bytes = desc.descriptor.Size
 
 	return desc, nil
 }
@@ -0,0 +1,282 @@
+package oci
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Attributes of a path which are compared by a diff
+const (
+	FieldType     = "type"
+	FieldMode     = "mode"
+	FieldOwner    = "owner"
+	FieldSize     = "size"
+	FieldContent  = "content"
+	FieldLinkname = "linkname"
+)
+
+// FileState describes a path of the merged filesystem of an image as far as it is compared by a diff
+type FileState struct {
+	Type string `json:"type"`
+	// Mode are the permission bits including setuid, setgid and sticky in octal
+	Mode     string        `json:"mode"`
+	Uid      int           `json:"uid"`
+	Gid      int           `json:"gid"`
+	Size     int64         `json:"size"`
+	Digest   digest.Digest `json:"digest,omitempty"`
+	Linkname string        `json:"linkname,omitempty"`
+}
+
+// Change is a path which differs between two filesystems. Before is nil for added and After for removed paths.
+type Change struct {
+	Path   string     `json:"path"`
+	Kind   ChangeKind `json:"kind"`
+	Fields []string   `json:"fields,omitempty"`
+	Before *FileState `json:"before,omitempty"`
+	After  *FileState `json:"after,omitempty"`
+}
+
+// DiffReport lists all changed paths sorted by path
+type DiffReport struct {
+	Changes  []Change `json:"changes"`
+	Added    int      `json:"added"`
+	Removed  int      `json:"removed"`
+	Modified int      `json:"modified"`
+}
+
+// Encode writes the report as indented JSON
+func (r *DiffReport) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// DiffImages compares the merged filesystems of two images. Changes are reported from a to b.
+func DiffImages(a, b *Image) (*DiffReport, error) {
+	before, err := a.fileStates(len(a.manifest.Layers))
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := b.fileStates(len(b.manifest.Layers))
+	if err != nil {
+		return nil, err
+	}
+
+	return diffFileStates(before, after), nil
+}
+
+// DiffLayer reports the changes the layer with the given index makes to the merged filesystem of the layers below it
+func (img *Image) DiffLayer(index int) (*DiffReport, error) {
+	if index < 0 || index >= len(img.manifest.Layers) {
+		return nil, fmt.Errorf("layer %d does not exist in image with %d layers", index, len(img.manifest.Layers))
+	}
+
+	after, _, layers, err := img.mergedIndexEntries(0, index+1)
+	if err != nil {
+		return nil, err
+	}
+
+	// The merged filesystem below the layer is built from the entries indexed already
+	lower := newMergedTree()
+	for i, layerEntries := range layers[:index] {
+		lower.add(i, layerEntries)
+	}
+	before := lower.visible
+
+	// Paths found at the same entry on both sides are unchanged thus only the other contents are hashed
+	changed := make(map[entryLocation]bool)
+	for name, loc := range before {
+		if found, ok := after[name]; !ok || found != loc {
+			changed[loc] = true
+		}
+	}
+	for name, loc := range after {
+		if found, ok := before[name]; !ok || found != loc {
+			changed[loc] = true
+		}
+	}
+
+	digests, err := img.entryDigests(changed)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffFileStates(entryStates(before, layers, digests), entryStates(after, layers, digests)), nil
+}
+
+// fileStates describes every path of the merged filesystem of the first layers of the image
+func (img *Image) fileStates(layers int) (map[string]*FileState, error) {
+	visible, _, entries, err := img.mergedIndexEntries(0, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(map[entryLocation]bool, len(visible))
+	for _, loc := range visible {
+		locations[loc] = true
+	}
+
+	digests, err := img.entryDigests(locations)
+	if err != nil {
+		return nil, err
+	}
+
+	return entryStates(visible, entries, digests), nil
+}
+
+// entryDigests hashes the contents of the regular files at the locations. Layers without any of them are not read.
+func (img *Image) entryDigests(locations map[entryLocation]bool) (map[entryLocation]digest.Digest, error) {
+	layers := make(map[int]bool)
+	for loc := range locations {
+		layers[loc.layer] = true
+	}
+
+	digests := make(map[entryLocation]digest.Digest)
+	for i := range layers {
+		entry := 0
+		err := img.walkLayer(i, func(layer int, hdr *tar.Header, r io.Reader) error {
+			loc := entryLocation{layer: layer, entry: entry}
+			entry++
+
+			if !locations[loc] || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+				return nil
+			}
+
+			d, err := digest.Canonical.FromReader(r)
+			if err != nil {
+				return err
+			}
+			digests[loc] = d
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return digests, nil
+}
+
+// entryStates describes the visible paths of a merged index by the headers of their entries and the digests
+// of their contents if known
+func entryStates(visible map[string]entryLocation, layers [][]layerEntry, digests map[entryLocation]digest.Digest) map[string]*FileState {
+	states := make(map[string]*FileState, len(visible))
+	for name, loc := range visible {
+		hdr := layers[loc.layer][loc.entry].hdr
+		state := &FileState{
+			Type:   entryType(hdr.Typeflag),
+			Mode:   fmt.Sprintf("%04o", hdr.Mode&07777),
+			Uid:    hdr.Uid,
+			Gid:    hdr.Gid,
+			Size:   hdr.Size,
+			Digest: digests[loc],
+		}
+		if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+			state.Linkname = hdr.Linkname
+		}
+		states[name] = state
+	}
+
+	// Hardlinks have the content of the file they link to
+	for _, state := range states {
+		if state.Type != entryType(tar.TypeLink) {
+			continue
+		}
+		if target, ok := states[CleanEntryName(state.Linkname)]; ok {
+			state.Size = target.Size
+			state.Digest = target.Digest
+		}
+	}
+
+	return states
+}
+
+func diffFileStates(before, after map[string]*FileState) *DiffReport {
+	report := &DiffReport{Changes: make([]Change, 0)}
+
+	for name, b := range before {
+		a, ok := after[name]
+		if !ok {
+			report.Changes = append(report.Changes, Change{Path: name, Kind: ChangeRemoved, Before: b})
+			report.Removed++
+			continue
+		}
+
+		if fields := changedFields(b, a); len(fields) > 0 {
+			report.Changes = append(report.Changes, Change{Path: name, Kind: ChangeModified, Fields: fields, Before: b, After: a})
+			report.Modified++
+		}
+	}
+
+	for name, a := range after {
+		if _, ok := before[name]; !ok {
+			report.Changes = append(report.Changes, Change{Path: name, Kind: ChangeAdded, After: a})
+			report.Added++
+		}
+	}
+
+	sort.Slice(report.Changes, func(i, j int) bool {
+		return report.Changes[i].Path < report.Changes[j].Path
+	})
+
+	return report
+}
+
+func changedFields(before, after *FileState) []string {
+	fields := make([]string, 0)
+	if before.Type != after.Type {
+		fields = append(fields, FieldType)
+	}
+	if before.Mode != after.Mode {
+		fields = append(fields, FieldMode)
+	}
+	if before.Uid != after.Uid || before.Gid != after.Gid {
+		fields = append(fields, FieldOwner)
+	}
+	// The size of directories depends on the archiver and says nothing about their contents
+	if before.Size != after.Size && before.Type != entryType(tar.TypeDir) {
+		fields = append(fields, FieldSize)
+	}
+	if before.Digest != after.Digest {
+		fields = append(fields, FieldContent)
+	}
+	if before.Linkname != after.Linkname {
+		fields = append(fields, FieldLinkname)
+	}
+	return fields
+}
+
+// entryType names the type of an archive entry
+func entryType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return fmt.Sprintf("unknown(%c)", typeflag)
+}
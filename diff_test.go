@@ -0,0 +1,68 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestDiff() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"a": "a", "bin/tool": "tool", "gone": "gone"})
+	require.NoError(suite.T(), os.Symlink("tool", filepath.Join(lower, "bin", "link")))
+	upper := suite.writeTree(map[string]string{"a": "a", "bin/tool": "tool"})
+	require.NoError(suite.T(), os.Symlink("tool", filepath.Join(upper, "bin", "link")))
+	top := suite.writeTree(map[string]string{"a": "changed", "new": "new", "bin/tool": "tool"})
+	require.NoError(suite.T(), os.Chmod(filepath.Join(top, "bin", "tool"), 0755))
+	require.NoError(suite.T(), os.Symlink("other", filepath.Join(top, "bin", "link")))
+
+	base := layout.CreateImage("base")
+	require.NoError(suite.T(), base.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+	require.NoError(suite.T(), img.AddDiff(afero.NewOsFs(), afero.NewOsFs(), lower, upper, specsv1.History{CreatedBy: "remove gone"}))
+	require.NoError(suite.T(), img.AddTree(top, specsv1.History{CreatedBy: "top"}))
+
+	report, err := DiffImages(base, img)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, report.Added)
+	assert.Equal(suite.T(), 1, report.Removed)
+	assert.Equal(suite.T(), 3, report.Modified)
+
+	changes := make(map[string]Change)
+	for _, change := range report.Changes {
+		changes[change.Path] = change
+	}
+	assert.Equal(suite.T(), ChangeAdded, changes["new"].Kind)
+	assert.Equal(suite.T(), ChangeRemoved, changes["gone"].Kind)
+	assert.Equal(suite.T(), []string{FieldSize, FieldContent}, changes["a"].Fields)
+	assert.Equal(suite.T(), []string{FieldMode}, changes["bin/tool"].Fields)
+	assert.Equal(suite.T(), "0755", changes["bin/tool"].After.Mode)
+	assert.Equal(suite.T(), []string{FieldLinkname}, changes["bin/link"].Fields)
+	assert.Equal(suite.T(), "other", changes["bin/link"].After.Linkname)
+
+	layerReport, err := img.DiffLayer(1)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), layerReport.Changes, 1)
+	assert.Equal(suite.T(), Change{Path: "gone", Kind: ChangeRemoved, Before: changes["gone"].Before}, layerReport.Changes[0])
+
+	_, err = img.DiffLayer(3)
+	assert.Error(suite.T(), err)
+
+	var buf bytes.Buffer
+	require.NoError(suite.T(), report.Encode(&buf))
+	decoded := DiffReport{}
+	require.NoError(suite.T(), json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(suite.T(), *report, decoded)
+}
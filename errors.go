@@ -0,0 +1,69 @@
+package oci
+
+import (
+	"errors"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	// ErrBlobNotFound is returned for blobs missing in the store of a layout or image.
+	// errors.Is also matches it against os.ErrNotExist.
+	ErrBlobNotFound error = notFoundError("blob not found")
+	// ErrImageNotFound is returned for references missing in the index of a layout.
+	// errors.Is also matches it against os.ErrNotExist.
+	ErrImageNotFound error = notFoundError("image not found")
+	// ErrLayoutNotFound is returned for layouts missing in a repository.
+	// errors.Is also matches it against os.ErrNotExist.
+	ErrLayoutNotFound error = notFoundError("image layout not found")
+	ErrNotImage             = errors.New("not an image manifest")
+	ErrInvalidLayout        = errors.New("invalid image layout")
+	ErrLayoutExists         = errors.New("image layout exists")
+	// ErrDigestMismatch is returned when committed content does not match the expected digest
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrNotResumable is returned by blob writers of stores which cannot keep or discard unfinished blobs
+	ErrNotResumable = errors.New("blob writer cannot be suspended or reset")
+)
+
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+// Error records the path, reference or digest an operation failed on. Err is one of the sentinel errors of the
+// package or the error of the underlying filesystem or store.
+type Error struct {
+	Op        string
+	Path      string
+	Reference string
+	Digest    digest.Digest
+	Err       error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op
+	for _, subject := range []string{e.Path, e.Reference, e.Digest.String()} {
+		if subject != "" {
+			msg += " " + subject
+		}
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// blobError reports a failed operation on the blob d. Missing blobs are reported as ErrBlobNotFound.
+func blobError(op string, d digest.Digest, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		err = ErrBlobNotFound
+	}
+	return &Error{Op: op, Digest: d, Err: err}
+}
@@ -0,0 +1,86 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Infof(format string, args ...interface{})  { l.Debugf(format, args...) }
+func (l *recordingLogger) Warnf(format string, args ...interface{})  { l.Debugf(format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.Debugf(format, args...) }
+
+func (suite *OCITestSuite) TestErrors() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	_, err = repo.CreateImageLayout("testing")
+	assert.True(suite.T(), errors.Is(err, ErrLayoutExists), "%v", err)
+
+	var opErr *Error
+	_, err = layout.Resolve("missing")
+	assert.True(suite.T(), errors.Is(err, ErrImageNotFound), "%v", err)
+	assert.True(suite.T(), errors.Is(err, os.ErrNotExist), "%v", err)
+	require.True(suite.T(), errors.As(err, &opErr))
+	assert.Equal(suite.T(), "missing", opErr.Reference)
+
+	missing := digest.FromString("missing")
+	_, err = layout.OpenBlob(missing)
+	assert.True(suite.T(), errors.Is(err, ErrBlobNotFound), "%v", err)
+	assert.True(suite.T(), errors.Is(err, os.ErrNotExist), "%v", err)
+	require.True(suite.T(), errors.As(err, &opErr))
+	assert.Equal(suite.T(), missing, opErr.Digest)
+
+	wr, err := layout.NewBlobWriter("text/plain", digest.Canonical)
+	require.NoError(suite.T(), err)
+	_, err = wr.Write([]byte("content"))
+	require.NoError(suite.T(), err)
+	_, err = wr.Commit(missing)
+	assert.True(suite.T(), errors.Is(err, ErrDigestMismatch), "%v", err)
+	require.True(suite.T(), errors.As(err, &opErr))
+	assert.Equal(suite.T(), missing, opErr.Digest)
+
+	require.NoError(suite.T(), suite.fs.MkdirAll("test/broken", 0755))
+	require.NoError(suite.T(), afero.WriteFile(suite.fs, "test/broken/"+specsv1.ImageLayoutFile, []byte(`{}`), 0644))
+	_, err = repo.OpenImageLayout("broken")
+	assert.True(suite.T(), errors.Is(err, ErrInvalidLayout), "%v", err)
+	require.True(suite.T(), errors.As(err, &opErr))
+	assert.Equal(suite.T(), "broken/index.json", opErr.Path)
+}
+
+func (suite *OCITestSuite) TestLogger() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	logger := &recordingLogger{}
+	repo.SetLogger(logger)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"etc/motd": "hello"}), specsv1.History{CreatedBy: "motd"}))
+	require.NoError(suite.T(), img.ExtractInto(afero.NewMemMapFs(), "/"))
+	assert.Contains(suite.T(), logger.messages, "extracting file etc/motd")
+
+	// Without logger nothing is recorded
+	logger.messages = nil
+	layout.SetLogger(nil)
+	img = layout.CreateImage("quiet")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"etc/motd": "hello"}), specsv1.History{CreatedBy: "motd"}))
+	require.NoError(suite.T(), img.ExtractInto(afero.NewMemMapFs(), "/"))
+	assert.Empty(suite.T(), logger.messages)
+}
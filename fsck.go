@@ -0,0 +1,85 @@
+package oci
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// FsckProblem is an inconsistency found by Fsck
+type FsckProblem struct {
+	Layout  string        `json:"layout"`
+	Digest  digest.Digest `json:"digest,omitempty"`
+	Problem string        `json:"problem"`
+}
+
+// FsckReport lists what Fsck checked and the problems it found
+type FsckReport struct {
+	Layouts  int           `json:"layouts"`
+	Blobs    int           `json:"blobs"`
+	Problems []FsckProblem `json:"problems"`
+}
+
+// Fsck checks every layout of the repository. The content of all blobs has to match their digest and every blob
+// reachable from the index of a layout has to exist. Problems are reported, errors only if the repository cannot be read.
+func (r *Repository) Fsck() (*FsckReport, error) {
+	names, err := r.ListImageLayouts()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{Problems: make([]FsckProblem, 0)}
+	for _, name := range names {
+		report.Layouts++
+		problem := func(d digest.Digest, format string, args ...interface{}) {
+			report.Problems = append(report.Problems, FsckProblem{Layout: name, Digest: d, Problem: fmt.Sprintf(format, args...)})
+		}
+
+		layout, err := r.OpenImageLayout(name)
+		if err != nil {
+			problem("", "layout cannot be opened: %v", err)
+			continue
+		}
+
+		err = layout.blobs.Walk(func(d digest.Digest, size int64) error {
+			report.Blobs++
+			rd, err := layout.blobs.Reader(d)
+			if err != nil {
+				problem(d, "blob cannot be read: %v", err)
+				return nil
+			}
+			defer rd.Close()
+
+			actual, err := d.Algorithm().FromReader(rd)
+			if err != nil {
+				problem(d, "blob cannot be read: %v", err)
+			} else if actual != d {
+				problem(d, "content has digest %s", actual)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, &Error{Op: "fsck", Path: name, Err: err}
+		}
+
+		reachable, err := layout.reachableBlobs()
+		if err != nil {
+			problem("", "manifests cannot be read: %v", err)
+			continue
+		}
+
+		missing := make([]digest.Digest, 0)
+		for d := range reachable {
+			if !layout.HasBlob(d) {
+				missing = append(missing, d)
+			}
+		}
+		sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+		for _, d := range missing {
+			problem(d, "referenced blob is missing")
+		}
+	}
+
+	return report, nil
+}
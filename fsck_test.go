@@ -0,0 +1,47 @@
+package oci
+
+import (
+	"errors"
+	"path"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestFsck() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"a": "a"}), specsv1.History{CreatedBy: "a"}))
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"b": "b"}), specsv1.History{CreatedBy: "b"}))
+	require.NoError(suite.T(), layout.SaveImage(img))
+	require.NoError(suite.T(), layout.Close())
+
+	report, err := repo.Fsck()
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, report.Layouts)
+	assert.Equal(suite.T(), 4, report.Blobs)
+	assert.Empty(suite.T(), report.Problems)
+
+	layers := img.Manifest().Layers
+	require.NoError(suite.T(), afero.WriteFile(suite.fs, path.Join("test", "testing", blobPath(layers[0].Digest)), []byte("corrupt"), 0644))
+	require.NoError(suite.T(), suite.fs.Remove(path.Join("test", "testing", blobPath(layers[1].Digest))))
+
+	report, err = repo.Fsck()
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), report.Problems, 2)
+	assert.Equal(suite.T(), layers[0].Digest, report.Problems[0].Digest)
+	assert.Contains(suite.T(), report.Problems[0].Problem, "content has digest")
+	assert.Equal(suite.T(), FsckProblem{Layout: "testing", Digest: layers[1].Digest, Problem: "referenced blob is missing"}, report.Problems[1])
+
+	require.NoError(suite.T(), repo.RemoveImageLayout("testing"))
+	assert.False(suite.T(), repo.HasImageLayout("testing"))
+	assert.True(suite.T(), errors.Is(repo.RemoveImageLayout("testing"), ErrLayoutNotFound))
+	_, err = repo.OpenImageLayout("testing")
+	assert.True(suite.T(), errors.Is(err, ErrLayoutNotFound))
+}
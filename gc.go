@@ -0,0 +1,163 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// GCResult reports the blobs removed by a garbage collection
+type GCResult struct {
+	// Removed are the digests of all removed blobs
+	Removed []digest.Digest `json:"removed"`
+	// Freed is the number of bytes no longer stored. Links to shared blobs only count once the shared blob is removed.
+	Freed int64 `json:"freed"`
+}
+
+// GC removes the blobs of every layout which are not reachable from the index of the layout. Blobs of the shared
+// store, either the one of EnableSharedBlobs or the one set with SetBlobStore, are removed once no layout of the
+// repository references them. The indexes are read from disk thus close layouts before so their changes are seen.
+func (r *Repository) GC() (*GCResult, error) {
+	names, err := r.ListImageLayouts()
+	if err != nil {
+		return nil, err
+	}
+
+	var shared BlobStore
+	switch {
+	case r.blobs != nil:
+		shared = r.blobs
+	case r.sharedBlobs:
+		shared = NewFsBlobStore(r.fs)
+	}
+
+	referenced := make(map[digest.Digest]bool)
+	removed := make(map[digest.Digest]bool)
+	result := &GCResult{}
+
+	for _, name := range names {
+		layout, err := r.OpenImageLayout(name)
+		if err != nil {
+			return nil, err
+		}
+
+		reachable, err := layout.reachableBlobs()
+		if err != nil {
+			return nil, &Error{Op: "gc", Path: name, Err: err}
+		}
+		for d := range reachable {
+			referenced[d] = true
+		}
+
+		// Layouts of a store set for the repository have no blobs of their own
+		if r.blobs != nil {
+			continue
+		}
+
+		garbage, err := r.collectBlobs(layout.blobs, reachable, name)
+		if err != nil {
+			return nil, err
+		}
+		// Links to shared blobs only free space once the shared blob is removed
+		if shared == nil {
+			for d, size := range garbage {
+				removed[d] = true
+				result.Freed += size
+			}
+		}
+	}
+
+	if shared != nil {
+		garbage, err := r.collectBlobs(shared, referenced, "shared store")
+		if err != nil {
+			return nil, err
+		}
+		for d, size := range garbage {
+			removed[d] = true
+			result.Freed += size
+		}
+	}
+
+	for d := range removed {
+		result.Removed = append(result.Removed, d)
+	}
+	sort.Slice(result.Removed, func(i, j int) bool { return result.Removed[i] < result.Removed[j] })
+
+	return result, nil
+}
+
+// collectBlobs deletes the blobs of store which are not referenced and returns their sizes
+func (r *Repository) collectBlobs(store BlobStore, referenced map[digest.Digest]bool, owner string) (map[digest.Digest]int64, error) {
+	garbage := make(map[digest.Digest]int64)
+	err := store.Walk(func(d digest.Digest, size int64) error {
+		if !referenced[d] {
+			garbage[d] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for d := range garbage {
+		r.logger.Debugf("removing blob %s of %s", d, owner)
+		if err := store.Delete(d); err != nil {
+			return nil, blobError("gc", d, err)
+		}
+	}
+
+	return garbage, nil
+}
+
+// reachableBlobs returns the digests of all blobs referenced directly or indirectly by the index of the layout.
+// Manifests which are missing are skipped.
+func (layout *ImageLayout) reachableBlobs() (map[digest.Digest]bool, error) {
+	reachable := make(map[digest.Digest]bool)
+	pending := manifestDigests(layout.index.Manifests)
+
+	for len(pending) > 0 {
+		d := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[d] {
+			continue
+		}
+		reachable[d] = true
+
+		rd, err := layout.OpenBlob(d)
+		if errors.Is(err, ErrBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var links manifestLinks
+		err = json.NewDecoder(rd).Decode(&links)
+		rd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s cannot be read: %w", d, err)
+		}
+
+		if links.Config != nil {
+			reachable[links.Config.Digest] = true
+		}
+		for _, layer := range links.Layers {
+			reachable[layer.Digest] = true
+		}
+		pending = append(pending, manifestDigests(links.Manifests)...)
+	}
+
+	return reachable, nil
+}
+
+func manifestDigests(descriptors []specsv1.Descriptor) []digest.Digest {
+	digests := make([]digest.Digest, 0, len(descriptors))
+	for _, descr := range descriptors {
+		digests = append(digests, descr.Digest)
+	}
+	return digests
+}
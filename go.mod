@@ -1,13 +1,18 @@
 module github.com/toasterson/oci
 
-go 1.15
+go 1.18
 
 require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/opencontainers/go-digest v1.0.0
-	github.com/opencontainers/image-spec v1.0.1
-	github.com/sirupsen/logrus v1.7.0
+	github.com/opencontainers/image-spec v1.1.0
 	github.com/spf13/afero v1.5.1
-	github.com/stretchr/testify v1.4.0
-	github.com/ztrue/tracerr v0.3.0
+	github.com/stretchr/testify v1.7.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/text v0.3.3 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
@@ -1,7 +1,9 @@
 package oci
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -16,21 +19,45 @@ import (
 )
 
 type Image struct {
-	manifest specsv1.Manifest
-	Config   specsv1.Image
-	fs       afero.Fs
+	manifest      specsv1.Manifest
+	Config        specsv1.Image
+	blobs         BlobStore
+	recordSources bool
+	sources       []BuildSource
+	buildStarted  time.Time
+	progress      ProgressReporter
+	logger        Logger
+}
+
+// Manifest returns the manifest of the image as it would be written by Close
+func (img *Image) Manifest() specsv1.Manifest {
+	return img.manifest
+}
+
+// SetLogger makes the image log the entries of layers it adds or extracts to logger
+func (img *Image) SetLogger(logger Logger) {
+	img.logger = orNop(logger)
+}
+
+// SetProgress reports the progress of layers being added or extracted to reporter
+func (img *Image) SetProgress(reporter ProgressReporter) {
+	img.progress = reporter
 }
 
 func (img *Image) AddAnnotation(key, value string) {
 	img.manifest.Annotations[key] = value
 }
 
+// AddMetadata stores data as a blob and records its digest in the manifest annotation label.
+//
+// Deprecated: other tools do not understand this convention. Use an artifact whose subject is the manifest
+// of the image instead, see ImageLayout.CreateArtifact and ImageLayout.Referrers.
 func (img *Image) AddMetadata(label, mediaType string, data interface{}) error {
 	if label == "" {
 		panic(fmt.Errorf("programmer error label for metadata not set"))
 	}
 
-	descF, err := NewDescriptorWriterFs(img.fs, "/", mediaType, digest.Canonical, nil)
+	descF, err := NewDescriptorWriter(img.blobs, mediaType, digest.Canonical, nil)
 	if err != nil {
 		return err
 	}
@@ -50,12 +77,14 @@ func (img *Image) AddMetadata(label, mediaType string, data interface{}) error {
 }
 
 // Note this function assumes that you have downloaded the additional blobs from the registry
+//
+// Deprecated: see AddMetadata
 func (img *Image) GetMetadata(manifestDigest digest.Digest, label string, targetDataPtr interface{}) error {
 	if label == "" {
 		panic(fmt.Errorf("programmer error label for metadata not set: cannot read empty string as metadata"))
 	}
 
-	rd, err := NewDescriptorReaderFsDigest(img.fs, digest.Digest(img.manifest.Annotations[label]))
+	rd, err := NewDescriptorReader(img.blobs, specsv1.Descriptor{Digest: digest.Digest(img.manifest.Annotations[label])})
 	if err != nil {
 		return err
 	}
@@ -64,7 +93,7 @@ func (img *Image) GetMetadata(manifestDigest digest.Digest, label string, target
 }
 
 func (img *Image) SaveConfig(alg digest.Algorithm) error {
-	descWr, err := NewDescriptorWriterFs(img.fs, "/", specsv1.MediaTypeImageConfig, alg, &specsv1.Platform{
+	descWr, err := NewDescriptorWriter(img.blobs, specsv1.MediaTypeImageConfig, alg, &specsv1.Platform{
 		OS:           runtime.GOOS,
 		Architecture: runtime.GOARCH,
 	})
@@ -87,48 +116,72 @@ func (img *Image) SaveConfig(alg digest.Algorithm) error {
 	return nil
 }
 
+// AddLayerDescriptors appends uncompressed layers whose blobs are in the layout already on top of the image.
+// Their digests are used as diff IDs, use AppendLayerDescriptors for compressed layers.
 func (img *Image) AddLayerDescriptors(l []specsv1.Descriptor) {
-	img.manifest.Layers = append(l, img.manifest.Layers...)
 	for _, descr := range l {
+		img.manifest.Layers = append(img.manifest.Layers, descr)
 		img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, descr.Digest)
+		img.Config.History = append(img.Config.History, specsv1.History{Comment: "layer " + descr.Digest.String()})
 	}
 }
 
 func (img *Image) AddLayerFile(origPath, mediaType string, h specsv1.History) error {
+	return img.AddLayerFileContext(context.Background(), origPath, mediaType, h)
+}
+
+// AddLayerFileContext adds the layer archive origPath like AddLayerFile and stops copying it once ctx is done
+func (img *Image) AddLayerFileContext(ctx context.Context, origPath, mediaType string, h specsv1.History) error {
 	f, err := os.Open(origPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	descWr, err := NewDescriptorWriterFs(img.fs, "/", mediaType, digest.Canonical, nil)
+	descWr, err := NewDescriptorWriter(img.blobs, mediaType, digest.Canonical, nil)
 	if err != nil {
 		return err
 	}
+	descWr.SetProgress(img.progress)
 
-	_, err = io.Copy(descWr, f)
+	_, err = io.Copy(descWr, &contextReader{ctx: ctx, r: f})
 	if err != nil {
-		return err
+		_ = descWr.Abort()
+		return contextErr(ctx, err)
 	}
 	descr, err := descWr.Close()
 	if err != nil {
 		return err
 	}
 
+	diffID, err := layerDiffID(img.blobs, descr)
+	if err != nil {
+		return err
+	}
+
 	img.manifest.Layers = append(img.manifest.Layers, descr)
-	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, descr.Digest)
+	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, diffID)
 	img.Config.History = append(img.Config.History, h)
 
 	return nil
 }
 
 func (img *Image) AddTree(rPath string, h specsv1.History) error {
-	layer, err := img.NewLayerWriter(digest.Canonical)
+	return img.AddTreeContext(context.Background(), rPath, h)
+}
+
+// AddTreeContext adds the tree rPath as layer like AddTree. Once ctx is done the layer is discarded
+// and the error of ctx is returned.
+func (img *Image) AddTreeContext(ctx context.Context, rPath string, h specsv1.History) error {
+	layer, err := img.NewLayerWriterContext(ctx, digest.Canonical)
 	if err != nil {
 		return err
 	}
 
 	err = filepath.Walk(rPath, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		//Ignore /
 		if path == rPath {
 			return nil
@@ -138,7 +191,8 @@ func (img *Image) AddTree(rPath string, h specsv1.History) error {
 	})
 
 	if err != nil {
-		return err
+		_ = layer.Abort()
+		return contextErr(ctx, err)
 	}
 
 	descr, err := layer.Close()
@@ -146,113 +200,233 @@ func (img *Image) AddTree(rPath string, h specsv1.History) error {
 		return err
 	}
 
+	if err := img.recordSource(afero.NewOsFs(), rPath, descr.Digest); err != nil {
+		return err
+	}
+
 	img.manifest.Layers = append(img.manifest.Layers, descr)
-	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, descr.Digest)
+	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, layer.DiffID())
 	img.Config.History = append(img.Config.History, h)
 
 	return nil
 }
 
+// DiffOptions controls how AddDiffWithOptions decides whether a file changed
+type DiffOptions struct {
+	// HashOnly compares the content of every regular file. By default files with equal size and
+	// modification time are assumed to be unchanged and only the others are hashed.
+	HashOnly bool
+}
+
+// AddDiff adds a layer with the changes from the tree layer1RootPath of layerFs1 to the tree layer2RootPath of layerFs2
 func (img *Image) AddDiff(layerFs1 afero.Fs, layerFs2 afero.Fs, layer1RootPath, layer2RootPath string, h specsv1.History) error {
-	layer, err := img.NewLayerWriter(digest.Canonical)
+	return img.AddDiffWithOptionsContext(context.Background(), layerFs1, layerFs2, layer1RootPath, layer2RootPath, h, DiffOptions{})
+}
+
+// AddDiffContext adds the changes between two trees like AddDiff until ctx is done
+func (img *Image) AddDiffContext(ctx context.Context, layerFs1 afero.Fs, layerFs2 afero.Fs, layer1RootPath, layer2RootPath string, h specsv1.History) error {
+	return img.AddDiffWithOptionsContext(ctx, layerFs1, layerFs2, layer1RootPath, layer2RootPath, h, DiffOptions{})
+}
+
+// AddDiffWithOptions adds a layer with the changes from the tree layer1RootPath of layerFs1 to the tree layer2RootPath
+// of layerFs2. Deleted paths and paths whose type changed are whited out, new and changed paths are added from layerFs2.
+// Symlinks are only compared and added if the filesystems are an afero.Lstater and afero.LinkReader.
+func (img *Image) AddDiffWithOptions(layerFs1 afero.Fs, layerFs2 afero.Fs, layer1RootPath, layer2RootPath string, h specsv1.History, opts DiffOptions) error {
+	return img.AddDiffWithOptionsContext(context.Background(), layerFs1, layerFs2, layer1RootPath, layer2RootPath, h, opts)
+}
+
+// AddDiffWithOptionsContext is AddDiffWithOptions which discards the layer and returns the error of ctx once it is done
+func (img *Image) AddDiffWithOptionsContext(ctx context.Context, layerFs1 afero.Fs, layerFs2 afero.Fs, layer1RootPath, layer2RootPath string, h specsv1.History, opts DiffOptions) error {
+	layer, err := img.NewLayerWriterContext(ctx, digest.Canonical)
 	if err != nil {
 		return err
 	}
 
+	// Whiteouts are written before any entry as they only apply to lower layers
+	changed := make(map[string]bool)
 	err = afero.Walk(layerFs1, layer1RootPath, func(path string, info os.FileInfo, err error) error {
-		//Do nothing with the root directory of the layer
-		inImagePath := strings.Replace(path, layer1RootPath+"/", "", -1)
-		if path == inImagePath {
-			return nil
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		//First check if file has been deleted
-		l2Path := strings.Replace(path, layer1RootPath, layer2RootPath, -1)
-		newstat, err := os.Lstat(l2Path)
-		if os.IsNotExist(err) {
-			//This means we whiteout the file in the new Layer
-			return layer.AddEntry(path, inImagePath, info, true)
+		inImagePath, err := filepath.Rel(layer1RootPath, path)
+		if err != nil || inImagePath == "." {
+			return err
 		}
 
-		//Check if we have a difference
-		switch info.Mode() & os.ModeType {
-		case os.ModeSocket, os.ModeNamedPipe, os.ModeSticky, os.ModeExclusive:
-			return nil
-		case os.ModeSymlink:
-			//We have a Symlink thus Create it on the Target
-			l1dstTarget, _ := os.Readlink(path)
-			l2dstTarget, _ := os.Readlink(l2Path)
-			if l2dstTarget != l1dstTarget {
-				if err = layer.AddEntry(path, inImagePath, info, false); err != nil {
-					return err
-				}
+		newInfo, err := lstatFs(layerFs2, filepath.Join(layer2RootPath, inImagePath))
+		if err != nil && !os.IsNotExist(err) && !isNotDir(err) {
+			return err
+		}
+
+		if newInfo == nil || newInfo.Mode()&os.ModeType != info.Mode()&os.ModeType {
+			if err := layer.Whiteout(inImagePath); err != nil {
+				return err
+			}
+			if newInfo != nil {
+				changed[inImagePath] = true
 			}
-		default:
-			l1md := info.Mode() & os.ModePerm
-			l2md := newstat.Mode() & os.ModePerm
-			l1Stat := info.Sys().(*syscall.Stat_t)
-			l2Stat := info.Sys().(*syscall.Stat_t)
-			if l1md != l2md || l1Stat.Uid != l2Stat.Uid || l1Stat.Gid != l2Stat.Gid || info.Size() != newstat.Size() {
-				if err = layer.AddEntry(path, inImagePath, info, false); err != nil {
-					return err
-				}
+			// The whiteout covers everything below a deleted or replaced directory
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
+		isChanged, err := fileChanged(layerFs1, layerFs2, path, filepath.Join(layer2RootPath, inImagePath), info, newInfo, opts)
+		if err != nil {
+			return err
+		}
+		if isChanged {
+			changed[inImagePath] = true
 		}
+
 		return nil
 	})
-
 	if err != nil {
-		return err
+		_ = layer.Abort()
+		return contextErr(ctx, err)
 	}
 
-	// Check for new Files only
 	err = afero.Walk(layerFs2, layer2RootPath, func(path string, info os.FileInfo, err error) error {
-		//Do nothing with the root directory of the layer
-		inImagePath := strings.Replace(path, layer2RootPath+"/", "", -1)
-		if path == inImagePath {
-			return nil
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		inImagePath, err := filepath.Rel(layer2RootPath, path)
+		if err != nil || inImagePath == "." {
+			return err
 		}
 
-		l1Path := strings.Replace(path, layer2RootPath, layer1RootPath, -1)
-		_, err = os.Lstat(l1Path)
-		//Check if the file does not exist in the previous layer
-		if os.IsNotExist(err) {
-			// Add new file to the layer
-			if err = layer.AddEntry(path, inImagePath, info, false); err != nil {
+		if !changed[inImagePath] {
+			// Paths below new or replaced directories are also missing in the old tree
+			_, err := lstatFs(layerFs1, filepath.Join(layer1RootPath, inImagePath))
+			if err == nil {
+				return nil
+			}
+			if !os.IsNotExist(err) && !isNotDir(err) {
 				return err
 			}
 		}
-		return nil
-	})
 
+		return layer.AddEntryFs(layerFs2, path, inImagePath, info)
+	})
 	if err != nil {
-		return err
+		_ = layer.Abort()
+		return contextErr(ctx, err)
 	}
 
 	descr, err := layer.Close()
 	if err != nil {
 		return err
 	}
+
+	if err := img.recordSource(layerFs2, layer2RootPath, descr.Digest); err != nil {
+		return err
+	}
+
 	img.manifest.Layers = append(img.manifest.Layers, descr)
-	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, descr.Digest)
+	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, layer.DiffID())
 	img.Config.History = append(img.Config.History, h)
 
 	return nil
 }
 
+// fileChanged compares two paths of the same type
+func fileChanged(fs1, fs2 afero.Fs, path1, path2 string, info1, info2 os.FileInfo, opts DiffOptions) (bool, error) {
+	if info1.Mode() != info2.Mode() {
+		return true, nil
+	}
+
+	st1, ok1 := info1.Sys().(*syscall.Stat_t)
+	st2, ok2 := info2.Sys().(*syscall.Stat_t)
+	if ok1 && ok2 && (st1.Uid != st2.Uid || st1.Gid != st2.Gid || st1.Rdev != st2.Rdev) {
+		return true, nil
+	}
+
+	switch info1.Mode() & os.ModeType {
+	case 0:
+		if info1.Size() != info2.Size() {
+			return true, nil
+		}
+		if !opts.HashOnly && info1.ModTime().Equal(info2.ModTime()) {
+			return false, nil
+		}
+
+		d1, err := hashFileFs(fs1, path1)
+		if err != nil {
+			return false, err
+		}
+		d2, err := hashFileFs(fs2, path2)
+		if err != nil {
+			return false, err
+		}
+		return d1 != d2, nil
+	case os.ModeSymlink:
+		target1, err := readlinkFs(fs1, path1)
+		if err != nil {
+			return false, err
+		}
+		target2, err := readlinkFs(fs2, path2)
+		if err != nil {
+			return false, err
+		}
+		return target1 != target2, nil
+	}
+
+	return false, nil
+}
+
+func hashFileFs(fs afero.Fs, path string) (digest.Digest, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return digest.Canonical.FromReader(f)
+}
+
+// lstatFs stats path without following a symlink if the filesystem supports it
+func lstatFs(fs afero.Fs, path string) (os.FileInfo, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(path)
+		return info, err
+	}
+	return fs.Stat(path)
+}
+
+func isNotDir(err error) bool {
+	return errors.Is(err, syscall.ENOTDIR)
+}
+
 func (img *Image) ExtractInto(targetFs afero.Fs, rootPath string) error {
+	return img.ExtractIntoContext(context.Background(), targetFs, rootPath)
+}
+
+// ExtractIntoContext extracts the layers of the image like ExtractInto and stops with the error of ctx once it is done.
+// The layers extracted so far are kept in targetFs.
+func (img *Image) ExtractIntoContext(ctx context.Context, targetFs afero.Fs, rootPath string) error {
 	if rootPath != "" && rootPath != "/" {
 		targetFs = afero.NewBasePathFs(targetFs, rootPath)
 	}
 
 	for _, layerDescr := range img.manifest.Layers {
-		layerReader, err := NewLayerReader(img.fs, layerDescr)
+		layerReader, err := NewLayerReader(img.blobs, layerDescr)
 		if err != nil {
 			return err
 		}
-		err = layerReader.ExtractTreeInto(targetFs)
+		layerReader.SetProgress(img.progress)
+		layerReader.SetLogger(img.logger)
+		err = layerReader.ExtractTreeIntoContext(ctx, targetFs)
 		if err != nil {
+			layerReader.Close()
 			return err
 		}
 		err = layerReader.Close()
@@ -272,7 +446,7 @@ func (img *Image) Close() (specsv1.Descriptor, error) {
 	}
 
 	// Save manifest to disk
-	descWr, err := NewDescriptorWriterFs(img.fs, "/", specsv1.MediaTypeImageManifest, digest.Canonical, &specsv1.Platform{
+	descWr, err := NewDescriptorWriter(img.blobs, specsv1.MediaTypeImageManifest, digest.Canonical, &specsv1.Platform{
 		OS:           runtime.GOOS,
 		Architecture: runtime.GOARCH,
 	})
@@ -2,9 +2,13 @@ package oci
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"time"
 
 	"github.com/opencontainers/go-digest"
@@ -22,46 +26,58 @@ type ImageLayout struct {
 	layout specsv1.ImageLayout
 	index  specsv1.Index
 	fs     afero.Fs
+	blobs  BlobStore
+	logger Logger
 }
 
 func openImageLayout(repoFs afero.Fs, name string) (*ImageLayout, error) {
 	indexFile, err := repoFs.Open(filepath.Join(name, imageIndexEntrypointFileName))
 	if err != nil {
-		return nil, fmt.Errorf("index.json of image %s cannot be opened: %w", name, err)
+		return nil, layoutError(name, imageIndexEntrypointFileName, err)
 	}
 	defer indexFile.Close()
 
 	img := ImageLayout{
-		fs: afero.NewBasePathFs(repoFs, name),
+		fs:     afero.NewBasePathFs(repoFs, filepath.Join("/", name)),
+		logger: nopLogger{},
 	}
+	img.blobs = NewFsBlobStore(img.fs)
 
 	// Check if index is the proper object
 	if err := json.NewDecoder(indexFile).Decode(&img.index); err != nil {
-		return nil, fmt.Errorf("index.json of image %s cannot be opened: %w", name, err)
+		return nil, layoutError(name, imageIndexEntrypointFileName, fmt.Errorf("%w: %v", ErrInvalidLayout, err))
 	}
 
-	layoutFile, err := repoFs.Open(filepath.Join(name, imageIndexEntrypointFileName))
+	layoutFile, err := repoFs.Open(filepath.Join(name, specsv1.ImageLayoutFile))
 	if err != nil {
-		return nil, fmt.Errorf("%s of image %s cannot be opened: %w", specsv1.ImageLayoutFile, name, err)
+		return nil, layoutError(name, specsv1.ImageLayoutFile, err)
 	}
 	defer layoutFile.Close()
 
-	// Check if index is the proper object
+	// Check if layout is the proper object
 	if err := json.NewDecoder(layoutFile).Decode(&img.layout); err != nil {
-		return nil, fmt.Errorf("%s of image %s cannot be opened: %w", specsv1.ImageLayoutFile, name, err)
+		return nil, layoutError(name, specsv1.ImageLayoutFile, fmt.Errorf("%w: %v", ErrInvalidLayout, err))
 	}
 
 	if exists, err := afero.DirExists(repoFs, filepath.Join(name, blobsDirectory)); err != nil {
-		return nil, err
+		return nil, layoutError(name, blobsDirectory, err)
 	} else {
 		if !exists {
-			return nil, fmt.Errorf("blobs directory of image %s does not exist", name)
+			return nil, layoutError(name, blobsDirectory, ErrInvalidLayout)
 		}
 	}
 
 	return &img, nil
 }
 
+// layoutError reports a file of the layout name which cannot be used. Missing files make the layout invalid.
+func layoutError(name, file string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		err = ErrInvalidLayout
+	}
+	return &Error{Op: "open layout", Path: path.Join(name, file), Err: err}
+}
+
 func createImageLayout(repoFs afero.Fs, name string) (*ImageLayout, error) {
 	for _, path := range []string{
 		name,
@@ -73,7 +89,7 @@ func createImageLayout(repoFs afero.Fs, name string) (*ImageLayout, error) {
 			return nil, err
 		} else {
 			if exists {
-				return nil, fmt.Errorf("image %s already exists", name)
+				return nil, &Error{Op: "create layout", Path: name, Err: ErrLayoutExists}
 			}
 		}
 	}
@@ -81,10 +97,12 @@ func createImageLayout(repoFs afero.Fs, name string) (*ImageLayout, error) {
 	img := ImageLayout{
 		layout: specsv1.ImageLayout{Version: specsv1.ImageLayoutVersion},
 		index:  specsv1.Index{Versioned: specs.Versioned{SchemaVersion: 2}},
-		fs:     afero.NewBasePathFs(repoFs, name),
+		fs:     afero.NewBasePathFs(repoFs, filepath.Join("/", name)),
+		logger: nopLogger{},
 	}
+	img.blobs = NewFsBlobStore(img.fs)
 
-	if err := repoFs.Mkdir(name, 0755); err != nil {
+	if err := repoFs.MkdirAll(name, 0755); err != nil {
 		return nil, err
 	}
 
@@ -114,27 +132,76 @@ func createImageLayout(repoFs afero.Fs, name string) (*ImageLayout, error) {
 }
 
 func (layout *ImageLayout) OpenImage(reference string) (*Image, error) {
-	panic("Implement me")
+	descr, err := layout.Resolve(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if descr.MediaType != "" && descr.MediaType != specsv1.MediaTypeImageManifest {
+		return nil, &Error{Op: "open image", Reference: reference, Digest: descr.Digest, Err: fmt.Errorf("%w: %s", ErrNotImage, descr.MediaType)}
+	}
+
+	img := &Image{
+		blobs:  layout.blobs,
+		logger: layout.logger,
+	}
+
+	manifestReader, err := NewDescriptorReader(layout.blobs, descr)
+	if err != nil {
+		return nil, err
+	}
+	defer manifestReader.Close()
+
+	if err := manifestReader.Decode(&img.manifest); err != nil {
+		return nil, &Error{Op: "read manifest", Reference: reference, Digest: descr.Digest, Err: err}
+	}
+
+	if img.manifest.Annotations == nil {
+		img.manifest.Annotations = make(map[string]string)
+	}
+
+	configReader, err := NewDescriptorReader(layout.blobs, img.manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer configReader.Close()
+
+	if err := configReader.Decode(&img.Config); err != nil {
+		return nil, &Error{Op: "read config", Reference: reference, Digest: img.manifest.Config.Digest, Err: err}
+	}
+
+	return img, nil
 }
 
 func (layout *ImageLayout) CreateImage(reference string) *Image {
+	img := NewImage(layout.blobs, reference)
+	img.logger = layout.logger
+	return img
+}
+
+// NewImage starts an empty image whose blobs are written to store. Use CreateImage for images of a layout.
+func NewImage(store BlobStore, reference string) *Image {
 	t := time.Now()
 	return &Image{
-		fs: layout.fs,
+		blobs:  store,
+		logger: nopLogger{},
 		manifest: specsv1.Manifest{
 			Versioned: specs.Versioned{
 				SchemaVersion: 2,
 			},
+			MediaType: specsv1.MediaTypeImageManifest,
 			Annotations: map[string]string{
 				specsv1.AnnotationRefName: reference,
 			},
 			Layers: make([]specsv1.Descriptor, 0),
 		},
 		Config: specsv1.Image{
-			Created:      &t,
-			Architecture: runtime.GOARCH,
-			OS:           runtime.GOOS,
-			History:      make([]specsv1.History, 0),
+			Created: &t,
+			Platform: specsv1.Platform{
+				Architecture: runtime.GOARCH,
+				OS:           runtime.GOOS,
+			},
+			History: make([]specsv1.History, 0),
 			RootFS: specsv1.RootFS{
 				Type:    "rootfs",
 				DiffIDs: make([]digest.Digest, 0),
@@ -143,16 +210,136 @@ func (layout *ImageLayout) CreateImage(reference string) *Image {
 	}
 }
 func (layout *ImageLayout) AddAnnotation(key, value string) {
+	if layout.index.Annotations == nil {
+		layout.index.Annotations = make(map[string]string)
+	}
 	layout.index.Annotations[key] = value
 }
 
+// Manifests returns the descriptors currently referenced by the index of the layout
+func (layout *ImageLayout) Manifests() []specsv1.Descriptor {
+	return append([]specsv1.Descriptor(nil), layout.index.Manifests...)
+}
+
+// Resolve looks up a descriptor in the index of the layout. The reference can either be
+// the value of the org.opencontainers.image.ref.name annotation or the digest of the manifest
+func (layout *ImageLayout) Resolve(reference string) (specsv1.Descriptor, error) {
+	for _, descr := range layout.index.Manifests {
+		if descr.Annotations[specsv1.AnnotationRefName] == reference || descr.Digest.String() == reference {
+			return descr, nil
+		}
+	}
+
+	return specsv1.Descriptor{}, &Error{Op: "resolve", Reference: reference, Err: ErrImageNotFound}
+}
+
+// AddManifest adds a descriptor to the index of the layout. A descriptor already in the index
+// carrying the same reference name is replaced. Without reference name a descriptor of the same digest
+// without reference name is replaced.
+func (layout *ImageLayout) AddManifest(descr specsv1.Descriptor) {
+	refName, ok := descr.Annotations[specsv1.AnnotationRefName]
+	for i, existing := range layout.index.Manifests {
+		existingRefName, existingOk := existing.Annotations[specsv1.AnnotationRefName]
+		if (ok && existingRefName == refName) || (!ok && !existingOk && existing.Digest == descr.Digest) {
+			layout.index.Manifests[i] = descr
+			return
+		}
+	}
+
+	layout.index.Manifests = append(layout.index.Manifests, descr)
+}
+
+// Tags returns the reference names of all manifests in the index of the layout
+func (layout *ImageLayout) Tags() []string {
+	tags := make([]string, 0)
+	for _, descr := range layout.index.Manifests {
+		if refName, ok := descr.Annotations[specsv1.AnnotationRefName]; ok {
+			tags = append(tags, refName)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// RemoveManifest removes all descriptors from the index which match the reference name or digest.
+// The blobs stay in the layout. Returns false if nothing matched.
+func (layout *ImageLayout) RemoveManifest(reference string) bool {
+	manifests := layout.index.Manifests[:0]
+	for _, descr := range layout.index.Manifests {
+		if descr.Annotations[specsv1.AnnotationRefName] == reference || descr.Digest.String() == reference {
+			continue
+		}
+		manifests = append(manifests, descr)
+	}
+
+	removed := len(manifests) != len(layout.index.Manifests)
+	layout.index.Manifests = manifests
+	return removed
+}
+
+// HasBlob checks if the blob with the given digest is present in the blobs directory of the layout
+func (layout *ImageLayout) HasBlob(d digest.Digest) bool {
+	if d.Validate() != nil {
+		return false
+	}
+
+	exists, err := blobExists(layout.blobs, d)
+	if err != nil {
+		return false
+	}
+
+	return exists
+}
+
+// OpenBlob opens the blob with the given digest for reading
+func (layout *ImageLayout) OpenBlob(d digest.Digest) (*DescriptorReader, error) {
+	return NewDescriptorReader(layout.blobs, specsv1.Descriptor{Digest: d})
+}
+
+// NewBlobWriter creates a DescriptorWriter which stores its content in the blob store of the layout
+func (layout *ImageLayout) NewBlobWriter(mediaType string, alg digest.Algorithm) (*DescriptorWriter, error) {
+	return NewDescriptorWriter(layout.blobs, mediaType, alg, nil)
+}
+
+// NewResumableBlobWriter creates a DescriptorWriter for the blob described by descr whose temporary file survives
+// Suspend. Calling it again for the same blob continues with the content written so far.
+// Layouts with another BlobStore than their blobs directory get a writer which cannot be resumed.
+func (layout *ImageLayout) NewResumableBlobWriter(descr specsv1.Descriptor) (*DescriptorWriter, error) {
+	if err := descr.Digest.Validate(); err != nil {
+		return nil, err
+	}
+
+	store, ok := layout.blobs.(*fsBlobStore)
+	if !ok {
+		return NewDescriptorWriter(layout.blobs, descr.MediaType, descr.Digest.Algorithm(), nil)
+	}
+
+	name := fmt.Sprintf(".partial.%s.%s", descr.Digest.Algorithm(), descr.Digest.Encoded())
+	return ResumeDescriptorWriterFs(store.fs, name, descr.MediaType, descr.Digest.Algorithm())
+}
+
+// SetBlobStore makes the layout keep its blobs in store instead of its blobs directory. The index stays in the layout.
+func (layout *ImageLayout) SetBlobStore(store BlobStore) {
+	layout.blobs = store
+}
+
+// SetLogger makes the layout and the images opened or created afterwards log to logger. Nothing is logged by default.
+func (layout *ImageLayout) SetLogger(logger Logger) {
+	layout.logger = orNop(logger)
+}
+
+// BlobStore returns the store the layout keeps its blobs in
+func (layout *ImageLayout) BlobStore() BlobStore {
+	return layout.blobs
+}
+
 func (layout *ImageLayout) SaveImage(img *Image) error {
 	descr, err := img.Close()
 	if err != nil {
 		return err
 	}
 
-	layout.index.Manifests = append(layout.index.Manifests, descr)
+	layout.AddManifest(descr)
 
 	return nil
 }
@@ -162,6 +349,7 @@ func (layout *ImageLayout) Close() error {
 	if err != nil {
 		return err
 	}
+	defer indexFile.Close()
 
 	return json.NewEncoder(indexFile).Encode(layout.index)
 }
@@ -0,0 +1,88 @@
+package oci
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// layerEntries returns the type of every entry of the last layer of the image by name
+func (suite *OCITestSuite) layerEntries(img *Image) map[string]byte {
+	entries := make(map[string]byte)
+	require.NoError(suite.T(), img.walkLayer(len(img.manifest.Layers)-1, func(layer int, hdr *tar.Header, r io.Reader) error {
+		entries[CleanEntryName(hdr.Name)] = hdr.Typeflag
+		return nil
+	}))
+	return entries
+}
+
+func (suite *OCITestSuite) TestAddDiff() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"a": "aaaa", "same": "same", "touched": "touched", "t": "file", "u/x": "x", "gone/y": "y"})
+	require.NoError(suite.T(), os.Symlink("a", filepath.Join(lower, "l")))
+	upper := suite.writeTree(map[string]string{"a": "bbbb", "same": "same", "touched": "touched", "t/y": "y", "u": "file", "h1": "linked"})
+	require.NoError(suite.T(), os.Symlink("same", filepath.Join(upper, "l")))
+	require.NoError(suite.T(), os.Link(filepath.Join(upper, "h1"), filepath.Join(upper, "h2")))
+
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"same", "a"} {
+		require.NoError(suite.T(), os.Chtimes(filepath.Join(lower, name), past, past))
+		require.NoError(suite.T(), os.Chtimes(filepath.Join(upper, name), past, past))
+	}
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddDiff(afero.NewOsFs(), afero.NewOsFs(), lower, upper, specsv1.History{CreatedBy: "diff"}))
+	entries := suite.layerEntries(img)
+	assert.Equal(suite.T(), map[string]byte{
+		".wh.t":    tar.TypeReg,
+		".wh.u":    tar.TypeReg,
+		".wh.gone": tar.TypeReg,
+		"t":        tar.TypeDir,
+		"t/y":      tar.TypeReg,
+		"u":        tar.TypeReg,
+		"l":        tar.TypeSymlink,
+		"h1":       tar.TypeReg,
+		"h2":       tar.TypeLink,
+	}, entries)
+
+	// Equal size and modification time hide the change of a unless the content is hashed
+	require.NoError(suite.T(), img.AddDiffWithOptions(afero.NewOsFs(), afero.NewOsFs(), lower, upper, specsv1.History{CreatedBy: "diff"}, DiffOptions{HashOnly: true}))
+	entries = suite.layerEntries(img)
+	assert.Equal(suite.T(), byte(tar.TypeReg), entries["a"])
+	_, ok := entries["same"]
+	assert.False(suite.T(), ok)
+	_, ok = entries["touched"]
+	assert.False(suite.T(), ok)
+}
+
+func (suite *OCITestSuite) TestAddDiffUsesGivenFs() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	layerFs := afero.NewMemMapFs()
+	require.NoError(suite.T(), afero.WriteFile(layerFs, "/old/keep", []byte("keep"), 0644))
+	require.NoError(suite.T(), afero.WriteFile(layerFs, "/old/gone", []byte("gone"), 0644))
+	require.NoError(suite.T(), afero.WriteFile(layerFs, "/new/keep", []byte("kept"), 0644))
+	require.NoError(suite.T(), afero.WriteFile(layerFs, "/new/added", []byte("added"), 0644))
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddDiff(layerFs, layerFs, "/old", "/new", specsv1.History{CreatedBy: "diff"}))
+	assert.Equal(suite.T(), map[string]byte{
+		".wh.gone": tar.TypeReg,
+		"keep":     tar.TypeReg,
+		"added":    tar.TypeReg,
+	}, suite.layerEntries(img))
+}
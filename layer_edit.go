@@ -0,0 +1,191 @@
+package oci
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Reasons why a layer depends on a path of a removed or replaced layer
+const (
+	DependencyWhiteout = "whiteout"
+	DependencyModified = "modified"
+	DependencyHardlink = "hardlink"
+)
+
+// LayerDependency is a path of a removed or replaced layer which a later layer relies on. Such a layer may
+// whiteout a path which no longer exists, change a file which is now added or link to a file which is gone.
+type LayerDependency struct {
+	// Layer is the index of the depending layer in the changed image
+	Layer  int
+	Path   string
+	Reason string
+}
+
+// RemoveLayer removes the layer at index together with its history entry if it has one. The returned dependencies
+// warn about later layers relying on files of the removed layer. Save the image to write the new manifest.
+func (img *Image) RemoveLayer(index int) ([]LayerDependency, error) {
+	if index < 0 || index >= len(img.manifest.Layers) {
+		return nil, fmt.Errorf("cannot remove layer %d of image with %d layers", index, len(img.manifest.Layers))
+	}
+
+	removed, err := img.layerPaths(index, img.manifest.Layers[index])
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies, err := img.layerDependencies(index, removed)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dependencies {
+		dependencies[i].Layer--
+	}
+
+	if h := img.layerHistoryIndices()[index]; h >= 0 {
+		img.Config.History = append(img.Config.History[:h:h], img.Config.History[h+1:]...)
+	}
+	img.manifest.Layers = append(img.manifest.Layers[:index:index], img.manifest.Layers[index+1:]...)
+	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs[:index:index], img.Config.RootFS.DiffIDs[index+1:]...)
+
+	return dependencies, nil
+}
+
+// ReplaceLayer replaces the layer at index with the layer descr whose blob has to be in the layout already.
+// The history entry of the layer is replaced by h, layers without history entry get none. The returned dependencies
+// warn about later layers relying on files of the old layer which the new one does not contain. Save the image to
+// write the new manifest.
+func (img *Image) ReplaceLayer(index int, descr specsv1.Descriptor, h specsv1.History) ([]LayerDependency, error) {
+	if index < 0 || index >= len(img.manifest.Layers) {
+		return nil, fmt.Errorf("cannot replace layer %d of image with %d layers", index, len(img.manifest.Layers))
+	}
+
+	diffID, err := layerDiffID(img.blobs, descr)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := img.layerPaths(index, img.manifest.Layers[index])
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := img.layerPaths(index, descr)
+	if err != nil {
+		return nil, err
+	}
+	for name := range added {
+		delete(removed, name)
+	}
+
+	dependencies, err := img.layerDependencies(index, removed)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := img.layerHistoryIndices()[index]; i >= 0 {
+		h.EmptyLayer = false
+		img.Config.History[i] = h
+	}
+	img.manifest.Layers[index] = descr
+	img.Config.RootFS.DiffIDs[index] = diffID
+
+	return dependencies, nil
+}
+
+// AppendLayerDescriptors appends layers whose blobs are in the layout already on top of the image. The diff IDs
+// are computed from the blobs and every layer gets a history entry naming its digest.
+func (img *Image) AppendLayerDescriptors(l []specsv1.Descriptor) error {
+	for _, descr := range l {
+		diffID, err := layerDiffID(img.blobs, descr)
+		if err != nil {
+			return err
+		}
+
+		img.manifest.Layers = append(img.manifest.Layers, descr)
+		img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, diffID)
+		img.Config.History = append(img.Config.History, specsv1.History{Comment: "layer " + descr.Digest.String()})
+	}
+
+	return nil
+}
+
+// layerPaths returns the paths a layer adds and whether they are directories
+func (img *Image) layerPaths(index int, layer specsv1.Descriptor) (map[string]bool, error) {
+	paths := make(map[string]bool)
+	err := img.walkLayerBlob(index, layer, func(_ int, hdr *tar.Header, _ io.Reader) error {
+		if _, _, ok := WhiteoutTarget(hdr.Name); !ok {
+			paths[CleanEntryName(hdr.Name)] = hdr.Typeflag == tar.TypeDir
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// layerDependencies finds the entries of the layers above index which refer to the removed paths
+func (img *Image) layerDependencies(index int, removed map[string]bool) ([]LayerDependency, error) {
+	var dependencies []LayerDependency
+	if len(removed) == 0 {
+		return dependencies, nil
+	}
+
+	for i := index + 1; i < len(img.manifest.Layers); i++ {
+		found := make(map[string]string)
+		err := img.walkLayer(i, func(_ int, hdr *tar.Header, _ io.Reader) error {
+			name := CleanEntryName(hdr.Name)
+			if target, opaque, ok := WhiteoutTarget(name); ok {
+				// An opaque directory or a whiteout of a directory hides the removed paths below it
+				if containsPath(removed, target, opaque) {
+					found[target] = DependencyWhiteout
+				}
+				return nil
+			}
+
+			if hdr.Typeflag == tar.TypeLink {
+				if _, ok := removed[CleanEntryName(hdr.Linkname)]; ok {
+					found[name] = DependencyHardlink
+				}
+				return nil
+			}
+
+			// Directories are repeated in many layers without depending on each other
+			if isDir, ok := removed[name]; ok && !isDir && hdr.Typeflag != tar.TypeDir {
+				found[name] = DependencyModified
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(found))
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dependencies = append(dependencies, LayerDependency{Layer: i, Path: name, Reason: found[name]})
+		}
+	}
+
+	return dependencies, nil
+}
+
+// containsPath reports whether a path below name or unless onlyBelow name itself is in paths, the empty name is the root
+func containsPath(paths map[string]bool, name string, onlyBelow bool) bool {
+	if _, ok := paths[name]; ok && !onlyBelow {
+		return true
+	}
+
+	prefix := name + "/"
+	for p := range paths {
+		if (name == "" && p != "") || strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
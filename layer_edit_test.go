@@ -0,0 +1,147 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestRemoveAndReplaceLayer() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	base := suite.writeTree(map[string]string{"etc/": "", "bin/sh": "sh"})
+	secrets := suite.writeTree(map[string]string{"etc/": "", "etc/credentials": "secret", "etc/app.conf": "conf", "cache/x": "x"})
+	cleaned := suite.writeTree(map[string]string{"etc/": "", "etc/app.conf": "conf"})
+	top := suite.writeTree(map[string]string{"etc/": "", "etc/app.conf": "changed", "bin/sh": "sh2"})
+	require.NoError(suite.T(), os.Link(filepath.Join(top, "bin", "sh"), filepath.Join(top, "bin", "sh-link")))
+	deleted := suite.writeTree(map[string]string{"etc/": "", "etc/app.conf": "changed"})
+
+	create := func(name string) *Image {
+		img := layout.CreateImage(name)
+		require.NoError(suite.T(), img.AddTree(base, specsv1.History{CreatedBy: "base"}))
+		img.SetEnv("A", "1")
+		require.NoError(suite.T(), img.AddTree(secrets, specsv1.History{CreatedBy: "secrets"}))
+		require.NoError(suite.T(), img.AddTree(top, specsv1.History{CreatedBy: "top"}))
+		// Deletes cache and the credentials
+		require.NoError(suite.T(), img.AddDiff(afero.NewOsFs(), afero.NewOsFs(), secrets, deleted, specsv1.History{CreatedBy: "cleanup"}))
+		return img
+	}
+
+	img := create("remove")
+	_, err = img.RemoveLayer(4)
+	assert.Error(suite.T(), err)
+
+	dependencies, err := img.RemoveLayer(1)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []LayerDependency{
+		{Layer: 1, Path: "etc/app.conf", Reason: DependencyModified},
+		{Layer: 2, Path: "cache", Reason: DependencyWhiteout},
+		{Layer: 2, Path: "etc/app.conf", Reason: DependencyModified},
+		{Layer: 2, Path: "etc/credentials", Reason: DependencyWhiteout},
+	}, dependencies)
+	require.Len(suite.T(), img.manifest.Layers, 3)
+	require.Len(suite.T(), img.Config.RootFS.DiffIDs, 3)
+	createdBy := make([]string, 0, len(img.Config.History))
+	for _, h := range img.Config.History {
+		createdBy = append(createdBy, h.CreatedBy)
+	}
+	assert.Equal(suite.T(), []string{"base", "ENV A=1", "top", "cleanup"}, createdBy)
+
+	// The patched layer still has app.conf, only the deleted files are missing
+	img = create("replace")
+	patched := layout.CreateImage("patched")
+	require.NoError(suite.T(), patched.AddTree(cleaned, specsv1.History{CreatedBy: "cleaned"}))
+	dependencies, err = img.ReplaceLayer(1, patched.manifest.Layers[0], specsv1.History{CreatedBy: "cleaned"})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []LayerDependency{
+		{Layer: 3, Path: "cache", Reason: DependencyWhiteout},
+		{Layer: 3, Path: "etc/credentials", Reason: DependencyWhiteout},
+	}, dependencies)
+	assert.Equal(suite.T(), patched.manifest.Layers[0], img.manifest.Layers[1])
+	assert.Equal(suite.T(), patched.Config.RootFS.DiffIDs[0], img.Config.RootFS.DiffIDs[1])
+	assert.Equal(suite.T(), "cleaned", img.Config.History[2].CreatedBy)
+
+	mergedFs, err := img.Fs()
+	require.NoError(suite.T(), err)
+	_, err = mergedFs.Stat("/etc/credentials")
+	assert.True(suite.T(), os.IsNotExist(err))
+
+	// Hardlinks to files of the removed layer break
+	img = create("hardlink")
+	dependencies, err = img.RemoveLayer(0)
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), dependencies, LayerDependency{Layer: 1, Path: "bin/sh-link", Reason: DependencyHardlink})
+	assert.Contains(suite.T(), dependencies, LayerDependency{Layer: 1, Path: "bin/sh", Reason: DependencyModified})
+	assert.Equal(suite.T(), "ENV A=1", img.Config.History[0].CreatedBy)
+
+	// Appended layers keep the order and get a history entry each
+	img = layout.CreateImage("descriptors")
+	require.NoError(suite.T(), img.AddTree(base, specsv1.History{CreatedBy: "base"}))
+	require.NoError(suite.T(), img.AppendLayerDescriptors(patched.manifest.Layers))
+	assert.Equal(suite.T(), patched.manifest.Layers[0], img.manifest.Layers[1])
+	assert.Equal(suite.T(), patched.Config.RootFS.DiffIDs[0], img.Config.RootFS.DiffIDs[1])
+	assert.Equal(suite.T(), []int{0, 1}, img.layerHistoryIndices())
+
+	img.AddLayerDescriptors(img.manifest.Layers[:1])
+	assert.Equal(suite.T(), img.manifest.Layers[0], img.manifest.Layers[2])
+	assert.Equal(suite.T(), img.manifest.Layers[0].Digest, img.Config.RootFS.DiffIDs[2])
+	assert.Equal(suite.T(), []int{0, 1, 2}, img.layerHistoryIndices())
+}
+
+func (suite *OCITestSuite) TestEditLayersWithoutHistory() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	create := func(name string) *Image {
+		img := layout.CreateImage(name)
+		for _, content := range []string{"a", "b", "c"} {
+			dir := suite.writeTree(map[string]string{content: content})
+			require.NoError(suite.T(), img.AddTree(dir, specsv1.History{CreatedBy: content}))
+		}
+		return img
+	}
+	createdBy := func(img *Image) []string {
+		names := make([]string, 0, len(img.Config.History))
+		for _, h := range img.Config.History {
+			names = append(names, h.CreatedBy)
+		}
+		return names
+	}
+
+	// Without any history the layers are edited alone
+	img := create("missing")
+	img.Config.History = nil
+	_, err = img.RemoveLayer(0)
+	require.NoError(suite.T(), err)
+	replacement := img.manifest.Layers[0]
+	_, err = img.ReplaceLayer(1, replacement, specsv1.History{CreatedBy: "replaced"})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), img.Squash(0, 2))
+	assert.Len(suite.T(), img.manifest.Layers, 1)
+	assert.Len(suite.T(), img.Config.RootFS.DiffIDs, 1)
+	assert.Empty(suite.T(), img.Config.History)
+
+	// History ending early keeps the entries of the layers it covers
+	img = create("short")
+	img.Config.History = img.Config.History[:2]
+	assert.Equal(suite.T(), []int{0, 1, -1}, img.layerHistoryIndices())
+	_, err = img.ReplaceLayer(2, replacement, specsv1.History{CreatedBy: "replaced"})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"a", "b"}, createdBy(img))
+	require.NoError(suite.T(), img.Squash(1, 3))
+	require.Len(suite.T(), img.manifest.Layers, 2)
+	assert.Equal(suite.T(), []int{0, 2}, img.layerHistoryIndices())
+	_, err = img.RemoveLayer(0)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []int{1}, img.layerHistoryIndices())
+	assert.Equal(suite.T(), "b", createdBy(img)[0])
+}
@@ -2,6 +2,8 @@ package oci
 
 import (
 	"archive/tar"
+	"compress/gzip"
+	"context"
 	"strings"
 
 	"github.com/opencontainers/go-digest"
@@ -14,34 +16,87 @@ type LayerReader struct {
 	archiveReader *TarReader
 }
 
-func NewLayerReader(fs afero.Fs, layer specsv1.Descriptor) (*LayerReader, error) {
-	l := &LayerReader{
-		digest: layer.Digest,
-	}
+// SetLogger makes the reader log the entries it extracts to logger
+func (l *LayerReader) SetLogger(logger Logger) {
+	l.archiveReader.SetLogger(logger)
+}
+
+// SetProgress reports the extracted entries and the bytes read of the layer blob to reporter
+func (l *LayerReader) SetProgress(reporter ProgressReporter) {
+	l.archiveReader.SetProgress(reporter)
+}
 
-	var compressor ArchiveCompressor
-	if strings.Contains(layer.MediaType, "gzip") || strings.Contains(layer.MediaType, "gz") {
-		compressor = ArchiveCompressorGzip
-	} else {
-		compressor = ArchiveCompressorNone
+// NewLayerReader opens the archive of the layer in store
+func NewLayerReader(store BlobStore, layer specsv1.Descriptor) (*LayerReader, error) {
+	blob, err := store.Reader(layer.Digest)
+	if err != nil {
+		return nil, blobError("open layer", layer.Digest, err)
 	}
 
-	var err error
-	l.archiveReader, err = NewTarReader(compressor, afero.NewBasePathFs(fs, l.digest.Algorithm().String()), l.digest.Encoded())
+	archiveReader, err := newTarReader(layerCompressor(layer.MediaType), blob)
 	if err != nil {
+		blob.Close()
 		return nil, err
 	}
-	return l, nil
+
+	archiveReader.progress.event.Digest = layer.Digest
+	archiveReader.progress.event.Total = layer.Size
+
+	return &LayerReader{digest: layer.Digest, archiveReader: archiveReader}, nil
+}
+
+// NewLayerReaderFs opens the archive of the layer in the image layout at the root of fs
+func NewLayerReaderFs(fs afero.Fs, layer specsv1.Descriptor) (*LayerReader, error) {
+	return NewLayerReader(NewFsBlobStore(fs), layer)
+}
+
+// layerCompressor determines the compression of a layer from its media type
+func layerCompressor(mediaType string) ArchiveCompressor {
+	if strings.Contains(mediaType, "gzip") || strings.Contains(mediaType, "gz") {
+		return ArchiveCompressorGzip
+	}
+	return ArchiveCompressorNone
+}
+
+// layerDiffID computes the digest of the uncompressed archive of a layer in store
+func layerDiffID(store BlobStore, layer specsv1.Descriptor) (digest.Digest, error) {
+	if layerCompressor(layer.MediaType) == ArchiveCompressorNone {
+		return layer.Digest, nil
+	}
+
+	f, err := store.Reader(layer.Digest)
+	if err != nil {
+		return "", blobError("open layer", layer.Digest, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	return layer.Digest.Algorithm().FromReader(gz)
 }
 
 func (l *LayerReader) ExtractTreeInto(targetFs afero.Fs) error {
 	return l.archiveReader.ExtractTreeInto(targetFs)
 }
 
+// ExtractTreeIntoContext extracts the layer into targetFs until ctx is done
+func (l *LayerReader) ExtractTreeIntoContext(ctx context.Context, targetFs afero.Fs) error {
+	return l.archiveReader.ExtractTreeIntoContext(ctx, targetFs)
+}
+
 func (l *LayerReader) Next() (*tar.Header, error) {
 	return l.archiveReader.Next()
 }
 
+// Read reads the content of the current entry
+func (l *LayerReader) Read(p []byte) (int, error) {
+	return l.archiveReader.Read(p)
+}
+
 func (l *LayerReader) Close() error {
 	return l.archiveReader.Close()
 }
@@ -1,7 +1,12 @@
 package oci
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/opencontainers/go-digest"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -13,35 +18,103 @@ const LayerTempfilePrefix = ".oci.newlayer"
 type LayerWriter struct {
 	algorithm     digest.Algorithm
 	descF         *DescriptorWriter
+	gzipWriter    *gzip.Writer
+	diffID        digest.Digester
 	archiveWriter *TarWriter
-	fs            afero.Fs
+	ctx           context.Context
 }
 
 func (img *Image) NewLayerWriter(algorithm digest.Algorithm) (l *LayerWriter, err error) {
+	return img.NewLayerWriterContext(context.Background(), algorithm)
+}
+
+// NewLayerWriterContext creates a LayerWriter which fails to add entries once ctx is done.
+// Close aborts the layer in that case.
+func (img *Image) NewLayerWriterContext(ctx context.Context, algorithm digest.Algorithm) (l *LayerWriter, err error) {
 	l = &LayerWriter{
-		fs: img.fs,
+		algorithm: algorithm,
+		ctx:       ctx,
 	}
 
-	descWr, err := NewDescriptorWriterFs(img.fs, "/", specsv1.MediaTypeImageLayerGzip, algorithm, nil)
+	descWr, err := NewDescriptorWriter(img.blobs, specsv1.MediaTypeImageLayerGzip, algorithm, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	l.descF = descWr
 
-	l.archiveWriter, err = NewTarWriter(ArchiveCompressorGzip, descWr)
+	// The DiffID of a layer is the digest of the uncompressed archive thus we compress here
+	// and let the archive writer write into the compressor and the digester at the same time
+	if !algorithm.Available() {
+		algorithm = digest.Canonical
+	}
+	l.diffID = algorithm.Digester()
+	l.gzipWriter = gzip.NewWriter(descWr)
+
+	l.archiveWriter, err = NewTarWriter(ArchiveCompressorNone, &contextWriter{ctx: ctx, w: io.MultiWriter(l.gzipWriter, l.diffID.Hash())})
+	if err != nil {
+		_ = descWr.Abort()
+		return nil, err
+	}
+	l.SetProgress(img.progress)
+	l.archiveWriter.SetLogger(img.logger)
+
 	return l, nil
 }
 
+// Close finishes the layer and stores it as a blob. If the context of the writer is done the layer is aborted
+// and the error of the context is returned.
 func (l *LayerWriter) Close() (specsv1.Descriptor, error) {
+	if err := l.ctx.Err(); err != nil {
+		_ = l.Abort()
+		return specsv1.Descriptor{}, err
+	}
+
 	if err := l.archiveWriter.Close(); err != nil {
+		_ = l.Abort()
+		return specsv1.Descriptor{}, contextErr(l.ctx, err)
+	}
+
+	if err := l.gzipWriter.Close(); err != nil {
+		_ = l.descF.Abort()
 		return specsv1.Descriptor{}, err
 	}
 
 	return l.descF.Close()
 }
 
+// Abort discards the layer and removes its temporary file
+func (l *LayerWriter) Abort() error {
+	_ = l.gzipWriter.Close()
+	return l.descF.Abort()
+}
+
+// SetProgress reports every entry and the bytes of their uncompressed content to reporter
+func (l *LayerWriter) SetProgress(reporter ProgressReporter) {
+	l.archiveWriter.SetProgress(reporter)
+}
+
+// DiffID returns the digest of the uncompressed layer archive. It is only valid after Close.
+func (l *LayerWriter) DiffID() digest.Digest {
+	return l.diffID.Digest()
+}
+
 // Add a new File into the Layer Archive
 func (l *LayerWriter) AddEntry(realPath string, inImagePath string, info os.FileInfo, whiteout bool) (err error) {
 	return l.archiveWriter.AddEntry(realPath, inImagePath, info, whiteout)
 }
+
+// AddEntryFs adds the file realPath of fs into the Layer Archive
+func (l *LayerWriter) AddEntryFs(fs afero.Fs, realPath string, inImagePath string, info os.FileInfo) error {
+	return l.archiveWriter.AddEntryFs(fs, realPath, inImagePath, info, false)
+}
+
+// CopyEntry adds an entry read from another layer with its content from r
+func (l *LayerWriter) CopyEntry(hdr *tar.Header, r io.Reader) error {
+	return l.archiveWriter.CopyEntry(hdr, r)
+}
+
+// Whiteout adds a whiteout entry which deletes inImagePath of lower layers of any type
+func (l *LayerWriter) Whiteout(inImagePath string) error {
+	return l.archiveWriter.WhiteoutFile(strings.TrimPrefix(inImagePath, "/"))
+}
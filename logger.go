@@ -0,0 +1,25 @@
+package oci
+
+// Logger receives the diagnostic messages of the library. It is satisfied by the loggers of logrus and zap's
+// SugaredLogger among others. Nothing is logged unless a logger is set on the Repository or ImageLayout.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// orNop returns logger or a logger which discards everything if it is nil
+func orNop(logger Logger) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	return logger
+}
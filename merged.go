@@ -0,0 +1,294 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// WhiteoutPrefix marks an entry which deletes the file of the same name without prefix from lower layers
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir marks the directory it is in as opaque which hides all lower layer contents of the directory
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// LayerWalkFunc is called for every entry of a layer. The reader returns the content of the entry.
+type LayerWalkFunc func(layer int, hdr *tar.Header, r io.Reader) error
+
+// MergedWalkFunc is called for every entry visible in the merged filesystem of an image.
+// Entry names are cleaned and relative to the root of the image.
+type MergedWalkFunc func(hdr *tar.Header, r io.Reader) error
+
+// WalkLayers calls fn for every entry of every layer in the order of the manifest
+func (img *Image) WalkLayers(fn LayerWalkFunc) error {
+	for i := range img.manifest.Layers {
+		if err := img.walkLayer(i, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (img *Image) walkLayer(index int, fn LayerWalkFunc) error {
+	return img.walkLayerBlob(index, img.manifest.Layers[index], fn)
+}
+
+// walkLayerBlob is walkLayer for a layer blob which is not necessarily part of the manifest yet
+func (img *Image) walkLayerBlob(index int, layer specsv1.Descriptor, fn LayerWalkFunc) error {
+	layerReader, err := NewLayerReader(img.blobs, layer)
+	if err != nil {
+		return err
+	}
+	defer layerReader.Close()
+
+	for {
+		hdr, err := layerReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(index, hdr, layerReader); err != nil {
+			return err
+		}
+	}
+}
+
+// WalkMerged calls fn for every entry of the merged filesystem of the image, that is the union of all layers
+// with whiteouts applied. Entries are visited in layer order. Whiteout entries themselves are not visited.
+func (img *Image) WalkMerged(fn MergedWalkFunc) error {
+	return img.walkMergedRange(0, len(img.manifest.Layers), fn)
+}
+
+// entryLocation identifies an entry by the layer it is in and its position in the archive
+type entryLocation struct {
+	layer int
+	entry int
+}
+
+// layerEntry is an entry of a layer archive together with the offset of its content in the uncompressed archive
+type layerEntry struct {
+	hdr    *tar.Header
+	offset int64
+}
+
+// indexLayer reads the headers of all entries of a layer without keeping their content
+func (img *Image) indexLayer(index int) ([]layerEntry, error) {
+	layer := img.manifest.Layers[index]
+	f, err := img.blobs.Reader(layer.Digest)
+	if err != nil {
+		return nil, blobError("open layer", layer.Digest, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if layerCompressor(layer.MediaType) == ArchiveCompressorGzip {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// The tar reader consumes exactly the header blocks thus the position after Next is the start of the content
+	counter := &countingReader{r: r}
+	archive := tar.NewReader(counter)
+	entries := make([]layerEntry, 0)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %s cannot be read: %w", layer.Digest, err)
+		}
+		entries = append(entries, layerEntry{hdr: hdr, offset: counter.n})
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// mergedIndex computes which entry of the layers from..to-1 provides each path of the merged filesystem.
+// The returned whiteouts are the whiteout entries whose targets are not provided by the layers in the range.
+func (img *Image) mergedIndex(from, to int) (map[string]entryLocation, map[string]entryLocation, error) {
+	visible, whiteouts, _, err := img.mergedIndexEntries(from, to)
+	return visible, whiteouts, err
+}
+
+// mergedIndexEntries is mergedIndex which additionally returns the entries of every layer in the range
+func (img *Image) mergedIndexEntries(from, to int) (map[string]entryLocation, map[string]entryLocation, [][]layerEntry, error) {
+	tree := newMergedTree()
+	layers := make([][]layerEntry, 0, to-from)
+
+	for i := from; i < to; i++ {
+		layerEntries, err := img.indexLayer(i)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		layers = append(layers, layerEntries)
+		tree.add(i, layerEntries)
+	}
+
+	return tree.visible, tree.whiteouts, layers, nil
+}
+
+// mergedTree is a merged index which knows the children of every directory. Whiteouts and files replacing
+// directories thus remove a subtree without looking at the other paths.
+type mergedTree struct {
+	visible   map[string]entryLocation
+	whiteouts map[string]entryLocation
+	// children holds the names below each directory including directories without entry of their own
+	children map[string]map[string]struct{}
+}
+
+func newMergedTree() *mergedTree {
+	return &mergedTree{
+		visible:   make(map[string]entryLocation),
+		whiteouts: make(map[string]entryLocation),
+		children:  make(map[string]map[string]struct{}),
+	}
+}
+
+// add applies the entries of the layer with the given index to the merged index below it
+func (t *mergedTree) add(layer int, layerEntries []layerEntry) {
+	// Whiteouts only affect lower layers thus they are applied before the entries of their own layer
+	for entry, e := range layerEntries {
+		name := CleanEntryName(e.hdr.Name)
+		target, opaque, ok := WhiteoutTarget(name)
+		if name == "" || !ok {
+			continue
+		}
+
+		if !opaque {
+			delete(t.visible, target)
+		}
+		t.removeChildren(target)
+		t.whiteouts[name] = entryLocation{layer: layer, entry: entry}
+	}
+
+	for entry, e := range layerEntries {
+		name := CleanEntryName(e.hdr.Name)
+		if _, _, ok := WhiteoutTarget(name); name == "" || ok {
+			continue
+		}
+
+		// A file replacing a directory hides everything below it
+		if e.hdr.Typeflag != tar.TypeDir {
+			t.removeChildren(name)
+		}
+		t.set(name, entryLocation{layer: layer, entry: entry})
+	}
+}
+
+// set makes the entry at loc provide name and links name and its parents into the children index
+func (t *mergedTree) set(name string, loc entryLocation) {
+	t.visible[name] = loc
+
+	// Once a name is linked all its parents are linked as well
+	for name != "" {
+		parent := parentPath(name)
+		children, ok := t.children[parent]
+		if !ok {
+			children = make(map[string]struct{})
+			t.children[parent] = children
+		}
+		if _, linked := children[name]; linked {
+			return
+		}
+		children[name] = struct{}{}
+		name = parent
+	}
+}
+
+// removeChildren removes everything below dir, the empty name is the root
+func (t *mergedTree) removeChildren(dir string) {
+	children, ok := t.children[dir]
+	if !ok {
+		return
+	}
+	delete(t.children, dir)
+
+	for name := range children {
+		delete(t.visible, name)
+		t.removeChildren(name)
+	}
+}
+
+// parentPath returns the directory containing a cleaned entry name, the empty name for the root
+func parentPath(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func (img *Image) walkMergedRange(from, to int, fn MergedWalkFunc) error {
+	visible, _, err := img.mergedIndex(from, to)
+	if err != nil {
+		return err
+	}
+
+	for i := from; i < to; i++ {
+		entry := 0
+		err := img.walkLayer(i, func(layer int, hdr *tar.Header, r io.Reader) error {
+			loc := entryLocation{layer: layer, entry: entry}
+			entry++
+
+			name := CleanEntryName(hdr.Name)
+			if found, ok := visible[name]; !ok || found != loc {
+				return nil
+			}
+
+			hdr.Name = name
+			return fn(hdr, r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CleanEntryName normalizes the name of an archive entry to a relative path without trailing slash
+func CleanEntryName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// WhiteoutTarget returns the path a whiteout entry deletes. For opaque directory markers opaque is true and target
+// the directory whose lower contents are hidden. ok is false if the name is no whiteout.
+func WhiteoutTarget(name string) (target string, opaque bool, ok bool) {
+	name = CleanEntryName(name)
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+
+	if base == WhiteoutOpaqueDir {
+		return dir, true, true
+	}
+
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		return path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)), false, true
+	}
+
+	return "", false, false
+}
@@ -0,0 +1,514 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+)
+
+// maxSymlinks limits the number of symlinks followed while resolving a path like the kernel does
+const maxSymlinks = 40
+
+var ErrTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// MergedFs is a read-only afero.Fs presenting the merged filesystem of an image, that is the union of all
+// layers with whiteouts applied. It is built from an index of the headers of all layers. Contents are read
+// directly from uncompressed layers and decompressed only when a file is read from compressed ones.
+type MergedFs struct {
+	blobs    BlobStore
+	layers   []specsv1.Descriptor
+	entries  map[string]*mergedEntry
+	children map[string][]string
+}
+
+// mergedEntry is a path of the merged filesystem. The content is found at offset in the uncompressed archive of layer.
+type mergedEntry struct {
+	hdr    *tar.Header
+	layer  int
+	offset int64
+}
+
+// Fs indexes the layers of the image and returns a read-only filesystem of their merged contents.
+// Layers added to the image afterwards are not part of the returned filesystem.
+func (img *Image) Fs() (*MergedFs, error) {
+	visible, _, layers, err := img.mergedIndexEntries(0, len(img.manifest.Layers))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MergedFs{
+		blobs:    img.blobs,
+		layers:   append([]specsv1.Descriptor(nil), img.manifest.Layers...),
+		entries:  make(map[string]*mergedEntry, len(visible)+1),
+		children: make(map[string][]string),
+	}
+
+	for name, loc := range visible {
+		e := layers[loc.layer][loc.entry]
+		hdr := *e.hdr
+		hdr.Name = name
+		m.entries[name] = &mergedEntry{hdr: &hdr, layer: loc.layer, offset: e.offset}
+	}
+
+	// Hardlinks share the content of their target
+	for _, entry := range m.entries {
+		if entry.hdr.Typeflag != tar.TypeLink {
+			continue
+		}
+		target, ok := m.entries[CleanEntryName(entry.hdr.Linkname)]
+		if !ok || target.hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry.hdr.Typeflag = tar.TypeReg
+		entry.hdr.Size = target.hdr.Size
+		entry.hdr.Mode = target.hdr.Mode
+		entry.layer = target.layer
+		entry.offset = target.offset
+	}
+
+	// Archives do not need to contain the parent directories of their entries
+	for name := range visible {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := m.entries[dir]; ok {
+				break
+			}
+			m.entries[dir] = &mergedEntry{hdr: &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}}
+		}
+	}
+	if _, ok := m.entries[""]; !ok {
+		m.entries[""] = &mergedEntry{hdr: &tar.Header{Name: "/", Typeflag: tar.TypeDir, Mode: 0755}}
+	}
+
+	for name := range m.entries {
+		if name == "" {
+			continue
+		}
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		m.children[dir] = append(m.children[dir], path.Base(name))
+	}
+	for dir := range m.children {
+		sort.Strings(m.children[dir])
+	}
+
+	return m, nil
+}
+
+func (m *MergedFs) Name() string {
+	return "MergedFs"
+}
+
+// resolve looks up the entry of a path following symlinks in all of its components. The last component is
+// only followed if follow is set. Absolute symlinks are resolved relative to the root of the image.
+func (m *MergedFs) resolve(op, name string, follow bool) (string, *mergedEntry, error) {
+	pending := CleanEntryName(name)
+	resolved := ""
+	links := 0
+
+	for pending != "" {
+		component := pending
+		rest := ""
+		if i := strings.IndexByte(pending, '/'); i >= 0 {
+			component, rest = pending[:i], pending[i+1:]
+		}
+
+		current := path.Join(resolved, component)
+		entry, ok := m.entries[current]
+		if !ok {
+			return "", nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+
+		if entry.hdr.Typeflag == tar.TypeSymlink && (rest != "" || follow) {
+			links++
+			if links > maxSymlinks {
+				return "", nil, &os.PathError{Op: op, Path: name, Err: ErrTooManySymlinks}
+			}
+
+			target := entry.hdr.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(resolved, target)
+			}
+			pending = CleanEntryName(path.Join(target, rest))
+			resolved = ""
+			continue
+		}
+
+		if rest != "" && entry.hdr.Typeflag != tar.TypeDir {
+			return "", nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+
+		resolved = current
+		pending = rest
+	}
+
+	return resolved, m.entries[resolved], nil
+}
+
+func (m *MergedFs) Stat(name string) (os.FileInfo, error) {
+	_, entry, err := m.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return entry.hdr.FileInfo(), nil
+}
+
+// LstatIfPossible implements afero.Lstater, symlinks are not followed
+func (m *MergedFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	_, entry, err := m.resolve("lstat", name, false)
+	if err != nil {
+		return nil, true, err
+	}
+	return entry.hdr.FileInfo(), true, nil
+}
+
+// ReadlinkIfPossible implements afero.LinkReader
+func (m *MergedFs) ReadlinkIfPossible(name string) (string, error) {
+	_, entry, err := m.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if entry.hdr.Typeflag != tar.TypeSymlink {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return entry.hdr.Linkname, nil
+}
+
+func (m *MergedFs) Open(name string) (afero.File, error) {
+	resolved, entry, err := m.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mergedFile{fs: m, name: name, path: resolved, entry: entry}, nil
+}
+
+func (m *MergedFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	return m.Open(name)
+}
+
+func (m *MergedFs) Create(name string) (afero.File, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) RemoveAll(path string) error {
+	return &os.PathError{Op: "remove", Path: path, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) Chown(name string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.EPERM}
+}
+
+func (m *MergedFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// mergedFile is an open file of a MergedFs. Its content is only located once it is read.
+type mergedFile struct {
+	fs      *MergedFs
+	name    string
+	path    string
+	entry   *mergedEntry
+	content mergedContent
+	closer  io.Closer
+	dirPos  int
+	closed  bool
+}
+
+// mergedContent is the content of a file of a MergedFs
+type mergedContent interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// open locates the content of the file. Uncompressed layers are read in place, compressed layers are
+// decompressed up to the file and its content is streamed from the decompressor.
+func (f *mergedFile) open() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	if f.content != nil {
+		return nil
+	}
+
+	if f.entry.hdr.Typeflag == tar.TypeDir {
+		return &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if f.entry.hdr.Typeflag != tar.TypeReg && f.entry.hdr.Typeflag != tar.TypeRegA {
+		f.content = bytes.NewReader(nil)
+		return nil
+	}
+
+	layer := f.fs.layers[f.entry.layer]
+	if layerCompressor(layer.MediaType) != ArchiveCompressorNone {
+		content := &streamedContent{fs: f.fs, entry: f.entry}
+		if err := content.openStream(); err != nil {
+			return err
+		}
+		f.content, f.closer = content, content
+		return nil
+	}
+
+	blob, err := f.fs.blobs.Reader(layer.Digest)
+	if err != nil {
+		return err
+	}
+	f.content, f.closer = io.NewSectionReader(blob, f.entry.offset, f.entry.hdr.Size), blob
+
+	return nil
+}
+
+// streamedContent is the content of a file in a compressed layer. Sequential reads are streamed from the
+// decompressor. The stream can neither seek nor read at an offset thus the first Seek or ReadAt spills the
+// content into a temporary file which serves all reads from then on.
+type streamedContent struct {
+	fs    *MergedFs
+	entry *mergedEntry
+
+	blob   BlobReader
+	gz     *gzip.Reader
+	stream io.Reader
+	pos    int64
+	spill  *os.File
+}
+
+// openStream decompresses the layer of the entry up to its content
+func (c *streamedContent) openStream() error {
+	blob, err := c.fs.blobs.Reader(c.fs.layers[c.entry.layer].Digest)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(blob)
+	if err != nil {
+		blob.Close()
+		return err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, gz, c.entry.offset); err != nil {
+		gz.Close()
+		blob.Close()
+		return err
+	}
+
+	c.blob, c.gz, c.pos = blob, gz, 0
+	c.stream = io.LimitReader(gz, c.entry.hdr.Size)
+	return nil
+}
+
+func (c *streamedContent) closeStream() error {
+	if c.gz == nil {
+		return nil
+	}
+	c.gz.Close()
+	err := c.blob.Close()
+	c.blob, c.gz, c.stream = nil, nil, nil
+	return err
+}
+
+// spillToFile copies the whole content into a temporary file positioned where sequential reads stopped
+func (c *streamedContent) spillToFile() error {
+	if c.spill != nil {
+		return nil
+	}
+
+	// The bytes streamed so far are gone thus the content is decompressed once more
+	pos := c.pos
+	if pos > 0 {
+		if err := c.closeStream(); err != nil {
+			return err
+		}
+		if err := c.openStream(); err != nil {
+			return err
+		}
+	}
+
+	spill, err := ioutil.TempFile("", "oci-merged-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(spill, c.stream, c.entry.hdr.Size); err != nil {
+		spill.Close()
+		os.Remove(spill.Name())
+		return err
+	}
+	if _, err := spill.Seek(pos, io.SeekStart); err != nil {
+		spill.Close()
+		os.Remove(spill.Name())
+		return err
+	}
+
+	c.spill = spill
+	return c.closeStream()
+}
+
+func (c *streamedContent) Read(p []byte) (int, error) {
+	if c.spill != nil {
+		return c.spill.Read(p)
+	}
+
+	n, err := c.stream.Read(p)
+	c.pos += int64(n)
+	if err == io.EOF && c.pos < c.entry.hdr.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *streamedContent) ReadAt(p []byte, off int64) (int, error) {
+	if err := c.spillToFile(); err != nil {
+		return 0, err
+	}
+	return c.spill.ReadAt(p, off)
+}
+
+func (c *streamedContent) Seek(offset int64, whence int) (int64, error) {
+	if err := c.spillToFile(); err != nil {
+		return 0, err
+	}
+	return c.spill.Seek(offset, whence)
+}
+
+func (c *streamedContent) Close() error {
+	err := c.closeStream()
+	if c.spill != nil {
+		c.spill.Close()
+		os.Remove(c.spill.Name())
+	}
+	return err
+}
+
+func (f *mergedFile) Name() string {
+	return f.name
+}
+
+func (f *mergedFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	return f.entry.hdr.FileInfo(), nil
+}
+
+func (f *mergedFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.content.Read(p)
+}
+
+func (f *mergedFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.content.ReadAt(p, off)
+}
+
+func (f *mergedFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.content.Seek(offset, whence)
+}
+
+func (f *mergedFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	if f.entry.hdr.Typeflag != tar.TypeDir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+
+	children := f.fs.children[f.path][f.dirPos:]
+	if count > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && len(children) > count {
+		children = children[:count]
+	}
+	f.dirPos += len(children)
+
+	infos := make([]os.FileInfo, 0, len(children))
+	for _, child := range children {
+		infos = append(infos, f.fs.entries[path.Join(f.path, child)].hdr.FileInfo())
+	}
+
+	return infos, nil
+}
+
+func (f *mergedFile) Readdirnames(n int) ([]string, error) {
+	infos, err := f.Readdir(n)
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names, err
+}
+
+func (f *mergedFile) Close() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	f.closed = true
+
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+func (f *mergedFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *mergedFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *mergedFile) WriteString(s string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *mergedFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *mergedFile) Sync() error {
+	return nil
+}
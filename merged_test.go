@@ -0,0 +1,238 @@
+package oci
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTree creates the files in a new temporary directory. Names ending in / are created as directories.
+func (suite *OCITestSuite) writeTree(files map[string]string) string {
+	dir, err := ioutil.TempDir("", "oci-test-tree")
+	require.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if name[len(name)-1] == '/' {
+			require.NoError(suite.T(), os.MkdirAll(p, 0755))
+			continue
+		}
+		require.NoError(suite.T(), os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(suite.T(), ioutil.WriteFile(p, []byte(content), 0644))
+	}
+
+	return dir
+}
+
+func (suite *OCITestSuite) TestWalkMerged() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"a": "a", "dir/b": "b", "dir/c": "c", "gone/x": "x"})
+	upper := suite.writeTree(map[string]string{"a": "a", "dir/b": "b", "dir/d": "d", "gone/": ""})
+	top := suite.writeTree(map[string]string{"a": "changed"})
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+	require.NoError(suite.T(), img.AddDiff(afero.NewOsFs(), afero.NewOsFs(), lower, upper, specsv1.History{CreatedBy: "upper"}))
+	require.NoError(suite.T(), img.AddTree(top, specsv1.History{CreatedBy: "top"}))
+
+	contents := make(map[string]string)
+	err = img.WalkMerged(func(hdr *tar.Header, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		contents[hdr.Name] = string(data)
+		return nil
+	})
+	require.NoError(suite.T(), err)
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(suite.T(), []string{"a", "dir", "dir/b", "dir/d", "gone"}, names)
+	assert.Equal(suite.T(), "changed", contents["a"])
+}
+
+func (suite *OCITestSuite) TestWhiteoutTarget() {
+	target, opaque, ok := WhiteoutTarget("./etc/.wh.passwd")
+	assert.True(suite.T(), ok)
+	assert.False(suite.T(), opaque)
+	assert.Equal(suite.T(), "etc/passwd", target)
+
+	target, opaque, ok = WhiteoutTarget("etc/.wh..wh..opq")
+	assert.True(suite.T(), ok)
+	assert.True(suite.T(), opaque)
+	assert.Equal(suite.T(), "etc", target)
+
+	_, _, ok = WhiteoutTarget("etc/passwd")
+	assert.False(suite.T(), ok)
+}
+
+func (suite *OCITestSuite) TestMergedTree() {
+	entries := func(names map[string]byte) []layerEntry {
+		l := make([]layerEntry, 0, len(names))
+		for name, typ := range names {
+			l = append(l, layerEntry{hdr: &tar.Header{Name: name, Typeflag: typ}})
+		}
+		return l
+	}
+
+	// Layers do not need entries for the parents of their files
+	tree := newMergedTree()
+	tree.add(0, entries(map[string]byte{"a/b/c": tar.TypeReg, "a/d": tar.TypeReg, "e/f": tar.TypeReg, "g/h/i": tar.TypeReg, "g/j": tar.TypeReg}))
+	tree.add(1, entries(map[string]byte{"a/.wh.b": tar.TypeReg, "e": tar.TypeReg, "g/" + WhiteoutOpaqueDir: tar.TypeReg, "g/k": tar.TypeReg}))
+	tree.add(2, entries(map[string]byte{"a/b/x": tar.TypeReg}))
+
+	names := make([]string, 0, len(tree.visible))
+	for name := range tree.visible {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(suite.T(), []string{"a/b/x", "a/d", "e", "g/k"}, names)
+	assert.Len(suite.T(), tree.whiteouts, 2)
+	assert.Contains(suite.T(), tree.whiteouts, "a/"+WhiteoutPrefix+"b")
+	assert.Contains(suite.T(), tree.whiteouts, "g/"+WhiteoutOpaqueDir)
+}
+
+func (suite *OCITestSuite) TestMergedFs() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"etc/os-release": "ID=test\n", "dir/b": "b", "dir/c": "c"})
+	require.NoError(suite.T(), os.Symlink("os-release", filepath.Join(lower, "etc", "link")))
+	require.NoError(suite.T(), os.Symlink("/etc", filepath.Join(lower, "conf")))
+	upper := suite.writeTree(map[string]string{"etc/os-release": "ID=test\n", "dir/b": "b", "etc/link": ""})
+	require.NoError(suite.T(), os.Remove(filepath.Join(upper, "etc", "link")))
+	require.NoError(suite.T(), os.Symlink("os-release", filepath.Join(upper, "etc", "link")))
+	require.NoError(suite.T(), os.Symlink("/etc", filepath.Join(upper, "conf")))
+
+	// An uncompressed layer whose files are read in place
+	tarFile, err := ioutil.TempFile("", "oci-test-layer")
+	require.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { os.Remove(tarFile.Name()) })
+	tw := tar.NewWriter(tarFile)
+	content := "0123456789"
+	require.NoError(suite.T(), tw.WriteHeader(&tar.Header{Name: "data/numbers", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+	_, err = tw.Write([]byte(content))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), tw.Close())
+	require.NoError(suite.T(), tarFile.Close())
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+	require.NoError(suite.T(), img.AddDiff(afero.NewOsFs(), afero.NewOsFs(), lower, upper, specsv1.History{CreatedBy: "upper"}))
+	require.NoError(suite.T(), img.AddLayerFile(tarFile.Name(), specsv1.MediaTypeImageLayer, specsv1.History{CreatedBy: "data"}))
+
+	mergedFs, err := img.Fs()
+	require.NoError(suite.T(), err)
+
+	data, err := afero.ReadFile(mergedFs, "/etc/os-release")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "ID=test\n", string(data))
+
+	data, err = afero.ReadFile(mergedFs, "/conf/link")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "ID=test\n", string(data))
+	target, err := mergedFs.ReadlinkIfPossible("/etc/link")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "os-release", target)
+
+	dir, err := mergedFs.Open("/dir")
+	require.NoError(suite.T(), err)
+	names, err := dir.Readdirnames(-1)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"b"}, names)
+	require.NoError(suite.T(), dir.Close())
+
+	f, err := mergedFs.Open("data/numbers")
+	require.NoError(suite.T(), err)
+	_, err = f.Seek(5, io.SeekStart)
+	require.NoError(suite.T(), err)
+	rest, err := ioutil.ReadAll(f)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "56789", string(rest))
+	require.NoError(suite.T(), f.Close())
+
+	_, err = mergedFs.Stat("/dir/c")
+	assert.True(suite.T(), os.IsNotExist(err))
+	assert.Error(suite.T(), afero.WriteFile(mergedFs, "/etc/os-release", []byte("changed"), 0644))
+
+	files := make([]string, 0)
+	require.NoError(suite.T(), afero.Walk(mergedFs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	}))
+	assert.Equal(suite.T(), []string{"/data/numbers", "/dir/b", "/etc/os-release"}, files)
+}
+
+func (suite *OCITestSuite) TestMergedFsCompressed() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	content := strings.Repeat("0123456789", 10000)
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"a": "a", "data": content, "z": "z"}), specsv1.History{CreatedBy: "compressed"}))
+	require.Equal(suite.T(), specsv1.MediaTypeImageLayerGzip, img.manifest.Layers[0].MediaType)
+
+	mergedFs, err := img.Fs()
+	require.NoError(suite.T(), err)
+
+	// Sequential reads are streamed without buffering the content
+	f, err := mergedFs.Open("/data")
+	require.NoError(suite.T(), err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), content, string(data))
+	assert.Nil(suite.T(), f.(*mergedFile).content.(*streamedContent).spill)
+	require.NoError(suite.T(), f.Close())
+
+	// Seeking after reading spills the whole content
+	f, err = mergedFs.Open("/data")
+	require.NoError(suite.T(), err)
+	head := make([]byte, 15)
+	_, err = io.ReadFull(f, head)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), content[:15], string(head))
+	pos, err := f.Seek(-5, io.SeekCurrent)
+	require.NoError(suite.T(), err)
+	assert.EqualValues(suite.T(), 10, pos)
+	_, err = io.ReadFull(f, head)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), content[10:25], string(head))
+	_, err = f.ReadAt(head, int64(len(content)-15))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), content[len(content)-15:], string(head))
+	spill := f.(*mergedFile).content.(*streamedContent).spill.Name()
+	require.NoError(suite.T(), f.Close())
+	_, err = os.Stat(spill)
+	assert.True(suite.T(), os.IsNotExist(err))
+
+	data, err = afero.ReadFile(mergedFs, "/z")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "z", string(data))
+}
@@ -0,0 +1,59 @@
+package oci
+
+import (
+	"archive/tar"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// Extended attributes overlayfs marks directories with https://docs.kernel.org/filesystems/overlayfs.html
+// Unprivileged mounts use the user namespace instead of the trusted one.
+const (
+	OverlayXattrTrustedPrefix = "trusted.overlay."
+	OverlayXattrUserPrefix    = "user.overlay."
+	overlayXattrOpaque        = "opaque"
+	overlayXattrRedirect      = "redirect"
+	overlayXattrWhiteout      = "whiteout"
+)
+
+var ErrOverlayUnsupported = errors.New("overlayfs is not supported on this platform")
+
+// OpaqueDir adds the marker which hides all contents of dir in lower layers
+func (tarWriter *TarWriter) OpaqueDir(dir string) error {
+	now := time.Now()
+	hdr := tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       path.Join(dir, WhiteoutOpaqueDir),
+		Format:     tar.FormatGNU,
+		Mode:       0644,
+		ModTime:    now,
+		AccessTime: now,
+		ChangeTime: now,
+	}
+	return tarWriter.archiveWriter.WriteHeader(&hdr)
+}
+
+// OpaqueDir adds the marker which hides all contents of inImagePath in lower layers
+func (l *LayerWriter) OpaqueDir(inImagePath string) error {
+	return l.archiveWriter.OpaqueDir(CleanEntryName(inImagePath))
+}
+
+// OverlayDirs are the layers of an image extracted as overlayfs lower directories
+type OverlayDirs struct {
+	// LowerDirs are ordered from the top-most layer to the base layer as expected by the lowerdir mount option
+	LowerDirs []string
+	// UserXattr is set if the attributes are in the user namespace which needs the userxattr mount option
+	UserXattr bool
+}
+
+// MountOptions returns the options to mount the layers read-only with overlayfs. Add upperdir and workdir
+// for a writable mount.
+func (o *OverlayDirs) MountOptions() string {
+	options := "lowerdir=" + strings.Join(o.LowerDirs, ":")
+	if o.UserXattr {
+		options += ",userxattr"
+	}
+	return options
+}
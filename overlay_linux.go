@@ -0,0 +1,454 @@
+package oci
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+)
+
+// AddOverlayUpper adds the upper directory of an overlayfs mount as new layer. The lower directories of the
+// mount are expected to be the layers of the image. Whiteout devices and files carrying the whiteout attribute become
+// whiteouts and opaque directories get an opaque marker. Directories renamed with redirect_dir get the contents of their origin from the image.
+func (img *Image) AddOverlayUpper(upperDir string, h specsv1.History) error {
+	layer, err := img.NewLayerWriter(digest.Canonical)
+	if err != nil {
+		return err
+	}
+
+	// The merged filesystem of the image is only needed to resolve redirects
+	var lower *MergedFs
+
+	err = filepath.Walk(upperDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		inImagePath, err := filepath.Rel(upperDir, p)
+		if err != nil || inImagePath == "." {
+			return err
+		}
+
+		if isOverlayWhiteout(info) || isOverlayWhiteoutFile(p, info) {
+			return layer.Whiteout(inImagePath)
+		}
+
+		if err := layer.AddEntry(p, inImagePath, info, false); err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if value, ok := overlayXattr(p, overlayXattrOpaque); ok && value == "y" {
+			return layer.OpaqueDir(inImagePath)
+		}
+
+		redirect, ok := overlayXattr(p, overlayXattrRedirect)
+		if !ok {
+			return nil
+		}
+
+		// Absolute redirects are relative to the root of the mount, others to the parent directory
+		origin := CleanEntryName(redirect)
+		if !path.IsAbs(redirect) {
+			origin = CleanEntryName(path.Join(path.Dir(filepath.ToSlash(inImagePath)), redirect))
+		}
+
+		if lower == nil {
+			if lower, err = img.Fs(); err != nil {
+				return err
+			}
+		}
+
+		if err := layer.OpaqueDir(inImagePath); err != nil {
+			return err
+		}
+		return addRedirectedDir(layer, lower, origin, upperDir, inImagePath)
+	})
+	if err != nil {
+		return err
+	}
+
+	descr, err := layer.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := img.recordSource(afero.NewOsFs(), upperDir, descr.Digest); err != nil {
+		return err
+	}
+
+	img.manifest.Layers = append(img.manifest.Layers, descr)
+	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, layer.DiffID())
+	img.Config.History = append(img.Config.History, h)
+
+	return nil
+}
+
+// addRedirectedDir adds the contents of origin in the lower layers as inImagePath except for the paths the
+// upper directory provides itself
+func addRedirectedDir(layer *LayerWriter, lower *MergedFs, origin, upperDir, inImagePath string) error {
+	originPath := "/" + origin
+	if _, err := lower.Stat(originPath); err != nil {
+		return fmt.Errorf("origin %s of redirected directory %s: %w", origin, inImagePath, err)
+	}
+
+	return afero.Walk(lower, originPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(originPath, p)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		target := filepath.Join(inImagePath, rel)
+		upperInfo, err := os.Lstat(filepath.Join(upperDir, target))
+		if err != nil && !os.IsNotExist(err) && !isNotDir(err) {
+			return err
+		}
+
+		if upperInfo != nil {
+			// Directories of both are merged unless the upper one hides the lower contents itself
+			if info.IsDir() && upperInfo.IsDir() && overlayMerges(filepath.Join(upperDir, target)) {
+				return nil
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		return layer.AddEntryFs(lower, p, target, info)
+	})
+}
+
+// isOverlayWhiteout reports whether info describes the character device 0/0 overlayfs marks deleted files with
+func isOverlayWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// isOverlayWhiteoutFile reports whether p is a file carrying the whiteout attribute unprivileged overlayfs mounts
+// use in place of whiteout devices
+func isOverlayWhiteoutFile(p string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || info.Size() != 0 {
+		return false
+	}
+	_, ok := overlayXattr(p, overlayXattrWhiteout)
+	return ok
+}
+
+// overlayMerges reports whether the upper directory dir shows the contents of lower directories
+func overlayMerges(dir string) bool {
+	if value, ok := overlayXattr(dir, overlayXattrOpaque); ok && value == "y" {
+		return false
+	}
+	_, redirected := overlayXattr(dir, overlayXattrRedirect)
+	return !redirected
+}
+
+// overlayXattr reads an overlayfs attribute from the trusted namespace falling back to the user namespace
+func overlayXattr(p, name string) (string, bool) {
+	for _, prefix := range []string{OverlayXattrTrustedPrefix, OverlayXattrUserPrefix} {
+		size, err := syscall.Getxattr(p, prefix+name, nil)
+		if err != nil || size <= 0 {
+			continue
+		}
+
+		buf := make([]byte, size)
+		n, err := syscall.Getxattr(p, prefix+name, buf)
+		if err != nil {
+			continue
+		}
+		return string(buf[:n]), true
+	}
+
+	return "", false
+}
+
+// ExtractOverlay extracts the layer into dir as overlayfs lower directory. Whiteouts become whiteout devices and
+// opaque markers the opaque attribute of their directory. Attributes are set in the user namespace if userXattr
+// is set. Without the privilege to create whiteout devices whiteouts are written as files carrying the whiteout
+// attribute which requires userXattr, and device nodes are skipped with a warning.
+func (l *LayerReader) ExtractOverlay(dir string, userXattr bool) error {
+	prefix := OverlayXattrTrustedPrefix
+	if userXattr {
+		prefix = OverlayXattrUserPrefix
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	dirs := make([]*tar.Header, 0)
+	for {
+		hdr, err := l.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := CleanEntryName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		if err := mkdirParents(dir, name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, name)
+
+		if whiteout, opaque, ok := WhiteoutTarget(name); ok {
+			if opaque {
+				if err := os.MkdirAll(filepath.Join(dir, whiteout), 0755); err != nil {
+					return err
+				}
+				if err := syscall.Setxattr(filepath.Join(dir, whiteout), prefix+overlayXattrOpaque, []byte("y"), 0); err != nil {
+					return &os.PathError{Op: "setxattr", Path: whiteout, Err: err}
+				}
+				continue
+			}
+			if err := overlayWhiteout(filepath.Join(dir, whiteout), userXattr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Later entries replace earlier ones like the whiteout of a path whose type changed
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(target, os.FileMode(hdr.Mode).Perm()); err != nil && !os.IsExist(err) {
+				return err
+			}
+			// Directories get their times once their contents are written
+			dirs = append(dirs, hdr)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(target, hdr, l); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// Hardlinks can only refer to files of the same layer, never through its symlinks
+			source := CleanEntryName(hdr.Linkname)
+			if err := checkParents(dir, source); err != nil {
+				return err
+			}
+			if err := os.Link(filepath.Join(dir, source), target); err != nil {
+				return err
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			mode := uint32(hdr.Mode & 07777)
+			switch hdr.Typeflag {
+			case tar.TypeChar:
+				mode |= syscall.S_IFCHR
+			case tar.TypeBlock:
+				mode |= syscall.S_IFBLK
+			default:
+				mode |= syscall.S_IFIFO
+			}
+			dev := int((hdr.Devmajor&0xfff)<<8 | hdr.Devminor&0xff | (hdr.Devminor&^0xff)<<12)
+			if err := syscall.Mknod(target, mode, dev); err != nil {
+				if userXattr && hdr.Typeflag != tar.TypeFifo && (err == syscall.EPERM || err == syscall.EACCES) {
+					l.archiveReader.logger.Warnf("skipping device %s, it cannot be created without privileges", name)
+					continue
+				}
+				return &os.PathError{Op: "mknod", Path: name, Err: err}
+			}
+		default:
+			continue
+		}
+
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !userXattr {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		// Later entries may have replaced the directory or one of its parents with a symlink
+		name := CleanEntryName(dirs[i].Name)
+		if checkParents(dir, name) != nil {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := os.Chmod(target, os.FileMode(dirs[i].Mode).Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, dirs[i].AccessTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExtractOverlay extracts every layer of the image into its own directory below baseDir, named by the digest of
+// the layer, and returns them as lower directories for overlayfs. The trusted attribute namespace is used if
+// the process is privileged enough to set it, otherwise the user namespace.
+func (img *Image) ExtractOverlay(baseDir string) (*OverlayDirs, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, err
+	}
+
+	userXattr, err := overlayNeedsUserXattr(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := &OverlayDirs{
+		LowerDirs: make([]string, len(img.manifest.Layers)),
+		UserXattr: userXattr,
+	}
+
+	for i, layer := range img.manifest.Layers {
+		dir := filepath.Join(baseDir, layer.Digest.Algorithm().String(), layer.Digest.Encoded())
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+
+		layerReader, err := NewLayerReader(img.blobs, layer)
+		if err != nil {
+			return nil, err
+		}
+		layerReader.SetLogger(img.logger)
+		err = layerReader.ExtractOverlay(dir, userXattr)
+		layerReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("layer %s cannot be extracted: %w", layer.Digest, err)
+		}
+
+		dirs.LowerDirs[len(img.manifest.Layers)-1-i] = dir
+	}
+
+	return dirs, nil
+}
+
+// overlayNeedsUserXattr probes whether trusted attributes can be set below dir
+func overlayNeedsUserXattr(dir string) (bool, error) {
+	probe, err := ioutil.TempDir(dir, ".xattr-probe")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(probe)
+
+	err = syscall.Setxattr(probe, OverlayXattrTrustedPrefix+overlayXattrOpaque, []byte("y"), 0)
+	return err != nil, nil
+}
+
+// overlayWhiteout creates the whiteout device at p. If that is not permitted and userXattr is set the whiteout is
+// a file with the whiteout attribute instead, which needs its parent directory to be marked as well.
+func overlayWhiteout(p string, userXattr bool) error {
+	err := syscall.Mknod(p, syscall.S_IFCHR, 0)
+	if err == nil || !userXattr || (err != syscall.EPERM && err != syscall.EACCES) {
+		if err != nil {
+			return &os.PathError{Op: "mknod", Path: p, Err: err}
+		}
+		return nil
+	}
+
+	if err := ioutil.WriteFile(p, nil, 0000); err != nil {
+		return err
+	}
+	if err := syscall.Setxattr(p, OverlayXattrUserPrefix+overlayXattrWhiteout, []byte("y"), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: p, Err: err}
+	}
+
+	// An opaque directory stays opaque, otherwise the directory is marked as containing whiteout files
+	parent := filepath.Dir(p)
+	if value, ok := overlayXattr(parent, overlayXattrOpaque); ok && value == "y" {
+		return nil
+	}
+	if err := syscall.Setxattr(parent, OverlayXattrUserPrefix+overlayXattrOpaque, []byte("x"), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: parent, Err: err}
+	}
+	return nil
+}
+
+// mkdirParents creates the missing parent directories of name below dir and refuses to write through symlinks
+func mkdirParents(dir, name string) error {
+	current := dir
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s cannot be extracted: %s is no directory", name, current)
+		}
+	}
+
+	return nil
+}
+
+// checkParents makes sure every parent of name below dir is a directory and no symlink
+func checkParents(dir, name string) error {
+	current := dir
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s cannot be resolved: %s is no directory", name, current)
+		}
+	}
+
+	return nil
+}
+
+func extractFile(target string, hdr *tar.Header, r io.Reader) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+
+	// Setuid, setgid and sticky bits are not set by create
+	if err := f.Chmod(hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, hdr.AccessTime, hdr.ModTime)
+}
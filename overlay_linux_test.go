@@ -0,0 +1,195 @@
+package oci
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// overlayWhiteout creates the whiteout device of overlayfs or skips the test without the privileges for it
+func (suite *OCITestSuite) overlayWhiteout(p string) {
+	if err := syscall.Mknod(p, syscall.S_IFCHR, 0); err != nil {
+		suite.T().Skipf("whiteout devices cannot be created: %s", err)
+	}
+}
+
+func (suite *OCITestSuite) overlaySetXattr(p, name, value string) {
+	if err := syscall.Setxattr(p, OverlayXattrTrustedPrefix+name, []byte(value), 0); err != nil {
+		suite.T().Skipf("overlay xattrs cannot be set: %s", err)
+	}
+}
+
+func (suite *OCITestSuite) TestAddOverlayUpper() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"etc/keep": "keep", "etc/old": "old", "opaque/x": "x", "src/a": "a", "src/b": "b"})
+	upper := suite.writeTree(map[string]string{"opaque/new": "new", "dst/b": "changed", "dst/c": "c", "empty": ""})
+	require.NoError(suite.T(), os.Mkdir(filepath.Join(upper, "etc"), 0755))
+	suite.overlayWhiteout(filepath.Join(upper, "etc", "old"))
+	suite.overlayWhiteout(filepath.Join(upper, "src"))
+	suite.overlaySetXattr(filepath.Join(upper, "opaque"), overlayXattrOpaque, "y")
+	suite.overlaySetXattr(filepath.Join(upper, "dst"), overlayXattrRedirect, "/src")
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+	require.NoError(suite.T(), img.AddOverlayUpper(upper, specsv1.History{CreatedBy: "upper"}))
+
+	mergedFs, err := img.Fs()
+	require.NoError(suite.T(), err)
+
+	files := make(map[string]string)
+	require.NoError(suite.T(), afero.Walk(mergedFs, "/", func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		data, err := afero.ReadFile(mergedFs, p)
+		files[p] = string(data)
+		return err
+	}))
+
+	assert.Equal(suite.T(), map[string]string{
+		"/etc/keep":   "keep",
+		"/opaque/new": "new",
+		"/dst/a":      "a",
+		"/dst/b":      "changed",
+		"/dst/c":      "c",
+		"/empty":      "",
+	}, files)
+
+}
+
+func (suite *OCITestSuite) TestAddOverlayUpperWhiteoutFiles() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	// Unprivileged mounts mark deleted files with an attribute instead of a device
+	lower := suite.writeTree(map[string]string{"etc/keep": "keep", "etc/old": "old"})
+	upper := suite.writeTree(map[string]string{"etc/old": ""})
+	if err := syscall.Setxattr(filepath.Join(upper, "etc", "old"), OverlayXattrUserPrefix+overlayXattrWhiteout, []byte("y"), 0); err != nil {
+		suite.T().Skipf("user xattrs cannot be set: %s", err)
+	}
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+	require.NoError(suite.T(), img.AddOverlayUpper(upper, specsv1.History{CreatedBy: "upper"}))
+
+	entries := suite.layerEntries(img)
+	assert.Contains(suite.T(), entries, "etc/"+WhiteoutPrefix+"old")
+	assert.NotContains(suite.T(), entries, "etc/old")
+}
+
+func (suite *OCITestSuite) TestExtractOverlay() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"etc/keep": "keep", "etc/old": "old", "opaque/x": "x"})
+	upper := suite.writeTree(map[string]string{"opaque/new": "new", "etc/": ""})
+	suite.overlayWhiteout(filepath.Join(upper, "etc", "old"))
+	suite.overlaySetXattr(filepath.Join(upper, "opaque"), overlayXattrOpaque, "y")
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+	require.NoError(suite.T(), img.AddOverlayUpper(upper, specsv1.History{CreatedBy: "upper"}))
+
+	base := suite.writeTree(map[string]string{})
+	dirs, err := img.ExtractOverlay(base)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), dirs.LowerDirs, 2)
+	assert.False(suite.T(), dirs.UserXattr)
+	top := dirs.LowerDirs[0]
+	assert.Equal(suite.T(), filepath.Join(base, "sha256", img.manifest.Layers[1].Digest.Encoded()), top)
+	assert.Equal(suite.T(), "lowerdir="+top+":"+dirs.LowerDirs[1], dirs.MountOptions())
+
+	info, err := os.Lstat(filepath.Join(top, "etc", "old"))
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), isOverlayWhiteout(info))
+	value, ok := overlayXattr(filepath.Join(top, "opaque"), overlayXattrOpaque)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), "y", value)
+
+	data, err := ioutil.ReadFile(filepath.Join(dirs.LowerDirs[1], "etc", "old"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "old", string(data))
+
+	// Mount the layers if possible to see overlayfs agrees
+	mnt := suite.writeTree(map[string]string{})
+	if err := syscall.Mount("overlay", mnt, "overlay", syscall.MS_RDONLY, dirs.MountOptions()); err != nil {
+		suite.T().Logf("overlayfs cannot be mounted: %s", err)
+		return
+	}
+	defer syscall.Unmount(mnt, 0)
+
+	_, err = os.Stat(filepath.Join(mnt, "etc", "old"))
+	assert.True(suite.T(), os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(mnt, "opaque", "x"))
+	assert.True(suite.T(), os.IsNotExist(err))
+	data, err = ioutil.ReadFile(filepath.Join(mnt, "etc", "keep"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "keep", string(data))
+}
+
+// tarLayer writes the headers without contents into an uncompressed layer file
+func (suite *OCITestSuite) tarLayer(headers ...*tar.Header) string {
+	tarFile, err := ioutil.TempFile("", "oci-test-layer")
+	require.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { os.Remove(tarFile.Name()) })
+	tw := tar.NewWriter(tarFile)
+	for _, hdr := range headers {
+		require.NoError(suite.T(), tw.WriteHeader(hdr))
+	}
+	require.NoError(suite.T(), tw.Close())
+	require.NoError(suite.T(), tarFile.Close())
+	return tarFile.Name()
+}
+
+func (suite *OCITestSuite) TestExtractOverlayMalicious() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	outside := suite.writeTree(map[string]string{"secret": "secret"})
+	require.NoError(suite.T(), os.Chmod(outside, 0755))
+
+	// A hardlink through a symlink of the same layer must not reach files outside of it
+	img := layout.CreateImage("hardlink")
+	require.NoError(suite.T(), img.AddLayerFile(suite.tarLayer(
+		&tar.Header{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+		&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "evil/secret", Mode: 0644},
+	), specsv1.MediaTypeImageLayer, specsv1.History{CreatedBy: "hardlink"}))
+	_, err = img.ExtractOverlay(suite.writeTree(map[string]string{}))
+	assert.Error(suite.T(), err)
+	var stat syscall.Stat_t
+	require.NoError(suite.T(), syscall.Stat(filepath.Join(outside, "secret"), &stat))
+	assert.EqualValues(suite.T(), 1, stat.Nlink)
+
+	// A directory replaced by a symlink must not pass its mode on to the target of the symlink
+	img = layout.CreateImage("dir")
+	require.NoError(suite.T(), img.AddLayerFile(suite.tarLayer(
+		&tar.Header{Name: "d/", Typeflag: tar.TypeDir, Mode: 0700},
+		&tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+	), specsv1.MediaTypeImageLayer, specsv1.History{CreatedBy: "dir"}))
+	base := suite.writeTree(map[string]string{})
+	dirs, err := img.ExtractOverlay(base)
+	require.NoError(suite.T(), err)
+	target, err := os.Readlink(filepath.Join(dirs.LowerDirs[0], "d"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), outside, target)
+	info, err := os.Stat(outside)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), os.FileMode(0755), info.Mode().Perm())
+}
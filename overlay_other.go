@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package oci
+
+import specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+// AddOverlayUpper is only supported on Linux
+func (img *Image) AddOverlayUpper(upperDir string, h specsv1.History) error {
+	return ErrOverlayUnsupported
+}
+
+// ExtractOverlay is only supported on Linux
+func (l *LayerReader) ExtractOverlay(dir string, userXattr bool) error {
+	return ErrOverlayUnsupported
+}
+
+// ExtractOverlay is only supported on Linux
+func (img *Image) ExtractOverlay(baseDir string) (*OverlayDirs, error) {
+	return nil, ErrOverlayUnsupported
+}
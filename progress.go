@@ -0,0 +1,154 @@
+package oci
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// DefaultProgressInterval is the minimum time between two progress events of an operation
+const DefaultProgressInterval = 100 * time.Millisecond
+
+// ProgressOperation names the kind of operation a ProgressEvent belongs to
+type ProgressOperation string
+
+const (
+	// ProgressWriteArchive counts the entries and their uncompressed content added to an archive
+	ProgressWriteArchive ProgressOperation = "write archive"
+	// ProgressExtractArchive counts the entries extracted and the bytes read of the possibly compressed archive
+	ProgressExtractArchive ProgressOperation = "extract archive"
+	// ProgressWriteBlob counts the bytes written into a new blob
+	ProgressWriteBlob ProgressOperation = "write blob"
+	// ProgressCopyBlob counts the bytes of a blob copied from another store
+	ProgressCopyBlob ProgressOperation = "copy blob"
+)
+
+// ProgressEvent describes how far an operation got
+type ProgressEvent struct {
+	Operation ProgressOperation
+	// Path of the current archive entry
+	Path string
+	// Digest of the blob if it is known
+	Digest digest.Digest
+	// Entries is the number of archive entries processed so far
+	Entries int
+	// Bytes processed so far
+	Bytes int64
+	// Total number of bytes to process or 0 if unknown
+	Total int64
+	// Done is set on the last event of a successful operation
+	Done bool
+}
+
+// ProgressReporter receives the progress of long running operations
+type ProgressReporter interface {
+	Progress(event ProgressEvent)
+}
+
+// ProgressFunc adapts a function to a ProgressReporter
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) Progress(event ProgressEvent) {
+	f(event)
+}
+
+// RateLimitProgress forwards at most one event per interval to reporter. The first event and events
+// which finish an operation are always forwarded. The returned reporter may be shared by concurrent operations,
+// each operation of this package is limited on its own so they do not suppress the events of each other.
+func RateLimitProgress(reporter ProgressReporter, interval time.Duration) ProgressReporter {
+	return &rateLimitedProgress{reporter: reporter, interval: interval}
+}
+
+type rateLimitedProgress struct {
+	mu       sync.Mutex
+	reporter ProgressReporter
+	interval time.Duration
+	last     time.Time
+}
+
+func (r *rateLimitedProgress) Progress(event ProgressEvent) {
+	if !event.Done {
+		r.mu.Lock()
+		now := time.Now()
+		if !r.last.IsZero() && now.Sub(r.last) < r.interval {
+			r.mu.Unlock()
+			return
+		}
+		r.last = now
+		r.mu.Unlock()
+	}
+
+	r.reporter.Progress(event)
+}
+
+// progressTracker accumulates the progress of one operation. Without reporter it does nothing.
+type progressTracker struct {
+	reporter ProgressReporter
+	event    ProgressEvent
+}
+
+func newProgressTracker(operation ProgressOperation) *progressTracker {
+	return &progressTracker{event: ProgressEvent{Operation: operation}}
+}
+
+// setReporter limits reporters to DefaultProgressInterval unless they are rate limited already. The tracker gets
+// a limiter of its own either way.
+func (t *progressTracker) setReporter(reporter ProgressReporter) {
+	if limited, ok := reporter.(*rateLimitedProgress); ok {
+		reporter = RateLimitProgress(limited.reporter, limited.interval)
+	} else if reporter != nil {
+		reporter = RateLimitProgress(reporter, DefaultProgressInterval)
+	}
+	t.reporter = reporter
+}
+
+func (t *progressTracker) entry(path string) {
+	t.event.Entries++
+	t.event.Path = path
+	t.report()
+}
+
+func (t *progressTracker) add(n int) {
+	if n == 0 {
+		return
+	}
+	t.event.Bytes += int64(n)
+	t.report()
+}
+
+func (t *progressTracker) done() {
+	t.event.Done = true
+	t.report()
+}
+
+func (t *progressTracker) report() {
+	if t.reporter != nil {
+		t.reporter.Progress(t.event)
+	}
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r       io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.tracker.add(n)
+	return n, err
+}
+
+// progressWriter counts the bytes written through it
+type progressWriter struct {
+	w       io.Writer
+	tracker *progressTracker
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.tracker.add(n)
+	return n, err
+}
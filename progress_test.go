@@ -0,0 +1,91 @@
+package oci
+
+import (
+	"time"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestRateLimitProgress() {
+	var events []ProgressEvent
+	reporter := RateLimitProgress(ProgressFunc(func(event ProgressEvent) {
+		events = append(events, event)
+	}), time.Hour)
+
+	for i := 1; i <= 3; i++ {
+		reporter.Progress(ProgressEvent{Bytes: int64(i)})
+	}
+	reporter.Progress(ProgressEvent{Bytes: 4, Done: true})
+
+	assert.Equal(suite.T(), []ProgressEvent{{Bytes: 1}, {Bytes: 4, Done: true}}, events)
+
+	// Operations sharing the reporter are limited on their own
+	events = nil
+	first, second := newProgressTracker(ProgressWriteBlob), newProgressTracker(ProgressCopyBlob)
+	first.setReporter(reporter)
+	second.setReporter(reporter)
+	first.add(1)
+	second.add(2)
+	first.add(3)
+	assert.Equal(suite.T(), []ProgressEvent{{Operation: ProgressWriteBlob, Bytes: 1}, {Operation: ProgressCopyBlob, Bytes: 2}}, events)
+}
+
+func (suite *OCITestSuite) TestProgress() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	events := make(map[ProgressOperation][]ProgressEvent)
+	reporter := RateLimitProgress(ProgressFunc(func(event ProgressEvent) {
+		events[event.Operation] = append(events[event.Operation], event)
+	}), 0)
+
+	img := layout.CreateImage("latest")
+	img.SetProgress(reporter)
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"a": "hello", "dir/b": "world!"}), specsv1.History{CreatedBy: "tree"}))
+
+	written := events[ProgressWriteArchive]
+	require.NotEmpty(suite.T(), written)
+	last := written[len(written)-1]
+	assert.True(suite.T(), last.Done)
+	assert.Equal(suite.T(), 3, last.Entries)
+	assert.Equal(suite.T(), int64(len("hello")+len("world!")), last.Bytes)
+
+	require.NoError(suite.T(), img.ExtractInto(afero.NewMemMapFs(), "/"))
+	extracted := events[ProgressExtractArchive]
+	require.NotEmpty(suite.T(), extracted)
+	last = extracted[len(extracted)-1]
+	layer := img.Manifest().Layers[0]
+	assert.True(suite.T(), last.Done)
+	assert.Equal(suite.T(), 3, last.Entries)
+	assert.Equal(suite.T(), layer.Digest, last.Digest)
+	assert.Equal(suite.T(), layer.Size, last.Total)
+	assert.Greater(suite.T(), last.Bytes, int64(0))
+	assert.LessOrEqual(suite.T(), last.Bytes, last.Total)
+
+	require.NoError(suite.T(), layout.SaveImage(img))
+	dstRepo, err := CreateRepositoryFS(afero.NewMemMapFs(), "dst")
+	require.NoError(suite.T(), err)
+	dst, err := dstRepo.CreateImageLayout("release")
+	require.NoError(suite.T(), err)
+
+	result, err := Copy(layout, "latest", dst, "latest", CopyOptions{Reporter: reporter})
+	require.NoError(suite.T(), err)
+
+	finished := make(map[string]ProgressEvent)
+	for _, event := range events[ProgressCopyBlob] {
+		if event.Done {
+			finished[event.Digest.String()] = event
+		}
+	}
+	require.Len(suite.T(), finished, len(result.Copied))
+	for _, descr := range result.Copied {
+		event := finished[descr.Digest.String()]
+		assert.Equal(suite.T(), descr.Size, event.Total, descr.Digest.String())
+		assert.Equal(suite.T(), descr.Size, event.Bytes, descr.Digest.String())
+	}
+}
@@ -0,0 +1,68 @@
+package provenance
+
+import (
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/toasterson/oci/signature"
+)
+
+// Envelope is a DSSE envelope
+// https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+var ErrNoValidSignature = errors.New("no valid signature in envelope")
+
+// NewEnvelope signs the payload with the DSSE pre-authentication encoding
+func NewEnvelope(payloadType string, payload []byte, signer signature.Signer, keyID string) (*Envelope, error) {
+	sig, err := signer.Sign(pae(payloadType, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []EnvelopeSignature{{
+			KeyID: keyID,
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		}},
+	}, nil
+}
+
+// Verify returns the payload if one of the signatures verifies with the key
+func (e *Envelope) Verify(key crypto.PublicKey) ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload of envelope is not base64 encoded: %w", err)
+	}
+
+	encoded := pae(e.PayloadType, payload)
+	for _, s := range e.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		if signature.Verify(key, encoded, sig) == nil {
+			return payload, nil
+		}
+	}
+
+	return nil, ErrNoValidSignature
+}
+
+// pae is the pre-authentication encoding of DSSE
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
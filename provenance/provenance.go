@@ -0,0 +1,271 @@
+// Package provenance records how an image was built as SLSA provenance in an in-toto statement.
+// The statement is signed as DSSE envelope and stored as artifact referring to the manifest of the image.
+// https://slsa.dev/spec/v1.0/provenance and https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md
+package provenance
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+	"github.com/toasterson/oci/signature"
+)
+
+const (
+	StatementType      = "https://in-toto.io/Statement/v1"
+	PredicateTypeSLSA  = "https://slsa.dev/provenance/v1"
+	BuildType          = "https://github.com/toasterson/oci/build/v1"
+	PayloadTypeInToto  = "application/vnd.in-toto+json"
+	MediaTypeDSSE      = "application/vnd.dsse.envelope.v1+json"
+	ArtifactTypeInToto = "application/vnd.in-toto+json"
+
+	// AnnotationPredicateType is set on the envelope layer so readers can pick statements without decoding them
+	AnnotationPredicateType = "in-toto.io/predicate-type"
+)
+
+// Statement is an in-toto statement carrying SLSA provenance
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Provenance           `json:"predicate"`
+}
+
+type ResourceDescriptor struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	MediaType   string            `json:"mediaType,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   ExternalParameters   `json:"externalParameters"`
+	InternalParameters   map[string]string    `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// ExternalParameters are the build steps as recorded in the history of the image config
+type ExternalParameters struct {
+	History []specsv1.History `json:"history"`
+}
+
+type RunDetails struct {
+	Builder    Builder              `json:"builder"`
+	Metadata   BuildMetadata        `json:"metadata"`
+	Byproducts []ResourceDescriptor `json:"byproducts,omitempty"`
+}
+
+// Builder identifies who built the image
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type BuildMetadata struct {
+	InvocationID string     `json:"invocationId,omitempty"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// NewStatement describes the build of the image whose manifest was saved as manifest.
+// The build sources and start are only known if RecordSources was called on the image before adding layers.
+// They are not stored with the image thus the statement has to be created from the image which was built,
+// not from one opened later.
+func NewStatement(img *oci.Image, manifest specsv1.Descriptor, name string, builder Builder, invocationID string) *Statement {
+	statement := &Statement{
+		Type: StatementType,
+		Subject: []ResourceDescriptor{{
+			Name:   name,
+			Digest: digestSet(manifest.Digest),
+		}},
+		PredicateType: PredicateTypeSLSA,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType: BuildType,
+				ExternalParameters: ExternalParameters{
+					History: img.Config.History,
+				},
+				InternalParameters: map[string]string{
+					"os":           img.Config.OS,
+					"architecture": img.Config.Architecture,
+				},
+			},
+			RunDetails: RunDetails{
+				Builder: builder,
+				Metadata: BuildMetadata{
+					InvocationID: invocationID,
+				},
+			},
+		},
+	}
+
+	sources := img.Sources()
+	for _, source := range sources {
+		statement.Predicate.BuildDefinition.ResolvedDependencies = append(statement.Predicate.BuildDefinition.ResolvedDependencies, ResourceDescriptor{
+			URI:    "file://" + source.Path,
+			Digest: digestSet(source.Digest),
+			Annotations: map[string]string{
+				"layer": source.Layer.String(),
+			},
+		})
+	}
+	if started := img.BuildStarted(); !started.IsZero() {
+		statement.Predicate.RunDetails.Metadata.StartedOn = &started
+	}
+	finished := time.Now().UTC()
+	statement.Predicate.RunDetails.Metadata.FinishedOn = &finished
+
+	for _, layer := range img.Manifest().Layers {
+		statement.Predicate.RunDetails.Byproducts = append(statement.Predicate.RunDetails.Byproducts, ResourceDescriptor{
+			Digest:    digestSet(layer.Digest),
+			MediaType: layer.MediaType,
+		})
+	}
+
+	return statement
+}
+
+// Sign wraps the statement into a signed DSSE envelope
+func (s *Statement) Sign(signer signature.Signer, keyID string) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEnvelope(PayloadTypeInToto, payload, signer, keyID)
+}
+
+// Attach stores the envelope as artifact referring to the manifest and adds it to the index of the layout.
+// The index of the layout is only persisted once the layout is closed.
+func Attach(layout *oci.ImageLayout, manifest specsv1.Descriptor, envelope *Envelope, predicateType string) (specsv1.Descriptor, error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	artifact := layout.CreateArtifact(ArtifactTypeInToto, &manifest)
+	if _, err := artifact.AddBlob(MediaTypeDSSE, bytes.NewReader(data), map[string]string{
+		AnnotationPredicateType: predicateType,
+	}); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	return layout.SaveArtifact(artifact)
+}
+
+// Record creates, signs and attaches the provenance of the image in one go
+func Record(layout *oci.ImageLayout, img *oci.Image, manifest specsv1.Descriptor, name string, builder Builder, signer signature.Signer) (specsv1.Descriptor, error) {
+	envelope, err := NewStatement(img, manifest, name, builder, "").Sign(signer, "")
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	return Attach(layout, manifest, envelope, PredicateTypeSLSA)
+}
+
+// Envelopes returns all DSSE envelopes attached to the manifest with the given predicate type.
+// An empty predicate type returns all of them.
+func Envelopes(layout *oci.ImageLayout, manifest digest.Digest, predicateType string) ([]*Envelope, error) {
+	referrers, err := layout.Referrers(manifest, ArtifactTypeInToto)
+	if err != nil {
+		return nil, err
+	}
+
+	envelopes := make([]*Envelope, 0)
+	for _, referrer := range referrers {
+		var artifact specsv1.Manifest
+		if err := decodeBlob(layout, referrer.Digest, &artifact); err != nil {
+			return nil, err
+		}
+
+		for _, layer := range artifact.Layers {
+			if layer.MediaType != MediaTypeDSSE {
+				continue
+			}
+			if predicateType != "" && layer.Annotations[AnnotationPredicateType] != predicateType {
+				continue
+			}
+
+			envelope := &Envelope{}
+			if err := decodeBlob(layout, layer.Digest, envelope); err != nil {
+				return nil, err
+			}
+			envelopes = append(envelopes, envelope)
+		}
+	}
+
+	return envelopes, nil
+}
+
+// Verify returns the SLSA provenance statements attached to the manifest which are signed by the key
+// and whose subject is the manifest. It fails if there is none.
+func Verify(layout *oci.ImageLayout, manifest digest.Digest, key crypto.PublicKey) ([]*Statement, error) {
+	envelopes, err := Envelopes(layout, manifest, PredicateTypeSLSA)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]*Statement, 0)
+	for _, envelope := range envelopes {
+		if envelope.PayloadType != PayloadTypeInToto {
+			continue
+		}
+
+		payload, err := envelope.Verify(key)
+		if err != nil {
+			continue
+		}
+
+		statement := &Statement{}
+		if err := json.Unmarshal(payload, statement); err != nil {
+			return nil, fmt.Errorf("signed statement cannot be parsed: %w", err)
+		}
+
+		if statement.Type != StatementType || statement.PredicateType != PredicateTypeSLSA || !statement.hasSubject(manifest) {
+			continue
+		}
+
+		statements = append(statements, statement)
+	}
+
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("%w: no verified provenance for %s", ErrNoValidSignature, manifest)
+	}
+
+	return statements, nil
+}
+
+func (s *Statement) hasSubject(d digest.Digest) bool {
+	for _, subject := range s.Subject {
+		if subject.Digest[d.Algorithm().String()] == d.Encoded() {
+			return true
+		}
+	}
+	return false
+}
+
+func digestSet(d digest.Digest) map[string]string {
+	return map[string]string{d.Algorithm().String(): d.Encoded()}
+}
+
+func decodeBlob(layout *oci.ImageLayout, d digest.Digest, v interface{}) error {
+	rd, err := layout.OpenBlob(d)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	return rd.Decode(v)
+}
@@ -0,0 +1,85 @@
+package provenance
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+	"github.com/toasterson/oci/signature"
+)
+
+func TestRecordAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-provenance")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "app"), []byte("#!/bin/sh\necho app\n"), 0755))
+
+	repo, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/repo")
+	require.NoError(t, err)
+	layout, err := repo.CreateImageLayout("built")
+	require.NoError(t, err)
+
+	img := layout.CreateImage("latest")
+	img.RecordSources()
+	require.NoError(t, img.AddTree(dir, specsv1.History{CreatedBy: "COPY app /"}))
+	require.NoError(t, layout.SaveImage(img))
+	manifest, err := layout.Resolve("latest")
+	require.NoError(t, err)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := signature.NewSigner(key)
+	require.NoError(t, err)
+
+	builder := Builder{ID: "https://ci.example.com/builder", Version: map[string]string{"oci": "test"}}
+	_, err = Record(layout, img, manifest, "registry.example.com/app", builder, signer)
+	require.NoError(t, err)
+
+	statements, err := Verify(layout, manifest.Digest, key.Public())
+	require.NoError(t, err)
+	require.Len(t, statements, 1)
+
+	predicate := statements[0].Predicate
+	assert.Equal(t, builder, predicate.RunDetails.Builder)
+	require.Len(t, predicate.BuildDefinition.ResolvedDependencies, 1)
+	assert.Equal(t, "file://"+dir, predicate.BuildDefinition.ResolvedDependencies[0].URI)
+	assert.Equal(t, img.Sources()[0].Digest.Encoded(), predicate.BuildDefinition.ResolvedDependencies[0].Digest["sha256"])
+	assert.Equal(t, "COPY app /", predicate.BuildDefinition.ExternalParameters.History[0].CreatedBy)
+	require.Len(t, predicate.RunDetails.Byproducts, 1)
+	assert.Equal(t, "registry.example.com/app", statements[0].Subject[0].Name)
+	require.NotNil(t, predicate.RunDetails.Metadata.StartedOn)
+	assert.True(t, img.BuildStarted().Equal(*predicate.RunDetails.Metadata.StartedOn))
+	assert.False(t, predicate.RunDetails.Metadata.StartedOn.After(img.Sources()[0].Recorded))
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = Verify(layout, manifest.Digest, otherKey.Public())
+	assert.True(t, errors.Is(err, ErrNoValidSignature))
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := signature.NewSigner(key)
+	require.NoError(t, err)
+
+	envelope, err := NewEnvelope(PayloadTypeInToto, []byte(`{"_type":"https://in-toto.io/Statement/v1"}`), signer, "test")
+	require.NoError(t, err)
+
+	payload, err := envelope.Verify(key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, `{"_type":"https://in-toto.io/Statement/v1"}`, string(payload))
+
+	envelope.PayloadType = "text/plain"
+	_, err = envelope.Verify(key.Public())
+	assert.True(t, errors.Is(err, ErrNoValidSignature))
+}
@@ -0,0 +1,88 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrBaseMismatch = errors.New("image is not based on the base image")
+
+// Rebase replaces the layers of oldBase at the bottom of the image with the layers of newBase. The layers are
+// matched by their DiffID, the blobs of newBase are copied into the layout of the image if they are missing and
+// the layers on top are kept as they are. The history of oldBase is replaced by the history of newBase.
+// Save the image to write the new manifest.
+func (img *Image) Rebase(oldBase, newBase *Image) error {
+	oldDiffIDs := oldBase.Config.RootFS.DiffIDs
+	diffIDs := img.Config.RootFS.DiffIDs
+	if len(diffIDs) != len(img.manifest.Layers) {
+		return fmt.Errorf("image has %d layers but %d diff ids", len(img.manifest.Layers), len(diffIDs))
+	}
+	if len(newBase.Config.RootFS.DiffIDs) != len(newBase.manifest.Layers) {
+		return fmt.Errorf("new base image has %d layers but %d diff ids", len(newBase.manifest.Layers), len(newBase.Config.RootFS.DiffIDs))
+	}
+
+	if len(oldDiffIDs) > len(diffIDs) {
+		return fmt.Errorf("%w: base image has %d layers, image only %d", ErrBaseMismatch, len(oldDiffIDs), len(diffIDs))
+	}
+	for i, diffID := range oldDiffIDs {
+		if diffIDs[i] != diffID {
+			return fmt.Errorf("%w: layer %d is %s instead of %s", ErrBaseMismatch, i, diffIDs[i], diffID)
+		}
+	}
+
+	baseHistory, err := img.baseHistoryLength(oldBase)
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range newBase.manifest.Layers {
+		if _, err := copyBlob(newBase.blobs, img.blobs, layer, newBase.progress); err != nil {
+			return err
+		}
+	}
+
+	layers := make([]specsv1.Descriptor, 0, len(newBase.manifest.Layers)+len(img.manifest.Layers)-len(oldDiffIDs))
+	layers = append(layers, newBase.manifest.Layers...)
+	img.manifest.Layers = append(layers, img.manifest.Layers[len(oldDiffIDs):]...)
+
+	rebasedDiffIDs := make([]digest.Digest, 0, len(img.manifest.Layers))
+	rebasedDiffIDs = append(rebasedDiffIDs, newBase.Config.RootFS.DiffIDs...)
+	img.Config.RootFS.DiffIDs = append(rebasedDiffIDs, diffIDs[len(oldDiffIDs):]...)
+
+	history := make([]specsv1.History, 0, len(newBase.Config.History)+len(img.Config.History)-baseHistory)
+	history = append(history, newBase.Config.History...)
+	img.Config.History = append(history, img.Config.History[baseHistory:]...)
+
+	return nil
+}
+
+// baseHistoryLength returns the number of history entries of the image which belong to base. Without history
+// the image has to have none as well.
+func (img *Image) baseHistoryLength(base *Image) (int, error) {
+	if len(base.Config.History) == 0 && len(img.Config.History) == 0 {
+		return 0, nil
+	}
+
+	if err := base.checkLayerHistory(); err != nil {
+		return 0, fmt.Errorf("base image: %w", err)
+	}
+	if err := img.checkLayerHistory(); err != nil {
+		return 0, err
+	}
+
+	n := len(base.Config.History)
+	if n > len(img.Config.History) {
+		return 0, fmt.Errorf("%w: base image has %d history entries, image only %d", ErrBaseMismatch, n, len(img.Config.History))
+	}
+
+	for i, h := range base.Config.History {
+		if img.Config.History[i].CreatedBy != h.CreatedBy || img.Config.History[i].EmptyLayer != h.EmptyLayer {
+			return 0, fmt.Errorf("%w: history entry %d differs", ErrBaseMismatch, i)
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,51 @@
+package oci
+
+import (
+	"errors"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestRebase() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	baseLayout, err := repo.CreateImageLayout("base")
+	require.NoError(suite.T(), err)
+	appLayout, err := repo.CreateImageLayout("app")
+	require.NoError(suite.T(), err)
+
+	oldBase := baseLayout.CreateImage("1.0")
+	require.NoError(suite.T(), oldBase.AddTree(suite.writeTree(map[string]string{"lib/ssl": "vulnerable"}), specsv1.History{CreatedBy: "base"}))
+	oldBase.Config.History = append(oldBase.Config.History, specsv1.History{CreatedBy: "ENV A=1", EmptyLayer: true})
+
+	newBase := baseLayout.CreateImage("1.1")
+	require.NoError(suite.T(), newBase.AddTree(suite.writeTree(map[string]string{"lib/ssl": "fixed"}), specsv1.History{CreatedBy: "base"}))
+	require.NoError(suite.T(), newBase.AddTree(suite.writeTree(map[string]string{"lib/extra": "extra"}), specsv1.History{CreatedBy: "extra"}))
+
+	// Start the application from the old base which copies its layer into the other layout
+	app := appLayout.CreateImage("latest")
+	require.NoError(suite.T(), app.Rebase(appLayout.CreateImage("empty"), oldBase))
+	require.True(suite.T(), appLayout.HasBlob(oldBase.manifest.Layers[0].Digest))
+	require.NoError(suite.T(), app.AddTree(suite.writeTree(map[string]string{"app": "app"}), specsv1.History{CreatedBy: "app"}))
+	appLayer := app.manifest.Layers[1]
+	appDiffID := app.Config.RootFS.DiffIDs[1]
+
+	err = app.Rebase(newBase, oldBase)
+	assert.True(suite.T(), errors.Is(err, ErrBaseMismatch))
+
+	require.NoError(suite.T(), app.Rebase(oldBase, newBase))
+	assert.Equal(suite.T(), []specsv1.Descriptor{newBase.manifest.Layers[0], newBase.manifest.Layers[1], appLayer}, app.manifest.Layers)
+	assert.Equal(suite.T(), append(newBase.Config.RootFS.DiffIDs[:2:2], appDiffID), app.Config.RootFS.DiffIDs)
+	assert.Equal(suite.T(), []specsv1.History{{CreatedBy: "base"}, {CreatedBy: "extra"}, {CreatedBy: "app"}}, app.Config.History)
+
+	mergedFs, err := app.Fs()
+	require.NoError(suite.T(), err)
+	for name, content := range map[string]string{"/lib/ssl": "fixed", "/lib/extra": "extra", "/app": "app"} {
+		data, err := afero.ReadFile(mergedFs, name)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), content, string(data))
+	}
+}
@@ -0,0 +1,347 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTokenLifetime is assumed for tokens whose response does not carry expires_in
+// https://docs.docker.com/registry/spec/auth/token/#requesting-a-token
+const defaultTokenLifetime = 60 * time.Second
+
+// WithCredentials sets the store the credentials for the registry are looked up in.
+// Without credentials the client only uses anonymous tokens.
+func WithCredentials(store CredentialStore) Option {
+	return func(c *Client) {
+		c.auth.credentials = store
+	}
+}
+
+// WithBasicAuth authenticates with username and password against the registry of the client
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.auth.credentials = StaticCredentials{c.base.Host: {Username: username, Password: password}}
+	}
+}
+
+// authChallenge is a parsed WWW-Authenticate header
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
+// authenticator keeps track of the authentication schemes registries asked for, of the credentials
+// looked up and of the bearer tokens issued per host and scope
+type authenticator struct {
+	credentials CredentialStore
+
+	mu     sync.Mutex
+	basic  map[string]bool
+	creds  map[string]Credential
+	tokens map[string]bearerToken
+}
+
+func newAuthenticator() *authenticator {
+	return &authenticator{
+		basic:  make(map[string]bool),
+		creds:  make(map[string]Credential),
+		tokens: make(map[string]bearerToken),
+	}
+}
+
+// credential looks up the credential for host once as stores may run a credential helper for every lookup
+func (a *authenticator) credential(ctx context.Context, host string) (Credential, error) {
+	if a.credentials == nil {
+		return Credential{}, nil
+	}
+
+	a.mu.Lock()
+	cred, ok := a.creds[host]
+	a.mu.Unlock()
+	if ok {
+		return cred, nil
+	}
+
+	var err error
+	if store, ok := a.credentials.(ContextCredentialStore); ok {
+		cred, err = store.CredentialContext(ctx, host)
+	} else {
+		cred, err = a.credentials.Credential(host)
+	}
+	if err != nil {
+		return Credential{}, err
+	}
+
+	a.mu.Lock()
+	a.creds[host] = cred
+	a.mu.Unlock()
+
+	return cred, nil
+}
+
+// authorize adds the Authorization header for host and scope to the request if it is known already
+func (a *authenticator) authorize(req *http.Request, scope string) error {
+	host := req.URL.Host
+
+	a.mu.Lock()
+	token, hasToken := a.tokens[tokenKey(host, scope)]
+	basic := a.basic[host]
+	a.mu.Unlock()
+
+	if hasToken && time.Now().Before(token.expires) {
+		req.Header.Set("Authorization", "Bearer "+token.token)
+		return nil
+	}
+
+	if basic {
+		cred, err := a.credential(req.Context(), host)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+
+	return nil
+}
+
+// handleChallenge answers the challenge of a registry. It returns false if the challenge cannot be
+// answered and retrying the request is pointless.
+func (a *authenticator) handleChallenge(ctx context.Context, httpClient *http.Client, host, scope string, challenge authChallenge) (bool, error) {
+	cred, err := a.credential(ctx, host)
+	if err != nil {
+		return false, err
+	}
+
+	switch challenge.scheme {
+	case "basic":
+		if cred.Username == "" {
+			return false, nil
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.basic[host] {
+			// Credentials have been sent already and were rejected
+			return false, nil
+		}
+		a.basic[host] = true
+		return true, nil
+	case "bearer":
+		tokenScope := scope
+		if challengeScope, ok := challenge.params["scope"]; ok {
+			tokenScope = challengeScope
+		}
+
+		token, err := fetchToken(ctx, httpClient, challenge.params["realm"], challenge.params["service"], tokenScope, cred)
+		if err != nil {
+			return false, err
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		key := tokenKey(host, scope)
+		if existing, ok := a.tokens[key]; ok && existing.token == token.token {
+			// Same token was rejected before
+			return false, nil
+		}
+		a.tokens[key] = token
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// fetchToken requests a bearer token from the token server of a registry
+// https://docs.docker.com/registry/spec/auth/token/ and https://docs.docker.com/registry/spec/auth/oauth/
+func fetchToken(ctx context.Context, httpClient *http.Client, realm, service, scope string, cred Credential) (bearerToken, error) {
+	if cred.RegistryToken != "" {
+		return bearerToken{token: cred.RegistryToken, expires: time.Now().Add(defaultTokenLifetime)}, nil
+	}
+
+	if realm == "" {
+		return bearerToken{}, fmt.Errorf("bearer challenge without realm")
+	}
+
+	realmURL, err := url.Parse(realm)
+	if err != nil {
+		return bearerToken{}, fmt.Errorf("invalid realm %s: %w", realm, err)
+	}
+
+	var req *http.Request
+	if cred.IdentityToken != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", cred.IdentityToken)
+		form.Set("service", service)
+		form.Set("client_id", "github.com/toasterson/oci")
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realmURL.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return bearerToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realmURL.Query()
+		if service != "" {
+			query.Set("service", service)
+		}
+		for _, s := range strings.Fields(scope) {
+			query.Add("scope", s)
+		}
+		realmURL.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realmURL.String(), nil)
+		if err != nil {
+			return bearerToken{}, err
+		}
+		if cred.Username != "" {
+			req.SetBasicAuth(cred.Username, cred.Password)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return bearerToken{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return bearerToken{}, newErrorResponse(resp)
+	}
+
+	var tokenResp struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return bearerToken{}, fmt.Errorf("invalid token response from %s: %w", realm, err)
+	}
+
+	token := bearerToken{token: tokenResp.Token}
+	if token.token == "" {
+		token.token = tokenResp.AccessToken
+	}
+	if token.token == "" {
+		return bearerToken{}, fmt.Errorf("token server %s returned no token", realm)
+	}
+
+	issued := tokenResp.IssuedAt
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	lifetime := defaultTokenLifetime
+	if tokenResp.ExpiresIn > 0 {
+		lifetime = time.Duration(tokenResp.ExpiresIn) * time.Second
+	}
+	token.expires = issued.Add(lifetime)
+
+	return token, nil
+}
+
+func tokenKey(host, scope string) string {
+	return host + " " + scope
+}
+
+// scopeForRequest derives the resource scope a request to the distribution API needs
+func scopeForRequest(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == req.URL.Path || path == "" {
+		return ""
+	}
+	if path == "_catalog" {
+		return "registry:catalog:*"
+	}
+
+	var name string
+	for _, marker := range []string{"/blobs/", "/manifests/", "/tags/", "/referrers/"} {
+		if idx := strings.Index(path, marker); idx > 0 {
+			name = path[:idx]
+			break
+		}
+	}
+	if name == "" {
+		return ""
+	}
+
+	actions := "pull"
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		actions = "pull,push"
+	}
+	if req.Method == http.MethodDelete {
+		actions = "delete"
+	}
+
+	return fmt.Sprintf("repository:%s:%s", name, actions)
+}
+
+// parseChallenge parses a WWW-Authenticate header of the form
+// Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:foo:pull"
+func parseChallenge(header string) (authChallenge, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return authChallenge{}, false
+	}
+
+	challenge := authChallenge{params: make(map[string]string)}
+	parts := strings.SplitN(header, " ", 2)
+	challenge.scheme = strings.ToLower(parts[0])
+	if len(parts) == 1 {
+		return challenge, true
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			rest = rest[1:]
+			var sb strings.Builder
+			for i := 0; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					sb.WriteByte(rest[i])
+					continue
+				}
+				if rest[i] == '"' {
+					rest = rest[i+1:]
+					break
+				}
+				sb.WriteByte(rest[i])
+				if i == len(rest)-1 {
+					rest = ""
+				}
+			}
+			value = sb.String()
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		challenge.params[key] = value
+	}
+
+	return challenge, true
+}
@@ -0,0 +1,227 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenProtected wraps a registry handler and requires bearer tokens issued by a token server for the requested scope
+func tokenProtected(next http.Handler, realm string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := scopeForRequest(r)
+		if scope != "" && r.Header.Get("Authorization") != "Bearer token-for "+scope {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="test-registry",scope="%s"`, realm, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newTokenServer(t *testing.T, username, password string, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		assert.Equal(t, "test-registry", r.URL.Query().Get("service"))
+		scope := r.URL.Query().Get("scope")
+		if strings.Contains(scope, "push") || username != "" {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != username || pass != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "token-for " + scope,
+			"expires_in": 300,
+		})
+	}))
+}
+
+func TestBearerTokenAuth(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := newTokenServer(t, "user", "secret", &tokenRequests)
+	defer tokenServer.Close()
+
+	reg := newTestRegistry()
+	server := httptest.NewServer(tokenProtected(reg, tokenServer.URL))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	source := buildTestLayout(t, fs)
+
+	anonymous, err := NewClient(server.URL)
+	require.NoError(t, err)
+	_, err = anonymous.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.Error(t, err)
+
+	client, err := NewClient(server.URL, WithBasicAuth("user", "secret"))
+	require.NoError(t, err)
+	_, err = client.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.NoError(t, err)
+
+	// The rejected anonymous request, one token for the pull scope of the existence checks and one for the push scope
+	assert.Equal(t, int32(3), atomic.LoadInt32(&tokenRequests))
+
+	_, _, err = client.GetManifest(context.Background(), "library/test", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&tokenRequests), "tokens are cached per scope")
+}
+
+func TestAnonymousPull(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := newTokenServer(t, "", "", &tokenRequests)
+	defer tokenServer.Close()
+
+	reg := newTestRegistry()
+	server := httptest.NewServer(tokenProtected(reg, tokenServer.URL))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	require.NoError(t, err)
+
+	_, err = client.BlobExists(context.Background(), "library/test", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests))
+
+	err = client.UploadBlob(context.Background(), "library/test", specsv1.Descriptor{}, strings.NewReader(""))
+	require.Error(t, err, "push scope requires credentials")
+}
+
+func TestBasicAuth(t *testing.T) {
+	reg := newTestRegistry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, WithBasicAuth("user", "secret"))
+	require.NoError(t, err)
+	require.NoError(t, client.Ping(context.Background()))
+
+	wrong, err := NewClient(server.URL, WithBasicAuth("user", "wrong"))
+	require.NoError(t, err)
+	require.Error(t, wrong.Ping(context.Background()))
+}
+
+// countingCredentials counts the lookups of the credentials it stores
+type countingCredentials struct {
+	StaticCredentials
+	lookups int32
+}
+
+func (c *countingCredentials) Credential(host string) (Credential, error) {
+	atomic.AddInt32(&c.lookups, 1)
+	return c.StaticCredentials.Credential(host)
+}
+
+func TestCredentialsCached(t *testing.T) {
+	reg := newTestRegistry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	require.NoError(t, err)
+	store := &countingCredentials{StaticCredentials: StaticCredentials{client.base.Host: {Username: "user", Password: "secret"}}}
+	WithCredentials(store)(client)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, client.Ping(context.Background()))
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&store.lookups))
+}
+
+func TestParseChallenge(t *testing.T) {
+	challenge, ok := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:foo/bar:pull,push"`)
+	require.True(t, ok)
+	assert.Equal(t, "bearer", challenge.scheme)
+	assert.Equal(t, "https://auth.example.com/token", challenge.params["realm"])
+	assert.Equal(t, "registry.example.com", challenge.params["service"])
+	assert.Equal(t, "repository:foo/bar:pull,push", challenge.params["scope"])
+}
+
+func TestDockerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-docker-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	helper := filepath.Join(dir, "docker-credential-test")
+	require.NoError(t, ioutil.WriteFile(helper, []byte(`#!/bin/sh
+read server
+if [ "$server" = "helper.example.com" ]; then
+  echo '{"ServerURL":"helper.example.com","Username":"<token>","Secret":"refresh"}'
+  exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`), 0755))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	require.NoError(t, os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH")))
+
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+	"auths": {
+		"registry.example.com": {"auth": "`+auth+`"},
+		"https://index.docker.io/v1/": {"auth": "`+auth+`"}
+	},
+	"credHelpers": {"helper.example.com": "test"}
+}`), 0644))
+	defer os.Unsetenv("DOCKER_CONFIG")
+	require.NoError(t, os.Setenv("DOCKER_CONFIG", dir))
+
+	cfg, err := DefaultDockerConfig()
+	require.NoError(t, err)
+
+	cred, err := cfg.Credential("registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credential{Username: "user", Password: "secret"}, cred)
+
+	cred, err = cfg.Credential("registry-1.docker.io")
+	require.NoError(t, err)
+	assert.Equal(t, "user", cred.Username)
+
+	cred, err = cfg.Credential("helper.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Credential{IdentityToken: "refresh"}, cred)
+
+	cred, err = cfg.Credential("unknown.example.com")
+	require.NoError(t, err)
+	assert.True(t, cred.IsEmpty())
+
+	// Hanging helpers are killed with the context of the request
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "docker-credential-hang"), []byte("#!/bin/sh\nexec sleep 60\n"), 0755))
+	cfg.CredHelpers["hang.example.com"] = "hang"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = cfg.CredentialContext(ctx, "hang.example.com")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
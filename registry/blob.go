@@ -0,0 +1,227 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+// BlobExists checks with a HEAD request if the repository already contains the blob
+func (c *Client) BlobExists(ctx context.Context, repository string, d digest.Digest) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, c.url(fmt.Sprintf("/v2/%s/blobs/%s", repository, d)), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, newErrorResponse(resp)
+	}
+}
+
+// FetchBlob opens the blob described by descr for reading.
+// The returned reader verifies the digest of the content once it has been read completely.
+func (c *Client) FetchBlob(ctx context.Context, repository string, descr specsv1.Descriptor) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.url(fmt.Sprintf("/v2/%s/blobs/%s", repository, descr.Digest)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newErrorResponse(resp)
+	}
+
+	return &verifyingReader{
+		ReadCloser: resp.Body,
+		verifier:   descr.Digest.Verifier(),
+		expected:   descr.Digest,
+	}, nil
+}
+
+// fetchBlobFrom requests the content of the blob starting at offset. The returned flag reports whether the
+// registry honored the range request. If it did not the reader returns the content from the beginning.
+func (c *Client) fetchBlobFrom(ctx context.Context, repository string, descr specsv1.Descriptor, offset int64) (io.ReadCloser, bool, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, c.url(fmt.Sprintf("/v2/%s/blobs/%s", repository, descr.Digest)), header, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, offset == 0, nil
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	default:
+		defer resp.Body.Close()
+		return nil, false, newErrorResponse(resp)
+	}
+}
+
+// UploadBlob uploads the content of r as the blob described by descr.
+// Blobs larger than the configured chunk size are uploaded in chunks, everything else in one request.
+func (c *Client) UploadBlob(ctx context.Context, repository string, descr specsv1.Descriptor, r io.Reader) error {
+	completed, location, err := c.startUploadSession(ctx, repository, nil)
+	if err != nil || completed {
+		return err
+	}
+
+	return c.finishUpload(ctx, location, descr, r)
+}
+
+// startUpload opens an upload session and returns its location.
+// Additional query parameters are passed on to the POST request.
+func (c *Client) startUpload(ctx context.Context, repository string, query url.Values) (*url.URL, error) {
+	_, location, err := c.startUploadSession(ctx, repository, query)
+	return location, err
+}
+
+// startUploadSession sends the POST request opening an upload session. It returns true if the registry
+// completed the request right away, as it does for a successful cross repository mount.
+func (c *Client) startUploadSession(ctx context.Context, repository string, query url.Values) (bool, *url.URL, error) {
+	u := c.url(fmt.Sprintf("/v2/%s/blobs/uploads/", repository))
+	u.RawQuery = query.Encode()
+
+	resp, err := c.do(ctx, http.MethodPost, u, nil, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil, nil
+	case http.StatusAccepted:
+		location, err := c.resolve(resp.Header.Get("Location"))
+		return false, location, err
+	default:
+		return false, nil, newErrorResponse(resp)
+	}
+}
+
+func (c *Client) finishUpload(ctx context.Context, location *url.URL, descr specsv1.Descriptor, r io.Reader) (err error) {
+	if c.chunkSize > 0 && descr.Size > c.chunkSize {
+		location, err = c.uploadChunks(ctx, location, r)
+		if err != nil {
+			return err
+		}
+		// All content has been sent with PATCH requests thus the closing PUT has no body
+		r = nil
+	}
+
+	u := *location
+	query := u.Query()
+	query.Set("digest", descr.Digest.String())
+	u.RawQuery = query.Encode()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	if r != nil {
+		r = io.LimitReader(r, descr.Size)
+	} else {
+		r = http.NoBody
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	if r != http.NoBody {
+		req.ContentLength = descr.Size
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return newErrorResponse(resp)
+	}
+
+	return nil
+}
+
+// uploadChunks sends the content of r in chunks of the configured size and returns the location
+// the closing PUT request has to be sent to
+func (c *Client) uploadChunks(ctx context.Context, location *url.URL, r io.Reader) (*url.URL, error) {
+	buf := make([]byte, c.chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			header := http.Header{}
+			header.Set("Content-Type", "application/octet-stream")
+			header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+			header.Set("Content-Length", strconv.Itoa(n))
+
+			req, reqErr := http.NewRequestWithContext(ctx, http.MethodPatch, location.String(), bytes.NewReader(buf[:n]))
+			if reqErr != nil {
+				return nil, reqErr
+			}
+			req.Header = header
+			req.ContentLength = int64(n)
+
+			resp, doErr := c.send(req)
+			if doErr != nil {
+				return nil, doErr
+			}
+			if resp.StatusCode != http.StatusAccepted {
+				errResp := newErrorResponse(resp)
+				resp.Body.Close()
+				return nil, errResp
+			}
+			resp.Body.Close()
+
+			if location, doErr = c.resolve(resp.Header.Get("Location")); doErr != nil {
+				return nil, doErr
+			}
+			offset += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return location, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// verifyingReader checks the digest of the content once EOF has been reached
+type verifyingReader struct {
+	io.ReadCloser
+	verifier digest.Verifier
+	expected digest.Digest
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = v.verifier.Write(p[:n])
+	}
+	if err == io.EOF && !v.verifier.Verified() {
+		return n, fmt.Errorf("%w: content of blob %s", oci.ErrDigestMismatch, v.expected)
+	}
+	return n, err
+}
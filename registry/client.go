@@ -0,0 +1,229 @@
+// Package registry implements a client for registries following the OCI distribution
+// specification which transfers images between a registry and an oci.ImageLayout.
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultChunkSize is the size above which blobs are uploaded in chunks
+	DefaultChunkSize int64 = 8 * 1024 * 1024
+	// DefaultConcurrency is the number of blobs downloaded in parallel
+	DefaultConcurrency = 3
+	// DefaultRetries is how often an interrupted blob download is resumed before giving up
+	DefaultRetries = 3
+)
+
+type Client struct {
+	base        *url.URL
+	httpClient  *http.Client
+	chunkSize   int64
+	concurrency int
+	retries     int
+	auth        *authenticator
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the http.Client used to talk to the registry
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithChunkSize sets the size of the chunks used for blob uploads.
+// Blobs smaller than the chunk size are uploaded monolithically. A size of 0 disables chunked uploads.
+func WithChunkSize(size int64) Option {
+	return func(c *Client) {
+		c.chunkSize = size
+	}
+}
+
+// WithConcurrency sets how many blobs are downloaded in parallel when pulling
+func WithConcurrency(workers int) Option {
+	return func(c *Client) {
+		if workers < 1 {
+			workers = 1
+		}
+		c.concurrency = workers
+	}
+}
+
+// WithRetries sets how often an interrupted blob download is resumed with a range request
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		if retries < 0 {
+			retries = 0
+		}
+		c.retries = retries
+	}
+}
+
+// NewClient creates a client for the registry at the given address. The address can either be a
+// plain host like registry.example.com:5000 in which case https is used or a full URL.
+func NewClient(registry string, opts ...Option) (*Client, error) {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+
+	base, err := url.Parse(registry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry address %s: %w", registry, err)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	c := &Client{
+		base:        base,
+		httpClient:  http.DefaultClient,
+		chunkSize:   DefaultChunkSize,
+		concurrency: DefaultConcurrency,
+		retries:     DefaultRetries,
+		auth:        newAuthenticator(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// Ping checks if the registry implements the distribution API
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, c.url("/v2/"), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newErrorResponse(resp)
+	}
+
+	return nil
+}
+
+func (c *Client) url(path string) *url.URL {
+	u := *c.base
+	u.Path = c.base.Path + path
+	return &u
+}
+
+// resolve turns a Location header returned by the registry into an absolute URL
+func (c *Client) resolve(location string) (*url.URL, error) {
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.base.ResolveReference(loc), nil
+}
+
+func (c *Client) do(ctx context.Context, method string, u *url.URL, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	return c.send(req)
+}
+
+// send is the single place requests leave the client. It authorizes the request with what is known
+// about the registry and answers a WWW-Authenticate challenge by retrying the request once.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	scope := scopeForRequest(req)
+	if err := c.auth.authorize(req, scope); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+
+	// Without a way to replay the body the response has to be returned as is
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry, err := c.auth.handleChallenge(req.Context(), c.httpClient, req.URL.Host, scope, challenge)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if !retry {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retryReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retryReq.Header.Del("Authorization")
+	if err := c.auth.authorize(retryReq, scope); err != nil {
+		return nil, err
+	}
+
+	return c.httpClient.Do(retryReq)
+}
+
+// ErrorResponse is returned when the registry answers with an unexpected status code
+type ErrorResponse struct {
+	StatusCode int           `json:"-"`
+	Method     string        `json:"-"`
+	URL        string        `json:"-"`
+	Errors     []ErrorDetail `json:"errors"`
+}
+
+// ErrorDetail is a single entry in the errors list of a distribution API error response
+type ErrorDetail struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected status %d", e.Method, e.URL, e.StatusCode)
+	for _, detail := range e.Errors {
+		msg += fmt.Sprintf("; %s: %s", detail.Code, detail.Message)
+	}
+	return msg
+}
+
+func newErrorResponse(resp *http.Response) error {
+	errResp := &ErrorResponse{
+		StatusCode: resp.StatusCode,
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.Redacted(),
+	}
+
+	if body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024)); err == nil && len(body) > 0 {
+		_ = json.Unmarshal(body, errResp)
+	}
+
+	return errResp
+}
@@ -0,0 +1,279 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+// testRegistry is a minimal in memory implementation of the distribution API
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[string][]byte
+	types     map[string]string
+	uploads   map[string]*bytes.Buffer
+	requests  []string
+	nextID    int
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		blobs:     make(map[digest.Digest][]byte),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+		uploads:   make(map[string]*bytes.Buffer),
+	}
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.requests = append(reg.requests, r.Method+" "+r.URL.Path)
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/blobs/uploads/"):
+		reg.serveUpload(w, r, path[:strings.Index(path, "/blobs/uploads/")], path[strings.Index(path, "/blobs/uploads/")+len("/blobs/uploads/"):])
+	case strings.Contains(path, "/blobs/"):
+		d := digest.Digest(path[strings.Index(path, "/blobs/")+len("/blobs/"):])
+		data, ok := reg.blobs[d]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case strings.Contains(path, "/manifests/"):
+		key := strings.Replace(path, "/manifests/", ":", 1)
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			d := digest.FromBytes(data)
+			name := key[:strings.LastIndex(key, ":")]
+			for _, k := range []string{key, name + ":" + d.String()} {
+				reg.manifests[k] = data
+				reg.types[k] = r.Header.Get("Content-Type")
+			}
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.WriteHeader(http.StatusCreated)
+		default:
+			data, ok := reg.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", reg.types[key])
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (reg *testRegistry) serveUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	switch r.Method {
+	case http.MethodPost:
+		reg.nextID++
+		id = strconv.Itoa(reg.nextID)
+		reg.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		buf, ok := reg.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = buf.ReadFrom(r.Body)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		buf, ok := reg.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = buf.ReadFrom(r.Body)
+		d := digest.Digest(r.URL.Query().Get("digest"))
+		if digest.FromBytes(buf.Bytes()) != d {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reg.blobs[d] = buf.Bytes()
+		delete(reg.uploads, id)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (reg *testRegistry) count(prefix string) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	n := 0
+	for _, req := range reg.requests {
+		if strings.HasPrefix(req, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// buildTestLayout creates a layout containing one image tagged latest built from a small directory tree
+func buildTestLayout(t *testing.T, fs afero.Fs) *oci.ImageLayout {
+	dir, err := ioutil.TempDir("", "oci-registry-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "etc"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "etc", "os-release"), []byte("ID=test\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data"), bytes.Repeat([]byte("0123456789"), 4096), 0644))
+
+	repo, err := oci.CreateRepositoryFS(fs, "/repo")
+	require.NoError(t, err)
+	layout, err := repo.CreateImageLayout("source")
+	require.NoError(t, err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(t, img.AddTree(dir, specsv1.History{CreatedBy: "test"}))
+	require.NoError(t, layout.SaveImage(img))
+	require.NoError(t, layout.Close())
+
+	return layout
+}
+
+func TestPushPull(t *testing.T) {
+	reg := newTestRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	source := buildTestLayout(t, fs)
+
+	client, err := NewClient(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, client.Ping(context.Background()))
+
+	pushed, err := client.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(reg.blobs))
+	assert.Equal(t, 0, reg.count("PATCH"))
+
+	// Pushing again only checks for existence of the blobs
+	uploads := reg.count("POST")
+	_, err = client.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, uploads, reg.count("POST"))
+
+	repo, err := oci.OpenRepositoryFS(fs, "/repo")
+	require.NoError(t, err)
+	target, err := repo.CreateImageLayout("target")
+	require.NoError(t, err)
+
+	pulled, err := client.Pull(context.Background(), "library/test", "v1", target, "pulled")
+	require.NoError(t, err)
+	assert.Equal(t, pushed.Descriptor.Digest, pulled.Digest)
+	assert.Equal(t, specsv1.MediaTypeImageManifest, pulled.MediaType)
+
+	img, err := target.OpenImage("pulled")
+	require.NoError(t, err)
+	for _, layer := range img.Manifest().Layers {
+		assert.True(t, target.HasBlob(layer.Digest))
+	}
+
+	byDigest, err := client.Pull(context.Background(), "library/test", pushed.Descriptor.Digest.String(), target, "")
+	require.NoError(t, err)
+	assert.Equal(t, pushed.Descriptor.Digest, byDigest.Digest)
+}
+
+func TestChunkedUpload(t *testing.T) {
+	reg := newTestRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, WithChunkSize(1000))
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("chunk"), 1000)
+	descr := specsv1.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+	require.NoError(t, client.UploadBlob(context.Background(), "chunked", descr, bytes.NewReader(data)))
+	assert.Equal(t, 5, reg.count("PATCH"))
+
+	rd, err := client.FetchBlob(context.Background(), "chunked", descr)
+	require.NoError(t, err)
+	defer rd.Close()
+	fetched, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	assert.Equal(t, data, fetched)
+}
+
+func TestFetchBlobDigestMismatch(t *testing.T) {
+	reg := newTestRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	require.NoError(t, err)
+
+	d := digest.FromString("expected")
+	reg.blobs[d] = []byte("something else")
+
+	rd, err := client.FetchBlob(context.Background(), "mismatch", specsv1.Descriptor{Digest: d})
+	require.NoError(t, err)
+	defer rd.Close()
+	_, err = ioutil.ReadAll(rd)
+	assert.True(t, errors.Is(err, oci.ErrDigestMismatch))
+}
+
+func TestPushMountsAndSkipsBlobs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	source := buildTestLayout(t, fs)
+
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	server := httptest.NewServer(NewHandler(served))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	require.NoError(t, err)
+
+	result, err := client.Push(context.Background(), source, "latest", "library/base", "v1")
+	require.NoError(t, err)
+	assert.Len(t, result.Uploaded, 2)
+	assert.Empty(t, result.Mounted)
+	assert.Empty(t, result.Skipped)
+
+	result, err = client.Push(context.Background(), source, "latest", "library/app", "v1", MountFrom("library/missing", "library/base"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Uploaded)
+	assert.Len(t, result.Mounted, 2)
+	assert.Empty(t, result.Skipped)
+
+	result, err = client.Push(context.Background(), source, "latest", "library/app", "v2", MountFrom("library/base"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Uploaded)
+	assert.Empty(t, result.Mounted)
+	assert.Len(t, result.Skipped, 2)
+}
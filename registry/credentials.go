@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dockerConfigFileName = "config.json"
+	dockerHubConfigKey   = "https://index.docker.io/v1/"
+	// identityTokenUsername is returned by credential helpers in place of a user name if the secret is an identity token
+	identityTokenUsername = "<token>"
+)
+
+// Credential holds the secrets used to authenticate against a registry.
+// IdentityToken is an OAuth2 refresh token which is exchanged for a bearer token,
+// RegistryToken is a bearer token sent to the registry as is.
+type Credential struct {
+	Username      string
+	Password      string
+	IdentityToken string
+	RegistryToken string
+}
+
+// IsEmpty reports whether the credential would result in anonymous access
+func (c Credential) IsEmpty() bool {
+	return c == Credential{}
+}
+
+// CredentialStore looks up the credential for a registry host.
+// An empty credential and no error is returned if the store does not know the host.
+type CredentialStore interface {
+	Credential(host string) (Credential, error)
+}
+
+// ContextCredentialStore is a CredentialStore whose lookups can be cancelled, like those running credential helpers.
+// The client passes the context of the request to it.
+type ContextCredentialStore interface {
+	CredentialStore
+	CredentialContext(ctx context.Context, host string) (Credential, error)
+}
+
+// StaticCredentials is a CredentialStore backed by a map of registry hosts to credentials
+type StaticCredentials map[string]Credential
+
+func (s StaticCredentials) Credential(host string) (Credential, error) {
+	return s[host], nil
+}
+
+// DockerConfig is a CredentialStore reading the config.json of the docker cli including its credential helpers
+type DockerConfig struct {
+	Auths       map[string]dockerAuthEntry `json:"auths"`
+	CredsStore  string                     `json:"credsStore,omitempty"`
+	CredHelpers map[string]string          `json:"credHelpers,omitempty"`
+}
+
+type dockerAuthEntry struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// LoadDockerConfig reads a docker config.json from path
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &DockerConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("docker config %s cannot be parsed: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// DefaultDockerConfig reads the docker config.json from $DOCKER_CONFIG or ~/.docker.
+// A missing file results in an empty configuration.
+func DefaultDockerConfig() (*DockerConfig, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	cfg, err := LoadDockerConfig(filepath.Join(dir, dockerConfigFileName))
+	if os.IsNotExist(err) {
+		return &DockerConfig{}, nil
+	}
+
+	return cfg, err
+}
+
+func (cfg *DockerConfig) Credential(host string) (Credential, error) {
+	return cfg.CredentialContext(context.Background(), host)
+}
+
+// CredentialContext looks up the credential for host and kills credential helpers once ctx is done
+func (cfg *DockerConfig) CredentialContext(ctx context.Context, host string) (Credential, error) {
+	if helper, ok := cfg.CredHelpers[host]; ok && helper != "" {
+		return credentialFromHelper(ctx, helper, host)
+	}
+
+	if entry, ok := cfg.lookupAuth(host); ok {
+		return entry.credential()
+	}
+
+	if cfg.CredsStore != "" {
+		return credentialFromHelper(ctx, cfg.CredsStore, host)
+	}
+
+	return Credential{}, nil
+}
+
+// lookupAuth finds the entry for host allowing for the URL style keys older docker versions wrote
+func (cfg *DockerConfig) lookupAuth(host string) (dockerAuthEntry, bool) {
+	if entry, ok := cfg.Auths[host]; ok {
+		return entry, true
+	}
+
+	for key, entry := range cfg.Auths {
+		if key == dockerHubConfigKey && isDockerHub(host) {
+			return entry, true
+		}
+
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		if strings.SplitN(trimmed, "/", 2)[0] == host {
+			return entry, true
+		}
+	}
+
+	return dockerAuthEntry{}, false
+}
+
+func (entry dockerAuthEntry) credential() (Credential, error) {
+	cred := Credential{
+		Username:      entry.Username,
+		Password:      entry.Password,
+		IdentityToken: entry.IdentityToken,
+		RegistryToken: entry.RegistryToken,
+	}
+
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return Credential{}, fmt.Errorf("invalid auth entry in docker config: %w", err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return Credential{}, fmt.Errorf("invalid auth entry in docker config: missing password")
+		}
+		cred.Username, cred.Password = parts[0], parts[1]
+	}
+
+	return cred, nil
+}
+
+// credentialFromHelper runs docker-credential-<helper> get as described in
+// https://github.com/docker/docker-credential-helpers
+func credentialFromHelper(ctx context.Context, helper, host string) (Credential, error) {
+	serverURL := host
+	if isDockerHub(host) {
+		serverURL = dockerHubConfigKey
+	}
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return Credential{}, ctx.Err()
+		}
+		// Helpers report unknown servers on stdout and exit non zero
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return Credential{}, nil
+		}
+		return Credential{}, fmt.Errorf("credential helper %s failed: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return Credential{}, fmt.Errorf("credential helper %s returned invalid output: %w", helper, err)
+	}
+
+	if resp.Username == identityTokenUsername {
+		return Credential{IdentityToken: resp.Secret}, nil
+	}
+
+	return Credential{Username: resp.Username, Password: resp.Secret}, nil
+}
+
+func isDockerHub(host string) bool {
+	return host == "docker.io" || host == "index.docker.io" || host == "registry-1.docker.io"
+}
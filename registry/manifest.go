@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// maxManifestSize is the largest manifest the client accepts from a registry
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+var manifestMediaTypes = []string{
+	specsv1.MediaTypeImageManifest,
+	specsv1.MediaTypeImageIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}
+
+// GetManifest fetches a manifest by tag or digest. The returned descriptor describes the raw manifest bytes.
+// If the reference is a digest the content is verified against it.
+func (c *Client) GetManifest(ctx context.Context, repository, reference string) (specsv1.Descriptor, []byte, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := c.do(ctx, http.MethodGet, c.url(fmt.Sprintf("/v2/%s/manifests/%s", repository, reference)), header, nil)
+	if err != nil {
+		return specsv1.Descriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return specsv1.Descriptor{}, nil, newErrorResponse(resp)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return specsv1.Descriptor{}, nil, err
+	}
+	if len(data) > maxManifestSize {
+		return specsv1.Descriptor{}, nil, fmt.Errorf("manifest %s of %s exceeds %d bytes", reference, repository, maxManifestSize)
+	}
+
+	descr := specsv1.Descriptor{
+		MediaType: strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]),
+		Size:      int64(len(data)),
+	}
+
+	expected, err := digest.Parse(reference)
+	if err != nil {
+		// Reference is a tag. Trust the header of the registry if it uses the same algorithm.
+		expected, err = digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+		if err != nil {
+			expected = digest.FromBytes(data)
+		}
+	}
+
+	descr.Digest = expected.Algorithm().FromBytes(data)
+	if descr.Digest != expected {
+		return specsv1.Descriptor{}, nil, fmt.Errorf("%w: manifest %s of %s", oci.ErrDigestMismatch, reference, repository)
+	}
+
+	return descr, data, nil
+}
+
+// PutManifest uploads a manifest under the given reference which is either a tag or its digest
+func (c *Client) PutManifest(ctx context.Context, repository, reference, mediaType string, data []byte) (specsv1.Descriptor, error) {
+	descr, _, err := c.putManifest(ctx, repository, reference, mediaType, data)
+	return descr, err
+}
+
+// putManifest uploads a manifest and reports whether the registry processed its subject field
+func (c *Client) putManifest(ctx context.Context, repository, reference, mediaType string, data []byte) (specsv1.Descriptor, bool, error) {
+	descr := specsv1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url(fmt.Sprintf("/v2/%s/manifests/%s", repository, reference)).String(), bytes.NewReader(data))
+	if err != nil {
+		return specsv1.Descriptor{}, false, err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	req.ContentLength = descr.Size
+
+	resp, err := c.send(req)
+	if err != nil {
+		return specsv1.Descriptor{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return specsv1.Descriptor{}, false, newErrorResponse(resp)
+	}
+
+	return descr, resp.Header.Get(headerOCISubject) != "", nil
+}
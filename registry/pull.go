@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+// Pull downloads the image with the given tag or digest from the repository into the layout and
+// adds it to the index of the layout under localRef. Blobs already present in the layout are not downloaded again.
+// Blobs are downloaded in parallel and interrupted downloads are resumed from a temporary file in the layout,
+// even across calls. The index of the layout is only persisted once the layout is closed.
+func (c *Client) Pull(ctx context.Context, repository, reference string, layout *oci.ImageLayout, localRef string) (specsv1.Descriptor, error) {
+	descr, data, err := c.GetManifest(ctx, repository, reference)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	if err := c.pullManifest(ctx, repository, layout, descr, data); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	if localRef != "" {
+		descr.Annotations = map[string]string{
+			specsv1.AnnotationRefName: localRef,
+		}
+	}
+	layout.AddManifest(descr)
+
+	return descr, nil
+}
+
+func (c *Client) pullManifest(ctx context.Context, repository string, layout *oci.ImageLayout, descr specsv1.Descriptor, data []byte) error {
+	refs, err := parseManifestReferences(data)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range refs.Manifests {
+		childDescr, childData, err := c.GetManifest(ctx, repository, child.Digest.String())
+		if err != nil {
+			return err
+		}
+		if err := c.pullManifest(ctx, repository, layout, childDescr, childData); err != nil {
+			return err
+		}
+	}
+
+	blobs := refs.Layers
+	if refs.Config != nil {
+		blobs = append([]specsv1.Descriptor{*refs.Config}, blobs...)
+	}
+
+	if err := c.pullBlobs(ctx, repository, layout, blobs); err != nil {
+		return err
+	}
+
+	// The manifest is stored last so a layout never references a manifest whose blobs are missing
+	return storeBlob(layout, descr, bytes.NewReader(data))
+}
+
+// pullBlobs downloads the blobs with the configured number of workers. The first failure cancels all other downloads.
+func (c *Client) pullBlobs(ctx context.Context, repository string, layout *oci.ImageLayout, blobs []specsv1.Descriptor) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan specsv1.Descriptor)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for blob := range queue {
+				if err := c.pullBlob(ctx, repository, layout, blob); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	seen := make(map[string]bool)
+	for _, blob := range blobs {
+		// Two workers must never write the same temporary file
+		if seen[blob.Digest.String()] {
+			continue
+		}
+		seen[blob.Digest.String()] = true
+
+		select {
+		case queue <- blob:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
+
+// pullBlob downloads a single blob into a resumable temporary file of the layout and commits it once its digest is verified.
+// Interrupted transfers are continued with range requests. On failure the temporary file is kept for the next attempt.
+func (c *Client) pullBlob(ctx context.Context, repository string, layout *oci.ImageLayout, blob specsv1.Descriptor) error {
+	if layout.HasBlob(blob.Digest) {
+		return nil
+	}
+
+	wr, err := layout.NewResumableBlobWriter(blob)
+	if err != nil {
+		return err
+	}
+
+	if blob.Size > 0 && wr.Size() > blob.Size {
+		if err := wr.Reset(); err != nil {
+			_ = wr.Abort()
+			return err
+		}
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if blob.Size > 0 && wr.Size() == blob.Size {
+			break
+		}
+
+		lastErr = c.fetchInto(ctx, repository, blob, wr)
+		if lastErr == nil {
+			break
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		var errResp *ErrorResponse
+		if errors.As(lastErr, &errResp) {
+			// The registry answered, trying again will not change its mind
+			break
+		}
+	}
+
+	if lastErr != nil {
+		_ = wr.Suspend()
+		return lastErr
+	}
+
+	_, err = wr.Commit(blob.Digest)
+	return err
+}
+
+// fetchInto appends the missing content of the blob to the writer
+func (c *Client) fetchInto(ctx context.Context, repository string, blob specsv1.Descriptor, wr *oci.DescriptorWriter) error {
+	rd, resumed, err := c.fetchBlobFrom(ctx, repository, blob, wr.Size())
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	if !resumed {
+		if err := wr.Reset(); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(wr, rd)
+	return err
+}
+
+// storeBlob writes the content into the blobs directory of the layout if it matches the digest of descr
+func storeBlob(layout *oci.ImageLayout, descr specsv1.Descriptor, r io.Reader) error {
+	if layout.HasBlob(descr.Digest) {
+		return nil
+	}
+
+	wr, err := layout.NewBlobWriter(descr.MediaType, descr.Digest.Algorithm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(wr, r); err != nil {
+		_ = wr.Abort()
+		return err
+	}
+
+	_, err = wr.Commit(descr.Digest)
+	return err
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+// flakyBlobs cuts off the first response for every blob after half of its content
+type flakyBlobs struct {
+	next   http.Handler
+	mu     sync.Mutex
+	failed map[string]bool
+	ranges []string
+}
+
+func (f *flakyBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/blobs/sha256:") {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	if rng := r.Header.Get("Range"); rng != "" {
+		f.ranges = append(f.ranges, rng)
+	}
+	failed := f.failed[r.URL.Path]
+	f.failed[r.URL.Path] = true
+	f.mu.Unlock()
+
+	if failed {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	f.next.ServeHTTP(rec, r)
+	body := rec.Body.Bytes()
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(rec.Code)
+	_, _ = w.Write(body[:len(body)/2])
+}
+
+func TestPullResumesInterruptedDownloads(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	source := buildTestLayout(t, fs)
+
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	flaky := &flakyBlobs{next: NewHandler(served), failed: make(map[string]bool)}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, WithConcurrency(4))
+	require.NoError(t, err)
+	pushed, err := client.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.NoError(t, err)
+
+	repo, err := oci.OpenRepositoryFS(fs, "/repo")
+	require.NoError(t, err)
+	target, err := repo.CreateImageLayout("target")
+	require.NoError(t, err)
+
+	pulled, err := client.Pull(context.Background(), "library/test", "v1", target, "pulled")
+	require.NoError(t, err)
+	assert.Equal(t, pushed.Descriptor.Digest, pulled.Digest)
+
+	img, err := target.OpenImage("pulled")
+	require.NoError(t, err)
+	assert.True(t, target.HasBlob(img.Manifest().Layers[0].Digest))
+
+	// Config and layer were both resumed with a range request
+	assert.Len(t, flaky.ranges, 2)
+	for _, rng := range flaky.ranges {
+		assert.True(t, strings.HasPrefix(rng, "bytes="))
+	}
+
+	partials, err := afero.Glob(fs, "/repo/target/.partial.*")
+	require.NoError(t, err)
+	assert.Empty(t, partials)
+}
+
+func TestPullKeepsPartialDownloads(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	source := buildTestLayout(t, fs)
+
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	flaky := &flakyBlobs{next: NewHandler(served), failed: make(map[string]bool)}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	pusher, err := NewClient(server.URL)
+	require.NoError(t, err)
+	_, err = pusher.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.NoError(t, err)
+
+	repo, err := oci.OpenRepositoryFS(fs, "/repo")
+	require.NoError(t, err)
+	target, err := repo.CreateImageLayout("target")
+	require.NoError(t, err)
+
+	client, err := NewClient(server.URL, WithRetries(0), WithConcurrency(1))
+	require.NoError(t, err)
+	_, err = client.Pull(context.Background(), "library/test", "v1", target, "pulled")
+	require.Error(t, err)
+
+	partials, err := afero.Glob(fs, "/repo/target/.partial.*")
+	require.NoError(t, err)
+	assert.NotEmpty(t, partials)
+
+	// A later pull continues with the partial content of the first one
+	resuming, err := NewClient(server.URL)
+	require.NoError(t, err)
+	_, err = resuming.Pull(context.Background(), "library/test", "v1", target, "pulled")
+	require.NoError(t, err)
+	_, err = target.OpenImage("pulled")
+	require.NoError(t, err)
+	assert.NotEmpty(t, flaky.ranges)
+}
@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+// manifestReferences contains the fields of image manifests and indexes which point to other content.
+// It is used to walk manifests regardless if they are OCI or Docker manifests.
+type manifestReferences struct {
+	MediaType    string               `json:"mediaType,omitempty"`
+	ArtifactType string               `json:"artifactType,omitempty"`
+	Config       *specsv1.Descriptor  `json:"config,omitempty"`
+	Layers       []specsv1.Descriptor `json:"layers,omitempty"`
+	Manifests    []specsv1.Descriptor `json:"manifests,omitempty"`
+	Subject      *specsv1.Descriptor  `json:"subject,omitempty"`
+	Annotations  map[string]string    `json:"annotations,omitempty"`
+}
+
+// mediaType returns the media type of the manifest falling back to guessing it from its content
+func (refs manifestReferences) mediaType() string {
+	switch {
+	case refs.MediaType != "":
+		return refs.MediaType
+	case refs.Manifests != nil:
+		return specsv1.MediaTypeImageIndex
+	default:
+		return specsv1.MediaTypeImageManifest
+	}
+}
+
+func parseManifestReferences(data []byte) (manifestReferences, error) {
+	var refs manifestReferences
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return refs, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+	return refs, nil
+}
+
+// PushResult reports what happened to the blobs of a pushed image
+type PushResult struct {
+	// Descriptor of the pushed manifest or index
+	Descriptor specsv1.Descriptor
+	// Skipped blobs were already present in the target repository
+	Skipped []specsv1.Descriptor
+	// Mounted blobs were linked from another repository of the registry
+	Mounted []specsv1.Descriptor
+	// Uploaded blobs were transferred
+	Uploaded []specsv1.Descriptor
+}
+
+type pushConfig struct {
+	mountFrom []string
+}
+
+type PushOption func(*pushConfig)
+
+// MountFrom lists repositories of the same registry which likely contain blobs of the image, like the repository
+// of its base image. Blobs found in them are mounted into the target repository instead of being uploaded.
+func MountFrom(repositories ...string) PushOption {
+	return func(cfg *pushConfig) {
+		cfg.mountFrom = append(cfg.mountFrom, repositories...)
+	}
+}
+
+// Push uploads the image referenced by localRef in the layout to the repository and tags it with tag.
+// Indexes are pushed with all manifests they reference. Blobs already present in the repository are skipped,
+// blobs found in one of the MountFrom repositories are mounted and only the remaining ones are uploaded.
+func (c *Client) Push(ctx context.Context, layout *oci.ImageLayout, localRef, repository, tag string, opts ...PushOption) (*PushResult, error) {
+	descr, err := layout.Resolve(localRef)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &pushConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	result := &PushResult{}
+	result.Descriptor, err = c.pushManifest(ctx, layout, repository, descr, tag, cfg, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) pushManifest(ctx context.Context, layout *oci.ImageLayout, repository string, descr specsv1.Descriptor, reference string, cfg *pushConfig, result *PushResult) (specsv1.Descriptor, error) {
+	data, err := readBlob(layout, descr)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	refs, err := parseManifestReferences(data)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	for _, child := range refs.Manifests {
+		if _, err := c.pushManifest(ctx, layout, repository, child, child.Digest.String(), cfg, result); err != nil {
+			return specsv1.Descriptor{}, err
+		}
+	}
+
+	blobs := refs.Layers
+	if refs.Config != nil {
+		blobs = append([]specsv1.Descriptor{*refs.Config}, blobs...)
+	}
+
+	for _, blob := range blobs {
+		if err := c.pushBlob(ctx, layout, repository, blob, cfg, result); err != nil {
+			return specsv1.Descriptor{}, err
+		}
+	}
+
+	mediaType := descr.MediaType
+	if mediaType == "" {
+		mediaType = refs.mediaType()
+	}
+
+	if reference == "" {
+		reference = descr.Digest.String()
+	}
+
+	pushed, subjectSupported, err := c.putManifest(ctx, repository, reference, mediaType, data)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	// Registries without referrers API need the referrers tag schema to be maintained by the client
+	if refs.Subject != nil && !subjectSupported {
+		if err := c.addToReferrersTag(ctx, repository, refs, pushed); err != nil {
+			return specsv1.Descriptor{}, err
+		}
+	}
+
+	return pushed, nil
+}
+
+func (c *Client) pushBlob(ctx context.Context, layout *oci.ImageLayout, repository string, blob specsv1.Descriptor, cfg *pushConfig, result *PushResult) error {
+	exists, err := c.BlobExists(ctx, repository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		result.Skipped = append(result.Skipped, blob)
+		return nil
+	}
+
+	location, err := c.mountOrStartUpload(ctx, repository, blob, cfg.mountFrom)
+	if err != nil {
+		return err
+	}
+	if location == nil {
+		result.Mounted = append(result.Mounted, blob)
+		return nil
+	}
+
+	rd, err := layout.OpenBlob(blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	if err := c.finishUpload(ctx, location, blob, rd); err != nil {
+		return err
+	}
+
+	result.Uploaded = append(result.Uploaded, blob)
+	return nil
+}
+
+// mountOrStartUpload tries to mount the blob from the first source repository containing it.
+// It returns a nil location if the blob was mounted, otherwise the location of an upload session.
+func (c *Client) mountOrStartUpload(ctx context.Context, repository string, blob specsv1.Descriptor, sources []string) (*url.URL, error) {
+	for _, source := range sources {
+		if source == repository {
+			continue
+		}
+
+		exists, err := c.BlobExists(ctx, source, blob.Digest)
+		if err != nil || !exists {
+			// Missing permissions on the source are no reason to fail the push
+			continue
+		}
+
+		query := url.Values{}
+		query.Set("mount", blob.Digest.String())
+		query.Set("from", source)
+		mounted, location, err := c.startUploadSession(ctx, repository, query)
+		if err != nil {
+			return nil, err
+		}
+		if mounted {
+			return nil, nil
+		}
+
+		// The registry declined to mount and opened an upload session instead
+		return location, nil
+	}
+
+	return c.startUpload(ctx, repository, nil)
+}
+
+func readBlob(layout *oci.ImageLayout, descr specsv1.Descriptor) ([]byte, error) {
+	rd, err := layout.OpenBlob(descr.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	if descr.Digest.Algorithm().FromBytes(data) != descr.Digest {
+		return nil, fmt.Errorf("%w: blob %s in layout", oci.ErrDigestMismatch, descr.Digest)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+const (
+	// headerOCISubject is set by registries which processed the subject of a pushed manifest
+	headerOCISubject = "OCI-Subject"
+	// headerOCIFiltersApplied lists the filters a registry applied to a referrers response
+	headerOCIFiltersApplied = "OCI-Filters-Applied"
+)
+
+// Referrers lists the manifests in the repository whose subject is the manifest with the given digest.
+// If artifactType is not empty only referrers of that type are returned. Registries without referrers API
+// are queried through the referrers tag schema.
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#listing-referrers
+func (c *Client) Referrers(ctx context.Context, repository string, subject digest.Digest, artifactType string) ([]specsv1.Descriptor, error) {
+	u := c.url(fmt.Sprintf("/v2/%s/referrers/%s", repository, subject))
+	if artifactType != "" {
+		u.RawQuery = url.Values{"artifactType": []string{artifactType}}.Encode()
+	}
+
+	header := http.Header{}
+	header.Set("Accept", specsv1.MediaTypeImageIndex)
+
+	resp, err := c.do(ctx, http.MethodGet, u, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var index specsv1.Index
+	filtered := false
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			return nil, fmt.Errorf("invalid referrers response: %w", err)
+		}
+		filtered = resp.Header.Get(headerOCIFiltersApplied) == "artifactType"
+	case http.StatusNotFound:
+		index, err = c.referrersTagIndex(ctx, repository, subject)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, newErrorResponse(resp)
+	}
+
+	if artifactType == "" || filtered {
+		return index.Manifests, nil
+	}
+
+	referrers := make([]specsv1.Descriptor, 0)
+	for _, descr := range index.Manifests {
+		if descr.ArtifactType == artifactType {
+			referrers = append(referrers, descr)
+		}
+	}
+	return referrers, nil
+}
+
+// PushReferrers pushes all manifests in the index of the layout which refer to subject
+func (c *Client) PushReferrers(ctx context.Context, layout *oci.ImageLayout, subject digest.Digest, repository string, opts ...PushOption) ([]*PushResult, error) {
+	referrers, err := layout.Referrers(subject, "")
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*PushResult, 0, len(referrers))
+	for _, referrer := range referrers {
+		result, err := c.Push(ctx, layout, referrer.Digest.String(), repository, "", opts...)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// referrersTag returns the tag the referrers tag schema uses for the subject
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#referrers-tag-schema
+func referrersTag(subject digest.Digest) string {
+	tag := subject.Algorithm().String() + "-" + subject.Encoded()
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
+
+func (c *Client) referrersTagIndex(ctx context.Context, repository string, subject digest.Digest) (specsv1.Index, error) {
+	index := specsv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: specsv1.MediaTypeImageIndex,
+		Manifests: make([]specsv1.Descriptor, 0),
+	}
+
+	_, data, err := c.GetManifest(ctx, repository, referrersTag(subject))
+	var errResp *ErrorResponse
+	if errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("invalid referrers tag index for %s: %w", subject, err)
+	}
+
+	return index, nil
+}
+
+// addToReferrersTag records the pushed manifest in the index tagged by the referrers tag schema of its subject
+func (c *Client) addToReferrersTag(ctx context.Context, repository string, refs manifestReferences, pushed specsv1.Descriptor) error {
+	index, err := c.referrersTagIndex(ctx, repository, refs.Subject.Digest)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range index.Manifests {
+		if existing.Digest == pushed.Digest {
+			return nil
+		}
+	}
+
+	referrer := specsv1.Descriptor{
+		MediaType:    pushed.MediaType,
+		Digest:       pushed.Digest,
+		Size:         pushed.Size,
+		ArtifactType: refs.ArtifactType,
+		Annotations:  refs.Annotations,
+	}
+	if referrer.ArtifactType == "" && refs.Config != nil {
+		referrer.ArtifactType = refs.Config.MediaType
+	}
+	index.Manifests = append(index.Manifests, referrer)
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.PutManifest(ctx, repository, referrersTag(refs.Subject.Digest), specsv1.MediaTypeImageIndex, data)
+	return err
+}
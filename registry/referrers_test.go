@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+// addTestReferrer attaches an artifact of the given type to the image tagged latest in the layout
+func addTestReferrer(t *testing.T, layout *oci.ImageLayout, artifactType string) specsv1.Descriptor {
+	subject, err := layout.Resolve("latest")
+	require.NoError(t, err)
+
+	artifact := layout.CreateArtifact(artifactType, &subject)
+	_, err = artifact.AddBlob(artifactType, strings.NewReader("content of "+artifactType), nil)
+	require.NoError(t, err)
+	descr, err := layout.SaveArtifact(artifact)
+	require.NoError(t, err)
+
+	return descr
+}
+
+func TestReferrers(t *testing.T) {
+	for name, handler := range map[string]func() http.Handler{
+		"referrers API": func() http.Handler {
+			served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+			require.NoError(t, err)
+			return NewHandler(served)
+		},
+		"tag schema": func() http.Handler {
+			return newTestRegistry()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(handler())
+			defer server.Close()
+
+			source := buildTestLayout(t, afero.NewMemMapFs())
+			sig := addTestReferrer(t, source, "application/vnd.example.signature")
+			addTestReferrer(t, source, "application/vnd.example.sbom")
+
+			client, err := NewClient(server.URL)
+			require.NoError(t, err)
+
+			result, err := client.Push(context.Background(), source, "latest", "library/test", "v1")
+			require.NoError(t, err)
+			subject := result.Descriptor.Digest
+
+			results, err := client.PushReferrers(context.Background(), source, subject, "library/test")
+			require.NoError(t, err)
+			assert.Len(t, results, 2)
+
+			referrers, err := client.Referrers(context.Background(), "library/test", subject, "")
+			require.NoError(t, err)
+			assert.Len(t, referrers, 2)
+
+			referrers, err = client.Referrers(context.Background(), "library/test", subject, "application/vnd.example.signature")
+			require.NoError(t, err)
+			require.Len(t, referrers, 1)
+			assert.Equal(t, sig.Digest, referrers[0].Digest)
+			assert.Equal(t, "application/vnd.example.signature", referrers[0].ArtifactType)
+		})
+	}
+}
@@ -0,0 +1,779 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+// Error codes of the distribution API
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#error-codes
+const (
+	ErrCodeBlobUnknown         = "BLOB_UNKNOWN"
+	ErrCodeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	ErrCodeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	ErrCodeDigestInvalid       = "DIGEST_INVALID"
+	ErrCodeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	ErrCodeManifestInvalid     = "MANIFEST_INVALID"
+	ErrCodeManifestUnknown     = "MANIFEST_UNKNOWN"
+	ErrCodeNameInvalid         = "NAME_INVALID"
+	ErrCodeNameUnknown         = "NAME_UNKNOWN"
+	ErrCodeSizeInvalid         = "SIZE_INVALID"
+	ErrCodeUnsupported         = "UNSUPPORTED"
+)
+
+var (
+	repositoryNameRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp            = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+)
+
+// DefaultUploadTimeout is how long a blob upload may be idle before the Handler discards it
+const DefaultUploadTimeout = time.Hour
+
+// Handler serves the image layouts of a Repository as a registry following the OCI distribution specification.
+// Each image layout is exposed as a repository of the same name and its reference names are the tags.
+type Handler struct {
+	repo          *oci.Repository
+	uploadTimeout time.Duration
+
+	// mu guards the upload sessions but is never held while content is transferred
+	mu      sync.Mutex
+	uploads map[string]*uploadSession
+	// layouts serialize the reads and writes of the index of each layout. Names share a fixed number of locks
+	// thus clients cannot grow the table by asking for unknown repositories. A request holds one of them at a time.
+	layouts [layoutLockStripes]sync.RWMutex
+}
+
+// layoutLockStripes is the number of locks the layouts are spread across
+const layoutLockStripes = 64
+
+// HandlerOption configures a Handler
+type HandlerOption func(*Handler)
+
+// WithUploadTimeout sets how long a blob upload may be idle before it is discarded
+func WithUploadTimeout(timeout time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.uploadTimeout = timeout
+	}
+}
+
+// uploadSession is a blob upload in progress
+type uploadSession struct {
+	name   string
+	layout *oci.ImageLayout
+
+	// mu serializes the requests of the session
+	mu      sync.Mutex
+	writer  *oci.DescriptorWriter
+	offset  int64
+	updated time.Time
+	// done is set once the upload is committed, aborted or expired
+	done bool
+}
+
+func NewHandler(repo *oci.Repository, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		repo:          repo,
+		uploadTimeout: DefaultUploadTimeout,
+		uploads:       make(map[string]*uploadSession),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == r.URL.Path {
+		writeError(w, http.StatusNotFound, ErrCodeUnsupported, "not a distribution API endpoint")
+		return
+	}
+
+	switch {
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case path == "_catalog":
+		h.serveCatalog(w, r)
+	case strings.HasSuffix(path, "/tags/list"):
+		h.withName(w, strings.TrimSuffix(path, "/tags/list"), func(name string) {
+			h.serveTags(w, r, name)
+		})
+	case strings.Contains(path, "/referrers/"):
+		idx := strings.LastIndex(path, "/referrers/")
+		h.withName(w, path[:idx], func(name string) {
+			h.serveReferrers(w, r, name, path[idx+len("/referrers/"):])
+		})
+	case strings.Contains(path, "/blobs/uploads"):
+		idx := strings.LastIndex(path, "/blobs/uploads")
+		h.withName(w, path[:idx], func(name string) {
+			h.serveUpload(w, r, name, strings.Trim(path[idx+len("/blobs/uploads"):], "/"))
+		})
+	case strings.Contains(path, "/blobs/"):
+		idx := strings.LastIndex(path, "/blobs/")
+		h.withName(w, path[:idx], func(name string) {
+			h.serveBlob(w, r, name, path[idx+len("/blobs/"):])
+		})
+	case strings.Contains(path, "/manifests/"):
+		idx := strings.LastIndex(path, "/manifests/")
+		h.withName(w, path[:idx], func(name string) {
+			h.serveManifest(w, r, name, path[idx+len("/manifests/"):])
+		})
+	default:
+		writeError(w, http.StatusNotFound, ErrCodeUnsupported, "unknown endpoint")
+	}
+}
+
+func (h *Handler) withName(w http.ResponseWriter, name string, fn func(name string)) {
+	if !repositoryNameRegexp.MatchString(name) {
+		writeError(w, http.StatusBadRequest, ErrCodeNameInvalid, fmt.Sprintf("invalid repository name %s", name))
+		return
+	}
+	fn(name)
+}
+
+func (h *Handler) serveCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+
+	names, err := h.repo.ListImageLayouts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnsupported, err.Error())
+		return
+	}
+
+	writeJSON(w, struct {
+		Repositories []string `json:"repositories"`
+	}{paginate(w, r, names)})
+}
+
+func (h *Handler) serveTags(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+
+	lock := h.layoutLock(name)
+	lock.RLock()
+	layout, ok := h.openLayout(w, name)
+	lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	writeJSON(w, struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{name, paginate(w, r, layout.Tags())})
+}
+
+func (h *Handler) serveReferrers(w http.ResponseWriter, r *http.Request, name, reference string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+
+	subject, err := digest.Parse(reference)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, err.Error())
+		return
+	}
+
+	index := specsv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: specsv1.MediaTypeImageIndex,
+		Manifests: make([]specsv1.Descriptor, 0),
+	}
+
+	lock := h.layoutLock(name)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	// Unknown repositories and subjects have no referrers but are no error
+	if h.repo.HasImageLayout(name) {
+		layout, err := h.repo.OpenImageLayout(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeNameUnknown, err.Error())
+			return
+		}
+
+		artifactType := r.URL.Query().Get("artifactType")
+		if index.Manifests, err = layout.Referrers(subject, artifactType); err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeManifestInvalid, err.Error())
+			return
+		}
+		if artifactType != "" {
+			w.Header().Set(headerOCIFiltersApplied, "artifactType")
+		}
+	}
+
+	w.Header().Set("Content-Type", specsv1.MediaTypeImageIndex)
+	_ = json.NewEncoder(w).Encode(index)
+}
+
+func (h *Handler) serveBlob(w http.ResponseWriter, r *http.Request, name, reference string) {
+	d, err := digest.Parse(reference)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, err.Error())
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+		return
+	}
+
+	rd, ok := h.openBlob(w, name, d)
+	if !ok {
+		return
+	}
+	defer rd.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Etag", `"`+d.String()+`"`)
+	http.ServeContent(w, r, "", time.Time{}, rd)
+}
+
+// openBlob opens the blob d of the layout name. The layout is only locked until the blob is opened.
+func (h *Handler) openBlob(w http.ResponseWriter, name string, d digest.Digest) (*oci.DescriptorReader, bool) {
+	lock := h.layoutLock(name)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	layout, ok := h.openLayout(w, name)
+	if !ok {
+		return nil, false
+	}
+
+	if !layout.HasBlob(d) {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUnknown, fmt.Sprintf("blob %s unknown to repository %s", d, name))
+		return nil, false
+	}
+
+	rd, err := layout.OpenBlob(d)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeBlobUnknown, err.Error())
+		return nil, false
+	}
+
+	return rd, true
+}
+
+func (h *Handler) serveManifest(w http.ResponseWriter, r *http.Request, name, reference string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.getManifest(w, r, name, reference)
+	case http.MethodPut:
+		h.putManifest(w, r, name, reference)
+	case http.MethodDelete:
+		h.deleteManifest(w, name, reference)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+	}
+}
+
+func (h *Handler) getManifest(w http.ResponseWriter, r *http.Request, name, reference string) {
+	descr, data, ok := h.readManifest(w, name, reference)
+	if !ok {
+		return
+	}
+
+	mediaType := descr.MediaType
+	if mediaType == "" {
+		refs, err := parseManifestReferences(data)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeManifestInvalid, err.Error())
+			return
+		}
+		mediaType = refs.mediaType()
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Docker-Content-Digest", descr.Digest.String())
+	w.Header().Set("Etag", `"`+descr.Digest.String()+`"`)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+// readManifest resolves reference in the layout name and reads the manifest while the layout is locked
+func (h *Handler) readManifest(w http.ResponseWriter, name, reference string) (specsv1.Descriptor, []byte, bool) {
+	lock := h.layoutLock(name)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	layout, ok := h.openLayout(w, name)
+	if !ok {
+		return specsv1.Descriptor{}, nil, false
+	}
+
+	descr, err := layout.Resolve(reference)
+	if err != nil {
+		// Manifests referenced by an index or pushed by digest only are not part of the index of the layout
+		d, parseErr := digest.Parse(reference)
+		if parseErr != nil || !layout.HasBlob(d) {
+			writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, fmt.Sprintf("manifest %s unknown to repository %s", reference, name))
+			return specsv1.Descriptor{}, nil, false
+		}
+		descr = specsv1.Descriptor{Digest: d}
+	}
+
+	rd, err := layout.OpenBlob(descr.Digest)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, err.Error())
+		return specsv1.Descriptor{}, nil, false
+	}
+	defer rd.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rd, maxManifestSize+1))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeManifestInvalid, err.Error())
+		return specsv1.Descriptor{}, nil, false
+	}
+	// Serving a prefix would hand out content not matching the digest
+	if len(data) > maxManifestSize {
+		writeError(w, http.StatusInternalServerError, ErrCodeManifestInvalid, fmt.Sprintf("manifest %s exceeds %d bytes", descr.Digest, maxManifestSize))
+		return specsv1.Descriptor{}, nil, false
+	}
+
+	return descr, data, true
+}
+
+func (h *Handler) putManifest(w http.ResponseWriter, r *http.Request, name, reference string) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, err.Error())
+		return
+	}
+	if len(data) > maxManifestSize {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodeSizeInvalid, "manifest too large")
+		return
+	}
+
+	refs, err := parseManifestReferences(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, err.Error())
+		return
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if mediaType == "" {
+		mediaType = refs.mediaType()
+	}
+
+	tag := ""
+	d := digest.FromBytes(data)
+	if expected, err := digest.Parse(reference); err == nil {
+		d = expected.Algorithm().FromBytes(data)
+		if d != expected {
+			writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, "manifest does not match digest")
+			return
+		}
+	} else if tagRegexp.MatchString(reference) {
+		tag = reference
+	} else {
+		writeError(w, http.StatusBadRequest, ErrCodeManifestInvalid, fmt.Sprintf("invalid tag %s", reference))
+		return
+	}
+
+	lock := h.layoutLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	layout, err := h.openOrCreateLayout(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeNameUnknown, err.Error())
+		return
+	}
+
+	blobs := append([]specsv1.Descriptor{}, refs.Layers...)
+	if refs.Config != nil {
+		blobs = append(blobs, *refs.Config)
+	}
+	blobs = append(blobs, refs.Manifests...)
+	for _, blob := range blobs {
+		if !layout.HasBlob(blob.Digest) {
+			writeError(w, http.StatusBadRequest, ErrCodeManifestBlobUnknown, fmt.Sprintf("blob %s unknown to repository %s", blob.Digest, name))
+			return
+		}
+	}
+
+	descr := specsv1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+	if err := storeBlob(layout, descr, bytes.NewReader(data)); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeManifestInvalid, err.Error())
+		return
+	}
+
+	// Manifests pushed by digest are added to the index as well so they are not considered garbage
+	if tag != "" {
+		descr.Annotations = map[string]string{specsv1.AnnotationRefName: tag}
+		layout.AddManifest(descr)
+	} else if _, err := layout.Resolve(d.String()); err != nil {
+		layout.AddManifest(descr)
+	}
+
+	if err := layout.Close(); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeManifestInvalid, err.Error())
+		return
+	}
+
+	if refs.Subject != nil {
+		w.Header().Set(headerOCISubject, refs.Subject.Digest.String())
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, d))
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) deleteManifest(w http.ResponseWriter, name, reference string) {
+	lock := h.layoutLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	layout, ok := h.openLayout(w, name)
+	if !ok {
+		return
+	}
+
+	if !layout.RemoveManifest(reference) {
+		writeError(w, http.StatusNotFound, ErrCodeManifestUnknown, fmt.Sprintf("manifest %s unknown to repository %s", reference, name))
+		return
+	}
+
+	if err := layout.Close(); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeManifestUnknown, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) serveUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	if id == "" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+			return
+		}
+		h.startUpload(w, r, name)
+		return
+	}
+
+	session := h.lockUpload(name, id)
+	if session == nil {
+		writeError(w, http.StatusNotFound, ErrCodeBlobUploadUnknown, fmt.Sprintf("upload %s unknown", id))
+		return
+	}
+	defer session.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeUploadStatus(w, name, id, session, http.StatusNoContent)
+	case http.MethodPatch:
+		if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+			start, err := strconv.ParseInt(strings.SplitN(contentRange, "-", 2)[0], 10, 64)
+			if err != nil || start != session.offset {
+				writeUploadStatus(w, name, id, session, http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		if !h.appendUpload(w, session, r.Body) {
+			return
+		}
+		writeUploadStatus(w, name, id, session, http.StatusAccepted)
+	case http.MethodPut:
+		if !h.appendUpload(w, session, r.Body) {
+			return
+		}
+		h.finishUpload(w, name, id, session, r.URL.Query().Get("digest"))
+	case http.MethodDelete:
+		h.abortUpload(id, session)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeUnsupported, "method not allowed")
+	}
+}
+
+func (h *Handler) startUpload(w http.ResponseWriter, r *http.Request, name string) {
+	lock := h.layoutLock(name)
+	lock.Lock()
+	layout, err := h.openOrCreateLayout(name)
+	lock.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeNameUnknown, err.Error())
+		return
+	}
+
+	if mount, from := r.URL.Query().Get("mount"), r.URL.Query().Get("from"); mount != "" && from != "" {
+		if d, ok := h.mountBlob(layout, mount, from); ok {
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, d))
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		// Not mountable, the client gets a regular upload session
+	}
+
+	writer, err := layout.NewBlobWriter("application/octet-stream", digest.Canonical)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeBlobUploadInvalid, err.Error())
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		_ = writer.Abort()
+		writeError(w, http.StatusInternalServerError, ErrCodeBlobUploadInvalid, err.Error())
+		return
+	}
+
+	session := &uploadSession{name: name, layout: layout, writer: writer, updated: time.Now()}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	h.mu.Lock()
+	h.expireUploads()
+	h.uploads[id] = session
+	h.mu.Unlock()
+
+	// Monolithic upload in a single POST request, the client cannot resume it once it failed
+	if d := r.URL.Query().Get("digest"); d != "" {
+		if !h.appendUpload(w, session, r.Body) {
+			h.abortUpload(id, session)
+			return
+		}
+		h.finishUpload(w, name, id, session, d)
+		return
+	}
+
+	writeUploadStatus(w, name, id, session, http.StatusAccepted)
+}
+
+// lockUpload returns the session id of the repository name with its lock held or nil if there is no such session
+func (h *Handler) lockUpload(name, id string) *uploadSession {
+	h.mu.Lock()
+	h.expireUploads()
+	session, ok := h.uploads[id]
+	h.mu.Unlock()
+	if !ok || session.name != name {
+		return nil
+	}
+
+	session.mu.Lock()
+	if session.done {
+		session.mu.Unlock()
+		return nil
+	}
+	return session
+}
+
+// abortUpload discards the session id. The lock of the session must be held.
+func (h *Handler) abortUpload(id string, session *uploadSession) {
+	_ = session.writer.Abort()
+	h.removeUpload(id, session)
+}
+
+// removeUpload forgets the session id. The lock of the session must be held.
+func (h *Handler) removeUpload(id string, session *uploadSession) {
+	session.done = true
+	h.mu.Lock()
+	delete(h.uploads, id)
+	h.mu.Unlock()
+}
+
+// expireUploads aborts the sessions which were idle for longer than the upload timeout. Sessions busy with a request
+// are not idle. h.mu must be held.
+func (h *Handler) expireUploads() {
+	for id, session := range h.uploads {
+		if !session.mu.TryLock() {
+			continue
+		}
+		if time.Since(session.updated) > h.uploadTimeout {
+			_ = session.writer.Abort()
+			session.done = true
+			delete(h.uploads, id)
+		}
+		session.mu.Unlock()
+	}
+}
+
+// layoutLock returns the lock of the layout name
+func (h *Handler) layoutLock(name string) *sync.RWMutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	return &h.layouts[hash.Sum32()%layoutLockStripes]
+}
+
+// mountBlob stores a blob of the layout of another repository in layout. With shared blobs the blob is linked,
+// otherwise its content is copied, but either way the client saves the upload.
+func (h *Handler) mountBlob(layout *oci.ImageLayout, mount, from string) (digest.Digest, bool) {
+	d, err := digest.Parse(mount)
+	if err != nil || !repositoryNameRegexp.MatchString(from) {
+		return "", false
+	}
+
+	if layout.HasBlob(d) {
+		return d, true
+	}
+
+	rd, err := h.openMountSource(from, d)
+	if err != nil {
+		return "", false
+	}
+	defer rd.Close()
+
+	if err := storeBlob(layout, specsv1.Descriptor{Digest: d}, rd); err != nil {
+		return "", false
+	}
+
+	return d, true
+}
+
+// openMountSource opens the blob d of the layout from. The layout is only locked until the blob is opened.
+func (h *Handler) openMountSource(from string, d digest.Digest) (*oci.DescriptorReader, error) {
+	lock := h.layoutLock(from)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	source, err := h.repo.OpenImageLayout(from)
+	if err != nil {
+		return nil, err
+	}
+	return source.OpenBlob(d)
+}
+
+func (h *Handler) appendUpload(w http.ResponseWriter, session *uploadSession, body io.Reader) bool {
+	n, err := io.Copy(session.writer, body)
+	session.offset += n
+	session.updated = time.Now()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeBlobUploadInvalid, err.Error())
+		return false
+	}
+	return true
+}
+
+func (h *Handler) finishUpload(w http.ResponseWriter, name, id string, session *uploadSession, rawDigest string) {
+	h.removeUpload(id, session)
+
+	d, err := digest.Parse(rawDigest)
+	if err != nil {
+		_ = session.writer.Abort()
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, err.Error())
+		return
+	}
+
+	if _, err := session.writer.Commit(d); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeDigestInvalid, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, d))
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) openLayout(w http.ResponseWriter, name string) (*oci.ImageLayout, bool) {
+	if !h.repo.HasImageLayout(name) {
+		writeError(w, http.StatusNotFound, ErrCodeNameUnknown, fmt.Sprintf("repository %s unknown", name))
+		return nil, false
+	}
+
+	layout, err := h.repo.OpenImageLayout(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeNameUnknown, err.Error())
+		return nil, false
+	}
+
+	return layout, true
+}
+
+func (h *Handler) openOrCreateLayout(name string) (*oci.ImageLayout, error) {
+	if h.repo.HasImageLayout(name) {
+		return h.repo.OpenImageLayout(name)
+	}
+	return h.repo.CreateImageLayout(name)
+}
+
+func writeUploadStatus(w http.ResponseWriter, name, id string, session *uploadSession, status int) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	end := session.offset - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+// paginate applies the n and last query parameters to a sorted list and sets the Link header if more entries follow
+func paginate(w http.ResponseWriter, r *http.Request, entries []string) []string {
+	sort.Strings(entries)
+	if last := r.URL.Query().Get("last"); last != "" {
+		idx := sort.SearchStrings(entries, last)
+		if idx < len(entries) && entries[idx] == last {
+			idx++
+		}
+		entries = entries[idx:]
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 0 || n >= len(entries) {
+		return entries
+	}
+
+	entries = entries[:n]
+	if n > 0 {
+		next := *r.URL
+		query := next.Query()
+		query.Set("last", entries[n-1])
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	return entries
+}
+
+func newUploadID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{
+		Errors: []ErrorDetail{{Code: code, Message: message}},
+	})
+}
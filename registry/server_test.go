@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+func TestHandlerRoundTrip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	source := buildTestLayout(t, fs)
+
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	server := httptest.NewServer(NewHandler(served))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, WithChunkSize(1024))
+	require.NoError(t, err)
+	require.NoError(t, client.Ping(context.Background()))
+
+	pushed, err := client.Push(context.Background(), source, "latest", "library/test", "v1")
+	require.NoError(t, err)
+	_, err = client.Push(context.Background(), source, "latest", "library/test", "v2")
+	require.NoError(t, err)
+
+	assert.True(t, served.HasImageLayout("library/test"))
+
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	getJSON(t, server.URL+"/v2/_catalog", &catalog)
+	assert.Equal(t, []string{"library/test"}, catalog.Repositories)
+
+	var tags struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	getJSON(t, server.URL+"/v2/library/test/tags/list", &tags)
+	assert.Equal(t, []string{"v1", "v2"}, tags.Tags)
+
+	getJSON(t, server.URL+"/v2/library/test/tags/list?n=1", &tags)
+	assert.Equal(t, []string{"v1"}, tags.Tags)
+
+	repo, err := oci.OpenRepositoryFS(fs, "/repo")
+	require.NoError(t, err)
+	target, err := repo.CreateImageLayout("target")
+	require.NoError(t, err)
+
+	pulled, err := client.Pull(context.Background(), "library/test", "v1", target, "pulled")
+	require.NoError(t, err)
+	assert.Equal(t, pushed.Descriptor.Digest, pulled.Digest)
+	assert.Equal(t, specsv1.MediaTypeImageManifest, pulled.MediaType)
+
+	img, err := target.OpenImage("pulled")
+	require.NoError(t, err)
+	require.Len(t, img.Manifest().Layers, 1)
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/v2/library/test/manifests/v2", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	_, _, err = client.GetManifest(context.Background(), "library/test", "v2")
+	require.Error(t, err)
+	errResp, ok := err.(*ErrorResponse)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, errResp.StatusCode)
+	assert.Equal(t, ErrCodeManifestUnknown, errResp.Errors[0].Code)
+
+	_, _, err = client.GetManifest(context.Background(), "library/test", pushed.Descriptor.Digest.String())
+	require.NoError(t, err)
+}
+
+func TestHandlerRejectsInvalidNames(t *testing.T) {
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	server := httptest.NewServer(NewHandler(served))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v2/../etc/manifests/latest")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/v2/Upper/manifests/latest")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHandlerOversizedManifest(t *testing.T) {
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	layout, err := served.CreateImageLayout("library/test")
+	require.NoError(t, err)
+	data := strings.Repeat("x", maxManifestSize+1)
+	d := digest.FromString(data)
+	require.NoError(t, storeBlob(layout, specsv1.Descriptor{Digest: d}, strings.NewReader(data)))
+
+	server := httptest.NewServer(NewHandler(served))
+	defer server.Close()
+
+	// A stored manifest over the limit is not served truncated under its digest
+	resp, err := http.Get(server.URL + "/v2/library/test/manifests/" + d.String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestHandlerUploads(t *testing.T) {
+	served, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/served")
+	require.NoError(t, err)
+	handler := NewHandler(served, WithUploadTimeout(50*time.Millisecond))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Idle uploads expire
+	resp, err := http.Post(server.URL+"/v2/library/test/blobs/uploads/", "application/octet-stream", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	location := resp.Header.Get("Location")
+
+	time.Sleep(100 * time.Millisecond)
+	resp, err = http.Get(server.URL + location)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// A failed monolithic upload leaves no session behind
+	req := httptest.NewRequest(http.MethodPost, "/v2/library/test/blobs/uploads/?digest=sha256:"+strings.Repeat("0", 64), io.MultiReader(strings.NewReader("data"), failingReader{}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	handler.mu.Lock()
+	assert.Empty(t, handler.uploads)
+	handler.mu.Unlock()
+
+	// A slow upload does not block other requests, not even of the same repository
+	body, writer := io.Pipe()
+	defer writer.Close()
+	resp, err = http.Post(server.URL+"/v2/library/test/blobs/uploads/", "application/octet-stream", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	req, err = http.NewRequest(http.MethodPatch, server.URL+resp.Header.Get("Location"), body)
+	require.NoError(t, err)
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	_, err = writer.Write([]byte("partial"))
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var tags struct {
+			Tags []string `json:"tags"`
+		}
+		getJSON(t, server.URL+"/v2/library/test/tags/list", &tags)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request blocked by an upload in progress")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
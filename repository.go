@@ -2,7 +2,9 @@ package oci
 
 import (
 	"errors"
+	"os"
 	"path/filepath"
+	"sort"
 
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/spf13/afero"
@@ -15,6 +17,13 @@ var (
 
 type Repository struct {
 	fs afero.Fs
+	// base and root locate the repository on the filesystem it was opened from to hardlink shared blobs
+	base   afero.Fs
+	root   string
+	logger Logger
+	// blobs replaces the blobs directories of all layouts if set
+	blobs       BlobStore
+	sharedBlobs bool
 }
 
 // Helper Method which creates an empty repository if it does not exists on path
@@ -24,7 +33,7 @@ func CreateRepository(path string) (*Repository, error) {
 }
 
 func CreateRepositoryFS(fs afero.Fs, path string) (*Repository, error) {
-	if IsRepository(path) {
+	if IsRepositoryFs(fs, path) {
 		return nil, ErrRepoExists
 	}
 
@@ -32,7 +41,7 @@ func CreateRepositoryFS(fs afero.Fs, path string) (*Repository, error) {
 		return nil, err
 	}
 
-	return &Repository{fs: afero.NewBasePathFs(fs, path)}, nil
+	return &Repository{fs: afero.NewBasePathFs(fs, path), base: fs, root: path, logger: nopLogger{}}, nil
 }
 
 // Helper function which opens an existing repository
@@ -42,11 +51,23 @@ func OpenRepository(path string) (*Repository, error) {
 }
 
 func OpenRepositoryFS(fs afero.Fs, path string) (*Repository, error) {
-	if !IsRepository(path) {
+	if !IsRepositoryFs(fs, path) {
 		return nil, ErrNoRepository
 	}
 
-	return &Repository{fs: afero.NewBasePathFs(fs, path)}, nil
+	return &Repository{fs: afero.NewBasePathFs(fs, path), base: fs, root: path, logger: nopLogger{}}, nil
+}
+
+// SetLogger makes the repository and the layouts opened or created afterwards log to logger.
+// Nothing is logged by default.
+func (r *Repository) SetLogger(logger Logger) {
+	r.logger = orNop(logger)
+}
+
+// SetBlobStore makes all layouts of the repository opened or created afterwards keep their blobs in store instead of
+// their blobs directories. The layouts share the store like with EnableSharedBlobs, their indexes stay in the layouts.
+func (r *Repository) SetBlobStore(store BlobStore) {
+	r.blobs = store
 }
 
 // Helper which checks if a OCI repository resides in the given path
@@ -63,11 +84,32 @@ func IsRepositoryFs(fs afero.Fs, path string) bool {
 }
 
 func (r *Repository) OpenImageLayout(name string) (*ImageLayout, error) {
-	return openImageLayout(r.fs, name)
+	if !r.HasImageLayout(name) {
+		return nil, &Error{Op: "open layout", Path: name, Err: ErrLayoutNotFound}
+	}
+
+	layout, err := openImageLayout(r.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.shareBlobs(layout, name), nil
 }
 
 func (r *Repository) CreateImageLayout(name string) (*ImageLayout, error) {
-	return createImageLayout(r.fs, name)
+	layout, err := createImageLayout(r.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.shareBlobs(layout, name), nil
+}
+
+// RemoveImageLayout deletes the layout name with all its blobs. Blobs shared with other layouts are kept.
+func (r *Repository) RemoveImageLayout(name string) error {
+	if !r.HasImageLayout(name) {
+		return &Error{Op: "remove layout", Path: name, Err: ErrLayoutNotFound}
+	}
+
+	return r.fs.RemoveAll(name)
 }
 
 func (r *Repository) HasImageLayout(name string) bool {
@@ -82,6 +124,39 @@ func (r *Repository) HasImageLayout(name string) bool {
 	return true
 }
 
+// ListImageLayouts returns the names of all image layouts in the repository.
+// Names of layouts in subdirectories contain the path relative to the repository root.
+func (r *Repository) ListImageLayouts() ([]string, error) {
+	names := make([]string, 0)
+	err := afero.Walk(r.fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		// The blobs of a layout never contain other layouts
+		if info.Name() == blobsDirectory {
+			return filepath.SkipDir
+		}
+
+		name := filepath.ToSlash(filepath.Clean(path))[1:]
+		if name != "" && r.HasImageLayout(name) {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
 func (r Repository) IsImageLayoutConsistent(name string) bool {
 	_, err := openImageLayout(r.fs, name)
 	if err != nil {
@@ -0,0 +1,58 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+	"strings"
+)
+
+func isApkInstalled(name string, _ *tar.Header) bool {
+	return name == "lib/apk/db/installed"
+}
+
+// parseApkInstalled reads the installed database of apk. Every package is a block of single letter
+// fields separated by blank lines. https://wiki.alpinelinux.org/wiki/Apk_spec
+func parseApkInstalled(name string, r *bufio.Reader) ([]Package, error) {
+	pkgs := make([]Package, 0)
+	current := Package{Type: TypeApk, Location: name}
+
+	flush := func() {
+		if current.Name != "" {
+			pkgs = append(pkgs, current)
+		}
+		current = Package{Type: TypeApk, Location: name}
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			flush()
+		} else if key, value, ok := strings.Cut(line, ":"); ok {
+			switch key {
+			case "P":
+				current.Name = value
+			case "V":
+				current.Version = value
+			case "A":
+				current.Architecture = value
+			case "L":
+				current.License = value
+			case "m":
+				current.Supplier = value
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	flush()
+
+	return pkgs, nil
+}
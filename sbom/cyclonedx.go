@@ -0,0 +1,138 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+const (
+	CycloneDXVersion   = "1.5"
+	MediaTypeCycloneDX = "application/vnd.cyclonedx+json"
+)
+
+// CycloneDXBOM is the subset of a CycloneDX BOM needed to list components https://cyclonedx.org/docs/1.5/json/
+type CycloneDXBOM struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     CycloneDXMetadata    `json:"metadata"`
+	Components   []CycloneDXComponent `json:"components"`
+}
+
+type CycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     CycloneDXTools     `json:"tools"`
+	Component CycloneDXComponent `json:"component"`
+}
+
+type CycloneDXTools struct {
+	Components []CycloneDXComponent `json:"components"`
+}
+
+type CycloneDXComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	Supplier   *CycloneDXEntity    `json:"supplier,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []CycloneDXLicense  `json:"licenses,omitempty"`
+	Hashes     []CycloneDXHash     `json:"hashes,omitempty"`
+	Properties []CycloneDXProperty `json:"properties,omitempty"`
+}
+
+type CycloneDXEntity struct {
+	Name string `json:"name"`
+}
+
+// CycloneDXLicense carries a license by name as it may be no SPDX identifier
+type CycloneDXLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+type CycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type CycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CycloneDX converts the SBOM to a CycloneDX BOM with the image as component the BOM describes
+func (s *SBOM) CycloneDX() (*CycloneDXBOM, error) {
+	serial, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+
+	bom := &CycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  CycloneDXVersion,
+		SerialNumber: "urn:uuid:" + serial,
+		Version:      1,
+		Metadata: CycloneDXMetadata{
+			Timestamp: s.Created.UTC().Format(time.RFC3339),
+			Tools: CycloneDXTools{
+				Components: []CycloneDXComponent{{Type: "application", Name: "github.com/toasterson/oci"}},
+			},
+			Component: CycloneDXComponent{
+				Type:    "container",
+				BOMRef:  s.imagePURL(),
+				Name:    s.Name,
+				Version: s.Subject.Digest.String(),
+				PURL:    s.imagePURL(),
+				Hashes:  []CycloneDXHash{{Algorithm: "SHA-256", Content: s.Subject.Digest.Encoded()}},
+			},
+		},
+		Components: make([]CycloneDXComponent, 0, len(s.Packages)),
+	}
+
+	refs := make(map[string]int)
+	for _, pkg := range s.Packages {
+		// bom-refs have to be unique even if a package is found at several locations
+		ref := pkg.PURL
+		if n := refs[pkg.PURL]; n > 0 {
+			ref = fmt.Sprintf("%s#%d", pkg.PURL, n)
+		}
+		refs[pkg.PURL]++
+
+		component := CycloneDXComponent{
+			Type:       "library",
+			BOMRef:     ref,
+			Name:       pkg.Name,
+			Version:    pkg.Version,
+			PURL:       pkg.PURL,
+			Properties: []CycloneDXProperty{{Name: "oci:location", Value: pkg.Location}},
+		}
+		if pkg.Supplier != "" {
+			component.Supplier = &CycloneDXEntity{Name: pkg.Supplier}
+		}
+		if pkg.License != "" {
+			license := CycloneDXLicense{}
+			license.License.Name = pkg.License
+			component.Licenses = []CycloneDXLicense{license}
+		}
+
+		bom.Components = append(bom.Components, component)
+	}
+
+	return bom, nil
+}
+
+// uuid returns a random version 4 UUID
+func uuid() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
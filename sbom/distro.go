@@ -0,0 +1,121 @@
+package sbom
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func isOSRelease(name string) bool {
+	return name == "etc/os-release" || name == "usr/lib/os-release"
+}
+
+// parseOSRelease reads the distribution from os-release https://www.freedesktop.org/software/systemd/man/os-release.html
+func parseOSRelease(r io.Reader) (Distro, error) {
+	var distro Distro
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+
+		switch key {
+		case "ID":
+			distro.ID = value
+		case "VERSION_ID":
+			distro.VersionID = value
+		case "PRETTY_NAME":
+			distro.Name = value
+		}
+	}
+
+	return distro, scanner.Err()
+}
+
+// purl builds the package URL of the package https://github.com/package-url/purl-spec
+func purl(pkg Package, distro Distro) string {
+	namespace := ""
+	name := pkg.Name
+	version := pkg.Version
+	qualifiers := make(map[string]string)
+
+	switch pkg.Type {
+	case TypeDeb, TypeApk, TypeRpm:
+		namespace = distro.ID
+		if namespace == "" {
+			namespace = map[string]string{TypeDeb: "debian", TypeApk: "alpine"}[pkg.Type]
+		}
+		if pkg.Architecture != "" {
+			qualifiers["arch"] = pkg.Architecture
+		}
+		if distro.ID != "" && distro.VersionID != "" {
+			qualifiers["distro"] = distro.ID + "-" + distro.VersionID
+		}
+		// The epoch of rpm packages is a qualifier instead of part of the version
+		if epoch, rest, ok := strings.Cut(version, ":"); ok && pkg.Type == TypeRpm {
+			qualifiers["epoch"] = epoch
+			version = rest
+		}
+	case TypeGolang, TypeNpm:
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	case TypePyPI:
+		name = strings.ToLower(strings.Replace(name, "_", "-", -1))
+	}
+
+	var b strings.Builder
+	b.WriteString("pkg:")
+	b.WriteString(pkg.Type)
+	b.WriteString("/")
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			b.WriteString(escape(segment))
+			b.WriteString("/")
+		}
+	}
+	b.WriteString(escape(name))
+	if version != "" {
+		b.WriteString("@")
+		b.WriteString(escape(version))
+	}
+
+	if len(qualifiers) > 0 {
+		keys := make([]string, 0, len(qualifiers))
+		for key := range qualifiers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for i, key := range keys {
+			if i == 0 {
+				b.WriteString("?")
+			} else {
+				b.WriteString("&")
+			}
+			b.WriteString(key)
+			b.WriteString("=")
+			b.WriteString(url.QueryEscape(qualifiers[key]))
+		}
+	}
+
+	return b.String()
+}
+
+func escape(s string) string {
+	return strings.Replace(url.PathEscape(s), "@", "%40", -1)
+}
@@ -0,0 +1,84 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+func isDpkgStatus(name string, _ *tar.Header) bool {
+	// Distroless images ship one status file per package in status.d
+	return name == "var/lib/dpkg/status" || path.Dir(name) == "var/lib/dpkg/status.d"
+}
+
+// parseDpkgStatus reads the installed packages of a dpkg status file. Packages which are not
+// fully installed are skipped. https://man7.org/linux/man-pages/man1/dpkg-query.1.html
+func parseDpkgStatus(name string, r *bufio.Reader) ([]Package, error) {
+	pkgs := make([]Package, 0)
+	err := readControlParagraphs(r, func(fields map[string]string) {
+		if fields["Package"] == "" {
+			return
+		}
+		// Files in status.d carry no Status field, their packages are installed
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			return
+		}
+
+		pkgs = append(pkgs, Package{
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Type:         TypeDeb,
+			Architecture: fields["Architecture"],
+			Supplier:     fields["Maintainer"],
+			Location:     name,
+		})
+	})
+
+	return pkgs, err
+}
+
+// readControlParagraphs splits a Debian control file into paragraphs separated by blank lines.
+// Continuation lines are appended to the field they belong to.
+func readControlParagraphs(r *bufio.Reader, fn func(fields map[string]string)) error {
+	fields := make(map[string]string)
+	last := ""
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.TrimSpace(trimmed) == "":
+			if len(fields) > 0 {
+				fn(fields)
+				fields = make(map[string]string)
+			}
+			last = ""
+		case trimmed[0] == ' ' || trimmed[0] == '\t':
+			if last != "" {
+				fields[last] += "\n" + strings.TrimSpace(trimmed)
+			}
+		default:
+			key, value, ok := strings.Cut(trimmed, ":")
+			if ok {
+				last = key
+				fields[key] = strings.TrimSpace(value)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(fields) > 0 {
+		fn(fields)
+	}
+
+	return nil
+}
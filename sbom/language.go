@@ -0,0 +1,194 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var elfMagic = []byte("\x7fELF")
+
+func isPythonMetadata(name string, _ *tar.Header) bool {
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	return (base == "METADATA" && strings.HasSuffix(dir, ".dist-info")) ||
+		(base == "PKG-INFO" && strings.HasSuffix(dir, ".egg-info"))
+}
+
+// parsePythonMetadata reads the core metadata of an installed distribution https://packaging.python.org/en/latest/specifications/core-metadata/
+func parsePythonMetadata(name string, r *bufio.Reader) ([]Package, error) {
+	pkg := Package{Type: TypePyPI, Location: name}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		// The headers end at the first blank line, the description follows
+		if line == "" {
+			break
+		}
+
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			value = strings.TrimSpace(value)
+			switch key {
+			case "Name":
+				pkg.Name = value
+			case "Version":
+				pkg.Version = value
+			case "License", "License-Expression":
+				if pkg.License == "" || key == "License-Expression" {
+					pkg.License = value
+				}
+			case "Author":
+				pkg.Supplier = value
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if pkg.Name == "" {
+		return nil, nil
+	}
+
+	return []Package{pkg}, nil
+}
+
+func isPackageLock(name string, _ *tar.Header) bool {
+	return path.Base(name) == "package-lock.json" && !strings.Contains(name, "node_modules/")
+}
+
+type packageLock struct {
+	LockfileVersion int                          `json:"lockfileVersion"`
+	Packages        map[string]packageLockEntry  `json:"packages"`
+	Dependencies    map[string]packageLockLegacy `json:"dependencies"`
+}
+
+type packageLockEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	License string `json:"license"`
+	Link    bool   `json:"link"`
+}
+
+type packageLockLegacy struct {
+	Version      string                       `json:"version"`
+	Dependencies map[string]packageLockLegacy `json:"dependencies"`
+}
+
+// parsePackageLock reads the dependencies installed by npm https://docs.npmjs.com/cli/configuring-npm/package-lock-json
+func parsePackageLock(name string, r *bufio.Reader) ([]Package, error) {
+	var lock packageLock
+	if err := json.NewDecoder(r).Decode(&lock); err != nil {
+		return nil, err
+	}
+
+	pkgs := make([]Package, 0)
+	if lock.Packages != nil {
+		for key, entry := range lock.Packages {
+			// The empty key is the project itself
+			if key == "" || entry.Link {
+				continue
+			}
+
+			pkgName := entry.Name
+			if pkgName == "" {
+				i := strings.LastIndex(key, "node_modules/")
+				pkgName = key[i+len("node_modules/"):]
+			}
+
+			pkgs = append(pkgs, Package{
+				Name:     pkgName,
+				Version:  entry.Version,
+				Type:     TypeNpm,
+				License:  entry.License,
+				Location: name,
+			})
+		}
+	} else {
+		var walk func(deps map[string]packageLockLegacy)
+		walk = func(deps map[string]packageLockLegacy) {
+			for depName, dep := range deps {
+				pkgs = append(pkgs, Package{Name: depName, Version: dep.Version, Type: TypeNpm, Location: name})
+				walk(dep.Dependencies)
+			}
+		}
+		walk(lock.Dependencies)
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Version < pkgs[j].Version
+	})
+
+	// The same version can be installed at several places in the tree
+	unique := pkgs[:0]
+	for i, pkg := range pkgs {
+		if i > 0 && pkg.Name == pkgs[i-1].Name && pkg.Version == pkgs[i-1].Version {
+			continue
+		}
+		unique = append(unique, pkg)
+	}
+
+	return unique, nil
+}
+
+func isExecutable(_ string, hdr *tar.Header) bool {
+	return hdr.Mode&0111 != 0 && hdr.Size > int64(len(elfMagic))
+}
+
+// parseGoBinary reads the build information the Go toolchain embeds into executables.
+// The main module and all its dependencies are reported.
+func parseGoBinary(name string, r *bufio.Reader) ([]Package, error) {
+	magic, err := r.Peek(len(elfMagic))
+	if err != nil || !bytes.Equal(magic, elfMagic) {
+		return nil, nil
+	}
+
+	// The build information is read at offsets thus the executable goes to a temporary file instead of memory
+	f, err := ioutil.TempFile("", "oci-sbom-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return nil, err
+	}
+
+	info, err := buildinfo.Read(f)
+	if err != nil {
+		// Not built by Go
+		return nil, nil
+	}
+
+	pkgs := make([]Package, 0, len(info.Deps)+1)
+	if info.Main.Path != "" {
+		pkgs = append(pkgs, Package{Name: info.Main.Path, Version: info.Main.Version, Type: TypeGolang, Location: name})
+	}
+	for _, dep := range info.Deps {
+		module := dep
+		if dep.Replace != nil {
+			module = dep.Replace
+		}
+		pkgs = append(pkgs, Package{Name: module.Path, Version: module.Version, Type: TypeGolang, Location: name})
+	}
+
+	return pkgs, nil
+}
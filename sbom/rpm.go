@@ -0,0 +1,145 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// Tags of the rpm header https://github.com/rpm-software-management/rpm/blob/master/include/rpm/rpmtag.h
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagVendor  = 1011
+	rpmTagLicense = 1014
+	rpmTagArch    = 1022
+
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+
+	rpmIndexEntrySize = 16
+)
+
+func isRpmDB(name string, _ *tar.Header) bool {
+	return name == "var/lib/rpm/rpmdb.sqlite" || name == "usr/lib/sysimage/rpm/rpmdb.sqlite"
+}
+
+// parseRpmDB reads the headers of all packages from the sqlite backend of the rpm database
+func parseRpmDB(name string, r *bufio.Reader) ([]Package, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := db.tableRoot("Packages")
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := make([]Package, 0)
+	err = db.scan(root, func(rowid int64, values []interface{}) error {
+		if len(values) < 2 {
+			return nil
+		}
+		blob, ok := values[1].([]byte)
+		if !ok {
+			return nil
+		}
+
+		tags, err := parseRpmHeader(blob)
+		if err != nil {
+			return fmt.Errorf("header of package %d: %w", rowid, err)
+		}
+
+		// Imported signing keys are stored as pseudo packages
+		if tags[rpmTagName] == "" || tags[rpmTagName] == "gpg-pubkey" {
+			return nil
+		}
+
+		version := tags[rpmTagVersion]
+		if release := tags[rpmTagRelease]; release != "" {
+			version += "-" + release
+		}
+		if epoch := tags[rpmTagEpoch]; epoch != "" && epoch != "0" {
+			version = epoch + ":" + version
+		}
+
+		pkgs = append(pkgs, Package{
+			Name:         tags[rpmTagName],
+			Version:      version,
+			Type:         TypeRpm,
+			Architecture: tags[rpmTagArch],
+			License:      tags[rpmTagLicense],
+			Supplier:     tags[rpmTagVendor],
+			Location:     name,
+		})
+		return nil
+	})
+
+	return pkgs, err
+}
+
+// parseRpmHeader returns the string values of the tags needed to describe a package from a header
+// as stored in the rpm database, that is without lead and header magic.
+// https://rpm-software-management.github.io/rpm/manual/format_v4.html
+func parseRpmHeader(blob []byte) (map[int32]string, error) {
+	if len(blob) < 8 {
+		return nil, fmt.Errorf("header too short")
+	}
+
+	entries := int(binary.BigEndian.Uint32(blob[0:4]))
+	dataSize := int(binary.BigEndian.Uint32(blob[4:8]))
+	indexEnd := 8 + entries*rpmIndexEntrySize
+	if entries < 0 || dataSize < 0 || indexEnd < 8 || indexEnd+dataSize > len(blob) {
+		return nil, fmt.Errorf("header index out of range")
+	}
+	store := blob[indexEnd : indexEnd+dataSize]
+
+	tags := make(map[int32]string)
+	for i := 0; i < entries; i++ {
+		entry := blob[8+i*rpmIndexEntrySize:]
+		tag := int32(binary.BigEndian.Uint32(entry[0:4]))
+		kind := binary.BigEndian.Uint32(entry[4:8])
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:12])))
+		count := binary.BigEndian.Uint32(entry[12:16])
+
+		switch tag {
+		case rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagEpoch, rpmTagVendor, rpmTagLicense, rpmTagArch:
+		default:
+			continue
+		}
+		if offset < 0 || offset >= len(store) || count == 0 {
+			return nil, fmt.Errorf("tag %d out of range", tag)
+		}
+
+		switch kind {
+		case rpmTypeString, rpmTypeStringArray, rpmTypeI18NString:
+			// Arrays are reduced to their first element which is the untranslated one for i18n strings
+			end := bytes.IndexByte(store[offset:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("string of tag %d is not terminated", tag)
+			}
+			tags[tag] = string(store[offset : offset+end])
+		case rpmTypeInt32:
+			if offset+4 > len(store) {
+				return nil, fmt.Errorf("tag %d out of range", tag)
+			}
+			tags[tag] = strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(store[offset:]))), 10)
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,192 @@
+// Package sbom generates software bills of materials from the merged filesystem of an image.
+// Packages are found in the databases of the package managers shipped in the image and emitted as
+// SPDX 2.3 or CycloneDX JSON. https://spdx.github.io/spdx-spec/v2.3/ and https://cyclonedx.org/specification/overview/
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+// Package types as used in package URLs https://github.com/package-url/purl-spec/blob/master/PURL-TYPES.rst
+const (
+	TypeDeb    = "deb"
+	TypeApk    = "apk"
+	TypeRpm    = "rpm"
+	TypeGolang = "golang"
+	TypePyPI   = "pypi"
+	TypeNpm    = "npm"
+)
+
+// Format selects the document type an SBOM is emitted as
+type Format string
+
+const (
+	FormatSPDX      Format = MediaTypeSPDX
+	FormatCycloneDX Format = MediaTypeCycloneDX
+)
+
+// Package is a piece of software found in the image
+type Package struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Architecture string `json:"architecture,omitempty"`
+	License      string `json:"license,omitempty"`
+	Supplier     string `json:"supplier,omitempty"`
+	// Location is the path of the file in the image the package was found in
+	Location string `json:"location"`
+	PURL     string `json:"purl"`
+}
+
+// Distro identifies the operating system of the image as given in os-release
+type Distro struct {
+	ID        string `json:"id,omitempty"`
+	VersionID string `json:"versionID,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// SBOM lists the packages of an image independent of the format it is emitted as
+type SBOM struct {
+	// Name of the image, usually the repository it is pushed to
+	Name     string
+	Subject  specsv1.Descriptor
+	Distro   Distro
+	Packages []Package
+	Created  time.Time
+}
+
+// cataloger parses the package database stored in one file of the image
+type cataloger struct {
+	match func(name string, hdr *tar.Header) bool
+	parse func(name string, r *bufio.Reader) ([]Package, error)
+}
+
+var catalogers = []cataloger{
+	{match: isDpkgStatus, parse: parseDpkgStatus},
+	{match: isApkInstalled, parse: parseApkInstalled},
+	{match: isRpmDB, parse: parseRpmDB},
+	{match: isPythonMetadata, parse: parsePythonMetadata},
+	{match: isPackageLock, parse: parsePackageLock},
+	{match: isExecutable, parse: parseGoBinary},
+}
+
+// Generate walks the merged filesystem of the image and catalogs the packages it contains.
+// manifest is the descriptor the image was saved as and name the name the SBOM describes it by.
+func Generate(img *oci.Image, manifest specsv1.Descriptor, name string) (*SBOM, error) {
+	s := &SBOM{
+		Name:     name,
+		Subject:  manifest,
+		Packages: make([]Package, 0),
+		Created:  time.Now().UTC(),
+	}
+
+	err := img.WalkMerged(func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		if isOSRelease(hdr.Name) {
+			distro, err := parseOSRelease(r)
+			if err != nil {
+				return fmt.Errorf("%s cannot be parsed: %w", hdr.Name, err)
+			}
+			// etc/os-release takes precedence over usr/lib/os-release it usually links to
+			if s.Distro.ID == "" || hdr.Name == "etc/os-release" {
+				s.Distro = distro
+			}
+			return nil
+		}
+
+		for _, c := range catalogers {
+			if !c.match(hdr.Name, hdr) {
+				continue
+			}
+
+			pkgs, err := c.parse(hdr.Name, bufio.NewReader(r))
+			if err != nil {
+				return fmt.Errorf("packages in %s cannot be read: %w", hdr.Name, err)
+			}
+			s.Packages = append(s.Packages, pkgs...)
+			return nil
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range s.Packages {
+		s.Packages[i].PURL = purl(s.Packages[i], s.Distro)
+	}
+
+	sort.SliceStable(s.Packages, func(i, j int) bool {
+		if s.Packages[i].Type != s.Packages[j].Type {
+			return s.Packages[i].Type < s.Packages[j].Type
+		}
+		return s.Packages[i].Name < s.Packages[j].Name
+	})
+
+	return s, nil
+}
+
+// Encode writes the SBOM as JSON document of the format
+func (s *SBOM) Encode(w io.Writer, format Format) error {
+	var doc interface{}
+	switch format {
+	case FormatSPDX:
+		doc = s.SPDX()
+	case FormatCycloneDX:
+		bom, err := s.CycloneDX()
+		if err != nil {
+			return err
+		}
+		doc = bom
+	default:
+		return fmt.Errorf("unsupported sbom format %s", format)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// Attach stores the SBOM in the given format as artifact referring to the manifest it describes and adds it to the
+// index of the layout. The media type of the format is used as artifact type.
+// The index of the layout is only persisted once the layout is closed.
+func Attach(layout *oci.ImageLayout, s *SBOM, format Format) (specsv1.Descriptor, error) {
+	var buf bytes.Buffer
+	if err := s.Encode(&buf, format); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	artifact := layout.CreateArtifact(string(format), &s.Subject)
+	artifact.AddAnnotation(specsv1.AnnotationCreated, s.Created.UTC().Format(time.RFC3339))
+	if _, err := artifact.AddBlob(string(format), &buf, nil); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	return layout.SaveArtifact(artifact)
+}
+
+// imagePURL is the package URL of the image https://github.com/package-url/purl-spec/blob/master/PURL-TYPES.rst#oci
+func (s *SBOM) imagePURL() string {
+	p := fmt.Sprintf("pkg:oci/%s@%s", escape(path.Base(s.Name)), strings.Replace(escape(s.Subject.Digest.String()), ":", "%3A", -1))
+	if path.Base(s.Name) != s.Name {
+		p += "?repository_url=" + url.QueryEscape(s.Name)
+	}
+	return p
+}
@@ -0,0 +1,249 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Priority: optional
+Architecture: amd64
+Maintainer: GNU Libc Maintainers <debian-glibc@lists.debian.org>
+Version: 2.36-9+deb12u4
+Description: GNU C Library: Shared libraries
+ Contains the standard libraries that are used by nearly all programs on
+ the system.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+L:MIT
+m:Timo Teräs <timo.teras@iki.fi>
+`
+
+const pythonMetadata = `Metadata-Version: 2.1
+Name: requests
+Version: 2.31.0
+License: Apache 2.0
+
+Name: not-a-header
+`
+
+const lockFile = `{
+  "name": "app",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app", "version": "1.0.0"},
+    "node_modules/@scope/util": {"version": "2.0.0", "license": "ISC"},
+    "node_modules/left-pad": {"version": "1.3.0", "license": "WTFPL"},
+    "node_modules/@scope/util/node_modules/left-pad": {"version": "1.3.0", "license": "WTFPL"},
+    "node_modules/local": {"resolved": "../local", "link": true}
+  }
+}
+`
+
+func buildImage(t *testing.T) (*oci.ImageLayout, *oci.Image, specsv1.Descriptor) {
+	dir, err := ioutil.TempDir("", "oci-sbom")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	rpmdb, err := ioutil.ReadFile(filepath.Join("testdata", "rpmdb.sqlite"))
+	require.NoError(t, err)
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	binary, err := ioutil.ReadFile(executable)
+	require.NoError(t, err)
+
+	for name, content := range map[string][]byte{
+		"etc/os-release":           []byte("NAME=\"Debian GNU/Linux\"\nID=debian\nVERSION_ID=\"12\"\n"),
+		"var/lib/dpkg/status":      []byte(dpkgStatus),
+		"lib/apk/db/installed":     []byte(apkInstalled),
+		"var/lib/rpm/rpmdb.sqlite": rpmdb,
+		"usr/lib/python3/site-packages/requests-2.31.0.dist-info/METADATA": []byte(pythonMetadata),
+		"srv/app/package-lock.json":                                        []byte(lockFile),
+		"usr/bin/app":                                                      binary,
+	} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, content, 0755))
+	}
+
+	repo, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/repo")
+	require.NoError(t, err)
+	layout, err := repo.CreateImageLayout("app")
+	require.NoError(t, err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(t, img.AddTree(dir, specsv1.History{CreatedBy: "COPY / /"}))
+	require.NoError(t, layout.SaveImage(img))
+	manifest, err := layout.Resolve("latest")
+	require.NoError(t, err)
+
+	return layout, img, manifest
+}
+
+func findPackage(pkgs []Package, pkgType, name string) *Package {
+	for i := range pkgs {
+		if pkgs[i].Type == pkgType && pkgs[i].Name == name {
+			return &pkgs[i]
+		}
+	}
+	return nil
+}
+
+func TestGenerate(t *testing.T) {
+	_, img, manifest := buildImage(t)
+
+	s, err := Generate(img, manifest, "registry.example.com/app")
+	require.NoError(t, err)
+	assert.Equal(t, Distro{ID: "debian", VersionID: "12", Name: ""}, s.Distro)
+
+	libc := findPackage(s.Packages, TypeDeb, "libc6")
+	require.NotNil(t, libc)
+	assert.Equal(t, "2.36-9+deb12u4", libc.Version)
+	assert.Equal(t, "var/lib/dpkg/status", libc.Location)
+	assert.Equal(t, "pkg:deb/debian/libc6@2.36-9+deb12u4?arch=amd64&distro=debian-12", libc.PURL)
+	assert.Nil(t, findPackage(s.Packages, TypeDeb, "removed"))
+
+	musl := findPackage(s.Packages, TypeApk, "musl")
+	require.NotNil(t, musl)
+	assert.Equal(t, "1.2.4-r2", musl.Version)
+	assert.Equal(t, "MIT", musl.License)
+
+	rpms := 0
+	for _, pkg := range s.Packages {
+		if pkg.Type == TypeRpm {
+			rpms++
+		}
+	}
+	// The signing key is no package
+	assert.Equal(t, 63, rpms)
+	assert.Nil(t, findPackage(s.Packages, TypeRpm, "gpg-pubkey"))
+	openssl := findPackage(s.Packages, TypeRpm, "openssl-libs")
+	require.NotNil(t, openssl)
+	assert.Equal(t, "1:3.0.7-27.el9", openssl.Version)
+	assert.Equal(t, "ASL 2.0", openssl.License)
+	assert.Equal(t, "pkg:rpm/debian/openssl-libs@3.0.7-27.el9?arch=x86_64&distro=debian-12&epoch=1", openssl.PURL)
+	// The header of glibc is stored on overflow pages
+	glibc := findPackage(s.Packages, TypeRpm, "glibc")
+	require.NotNil(t, glibc)
+	assert.Equal(t, "2.34-100.el9", glibc.Version)
+	assert.Equal(t, "x86_64", glibc.Architecture)
+
+	requests := findPackage(s.Packages, TypePyPI, "requests")
+	require.NotNil(t, requests)
+	assert.Equal(t, "2.31.0", requests.Version)
+	assert.Equal(t, "pkg:pypi/requests@2.31.0", requests.PURL)
+
+	npm := make([]string, 0)
+	for _, pkg := range s.Packages {
+		if pkg.Type == TypeNpm {
+			npm = append(npm, pkg.PURL)
+		}
+	}
+	assert.Equal(t, []string{"pkg:npm/%40scope/util@2.0.0", "pkg:npm/left-pad@1.3.0"}, npm)
+
+	testify := findPackage(s.Packages, TypeGolang, "github.com/stretchr/testify")
+	require.NotNil(t, testify)
+	assert.Equal(t, "usr/bin/app", testify.Location)
+	assert.Equal(t, "pkg:golang/github.com/stretchr/testify@"+testify.Version, testify.PURL)
+}
+
+func TestFormatsAndAttach(t *testing.T) {
+	layout, img, manifest := buildImage(t)
+
+	s, err := Generate(img, manifest, "registry.example.com/app")
+	require.NoError(t, err)
+
+	var spdx bytes.Buffer
+	require.NoError(t, s.Encode(&spdx, FormatSPDX))
+	doc := SPDXDocument{}
+	require.NoError(t, json.Unmarshal(spdx.Bytes(), &doc))
+	assert.Equal(t, SPDXVersion, doc.SPDXVersion)
+	assert.Len(t, doc.Packages, len(s.Packages)+1)
+	assert.Len(t, doc.Relationships, len(s.Packages)+1)
+	ids := make(map[string]bool)
+	for _, pkg := range doc.Packages {
+		assert.Regexp(t, `^SPDXRef-[a-zA-Z0-9.-]+$`, pkg.SPDXID)
+		assert.False(t, ids[pkg.SPDXID], "duplicate id %s", pkg.SPDXID)
+		ids[pkg.SPDXID] = true
+	}
+
+	var cdx bytes.Buffer
+	require.NoError(t, s.Encode(&cdx, FormatCycloneDX))
+	bom := CycloneDXBOM{}
+	require.NoError(t, json.Unmarshal(cdx.Bytes(), &bom))
+	assert.Equal(t, "CycloneDX", bom.BOMFormat)
+	assert.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, bom.SerialNumber)
+	assert.Equal(t, "pkg:oci/app@sha256%3A"+manifest.Digest.Encoded()+"?repository_url=registry.example.com%2Fapp", bom.Metadata.Component.PURL)
+	assert.Len(t, bom.Components, len(s.Packages))
+
+	assert.Error(t, s.Encode(&cdx, Format("text/plain")))
+
+	descr, err := Attach(layout, s, FormatSPDX)
+	require.NoError(t, err)
+	referrers, err := layout.Referrers(manifest.Digest, MediaTypeSPDX)
+	require.NoError(t, err)
+	require.Len(t, referrers, 1)
+	assert.Equal(t, descr.Digest, referrers[0].Digest)
+	assert.Equal(t, MediaTypeSPDX, referrers[0].ArtifactType)
+}
+
+func TestSQLiteRejectsInvalidFiles(t *testing.T) {
+	_, err := openSQLite([]byte("not a database"))
+	assert.Equal(t, ErrNotSQLite, err)
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "rpmdb.sqlite"))
+	require.NoError(t, err)
+	db, err := openSQLite(data[:len(data)/2])
+	require.NoError(t, err)
+	root, err := db.tableRoot("Packages")
+	require.NoError(t, err)
+	assert.Error(t, db.scan(root, func(int64, []interface{}) error { return nil }))
+}
+
+func TestParseGoBinary(t *testing.T) {
+	// Files which are no ELF executables are skipped without reading them
+	pkgs, err := parseGoBinary("bin/script", bufio.NewReader(io.MultiReader(strings.NewReader("#!/bin/sh\n"), failingReader{})))
+	require.NoError(t, err)
+	assert.Empty(t, pkgs)
+
+	pkgs, err = parseGoBinary("bin/c", bufio.NewReader(bytes.NewReader(append([]byte("\x7fELF"), make([]byte, 64)...))))
+	require.NoError(t, err)
+	assert.Empty(t, pkgs)
+
+	executable, err := os.Open(os.Args[0])
+	require.NoError(t, err)
+	defer executable.Close()
+	pkgs, err = parseGoBinary("bin/test", bufio.NewReader(executable))
+	require.NoError(t, err)
+	assert.NotNil(t, findPackage(pkgs, TypeGolang, "github.com/stretchr/testify"))
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read beyond the magic bytes")
+}
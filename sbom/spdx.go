@@ -0,0 +1,141 @@
+package sbom
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	SPDXVersion     = "SPDX-2.3"
+	MediaTypeSPDX   = "application/spdx+json"
+	spdxNoAssertion = "NOASSERTION"
+	spdxDocumentID  = "SPDXRef-DOCUMENT"
+	spdxImageID     = "SPDXRef-Image"
+	creatorTool     = "Tool: github.com/toasterson/oci"
+)
+
+var spdxInvalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// SPDXDocument is the subset of an SPDX 2.3 document needed to list packages https://spdx.github.io/spdx-spec/v2.3/
+type SPDXDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      SPDXCreationInfo   `json:"creationInfo"`
+	Packages          []SPDXPackage      `json:"packages"`
+	Relationships     []SPDXRelationship `json:"relationships"`
+}
+
+type SPDXCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type SPDXPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	Supplier         string            `json:"supplier,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	LicenseComments  string            `json:"licenseComments,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums        []SPDXChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []SPDXExternalRef `json:"externalRefs,omitempty"`
+}
+
+type SPDXChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type SPDXExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type SPDXRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// SPDX converts the SBOM to an SPDX document. The image is the described package which contains all others.
+// Licenses are declared as found in the package databases which are often no valid SPDX expressions, thus they are
+// reported as license comment only.
+func (s *SBOM) SPDX() *SPDXDocument {
+	doc := &SPDXDocument{
+		SPDXVersion:       SPDXVersion,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            spdxDocumentID,
+		Name:              s.Name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/toasterson/oci/spdx/%s-%s", s.Name, s.Subject.Digest.Encoded()),
+		CreationInfo: SPDXCreationInfo{
+			Created:  s.Created.UTC().Format(time.RFC3339),
+			Creators: []string{creatorTool},
+		},
+		Packages: []SPDXPackage{{
+			SPDXID:           spdxImageID,
+			Name:             s.Name,
+			VersionInfo:      s.Subject.Digest.String(),
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			PrimaryPurpose:   "CONTAINER",
+			Checksums: []SPDXChecksum{{
+				Algorithm:     "SHA256",
+				ChecksumValue: s.Subject.Digest.Encoded(),
+			}},
+			ExternalRefs: []SPDXExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  s.imagePURL(),
+			}},
+		}},
+		Relationships: []SPDXRelationship{{
+			SPDXElementID:      spdxDocumentID,
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: spdxImageID,
+		}},
+	}
+
+	for i, pkg := range s.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%s-%s-%d", pkg.Type, spdxInvalidIDChars.ReplaceAllString(pkg.Name, "-"), i)
+		spdxPkg := SPDXPackage{
+			SPDXID:           id,
+			Name:             pkg.Name,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			SourceInfo:       "acquired package info from " + pkg.Location,
+			ExternalRefs: []SPDXExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  pkg.PURL,
+			}},
+		}
+		if pkg.Supplier != "" {
+			spdxPkg.Supplier = "Organization: " + pkg.Supplier
+		}
+		if pkg.License != "" {
+			spdxPkg.LicenseComments = "declared as " + pkg.License
+		}
+
+		doc.Packages = append(doc.Packages, spdxPkg)
+		doc.Relationships = append(doc.Relationships, SPDXRelationship{
+			SPDXElementID:      spdxImageID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return doc
+}
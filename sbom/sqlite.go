@@ -0,0 +1,312 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrNotSQLite     = errors.New("not a sqlite database")
+	ErrCorruptSQLite = errors.New("sqlite database is corrupt")
+)
+
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+	// maxTreeDepth guards against page cycles in corrupt databases
+	maxTreeDepth = 64
+
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+)
+
+// sqliteDB is a minimal read-only reader of the SQLite file format which is able to scan tables.
+// It is enough to read the package database of rpm without depending on cgo. https://www.sqlite.org/fileformat.html
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < sqliteHeaderSize || string(data[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, ErrNotSQLite
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("%w: invalid page size %d", ErrCorruptSQLite, pageSize)
+	}
+
+	return &sqliteDB{
+		data:     data,
+		pageSize: pageSize,
+		usable:   pageSize - int(data[20]),
+	}, nil
+}
+
+// page returns the content of the page with the 1 based number n
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	start := int64(n-1) * int64(db.pageSize)
+	if n == 0 || start+int64(db.pageSize) > int64(len(db.data)) {
+		return nil, fmt.Errorf("%w: page %d out of range", ErrCorruptSQLite, n)
+	}
+
+	return db.data[start : start+int64(db.pageSize)], nil
+}
+
+// tableRoot looks up the root page of the table in the schema table
+func (db *sqliteDB) tableRoot(table string) (uint32, error) {
+	var root uint32
+	err := db.scan(1, func(_ int64, values []interface{}) error {
+		if len(values) < 4 || values[0] != "table" || values[1] != table {
+			return nil
+		}
+		if page, ok := values[3].(int64); ok && page > 0 && page <= math.MaxUint32 {
+			root = uint32(page)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if root == 0 {
+		return 0, fmt.Errorf("table %s not found in sqlite database", table)
+	}
+
+	return root, nil
+}
+
+// scan calls fn with the rowid and the column values of every row of the table b-tree starting at root.
+// Values are nil, int64, float64, string or []byte.
+func (db *sqliteDB) scan(root uint32, fn func(rowid int64, values []interface{}) error) error {
+	return db.scanPage(root, 0, fn)
+}
+
+func (db *sqliteDB) scanPage(n uint32, depth int, fn func(rowid int64, values []interface{}) error) error {
+	if depth > maxTreeDepth {
+		return fmt.Errorf("%w: b-tree too deep", ErrCorruptSQLite)
+	}
+
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+
+	hdr := 0
+	if n == 1 {
+		hdr = sqliteHeaderSize
+	}
+	if len(page) < hdr+12 {
+		return fmt.Errorf("%w: page %d too small", ErrCorruptSQLite, n)
+	}
+
+	kind := page[hdr]
+	cells := int(binary.BigEndian.Uint16(page[hdr+3 : hdr+5]))
+
+	pointers := hdr + 8
+	if kind == pageInteriorTable {
+		pointers = hdr + 12
+	}
+	if pointers+2*cells > len(page) {
+		return fmt.Errorf("%w: cell pointers of page %d out of range", ErrCorruptSQLite, n)
+	}
+
+	for i := 0; i < cells; i++ {
+		offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+		if offset >= len(page) {
+			return fmt.Errorf("%w: cell of page %d out of range", ErrCorruptSQLite, n)
+		}
+		cell := page[offset:]
+
+		switch kind {
+		case pageInteriorTable:
+			if len(cell) < 4 {
+				return fmt.Errorf("%w: cell of page %d out of range", ErrCorruptSQLite, n)
+			}
+			if err := db.scanPage(binary.BigEndian.Uint32(cell), depth+1, fn); err != nil {
+				return err
+			}
+		case pageLeafTable:
+			rowid, payload, err := db.leafCell(cell)
+			if err != nil {
+				return err
+			}
+			values, err := decodeRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(rowid, values); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: page %d is of unexpected type %#x", ErrCorruptSQLite, n, kind)
+		}
+	}
+
+	if kind == pageInteriorTable {
+		return db.scanPage(binary.BigEndian.Uint32(page[hdr+8:]), depth+1, fn)
+	}
+
+	return nil
+}
+
+// leafCell returns the rowid and the complete payload of a table leaf cell following overflow pages if necessary
+func (db *sqliteDB) leafCell(cell []byte) (int64, []byte, error) {
+	size, n := readVarint(cell)
+	if n == 0 {
+		return 0, nil, fmt.Errorf("%w: invalid cell", ErrCorruptSQLite)
+	}
+	cell = cell[n:]
+	rowid, n := readVarint(cell)
+	if n == 0 {
+		return 0, nil, fmt.Errorf("%w: invalid cell", ErrCorruptSQLite)
+	}
+	cell = cell[n:]
+
+	if size > uint64(len(db.data)) {
+		return 0, nil, fmt.Errorf("%w: payload larger than the database", ErrCorruptSQLite)
+	}
+
+	// Amount of payload stored on the page itself as defined by the file format
+	total := int(size)
+	maxLocal := db.usable - 35
+	minLocal := (db.usable-12)*32/255 - 23
+	local := total
+	if total > maxLocal {
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+
+	if local > len(cell) {
+		return 0, nil, fmt.Errorf("%w: payload out of range", ErrCorruptSQLite)
+	}
+	if local == total {
+		return int64(rowid), cell[:total], nil
+	}
+
+	if local+4 > len(cell) {
+		return 0, nil, fmt.Errorf("%w: payload out of range", ErrCorruptSQLite)
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, cell[:local]...)
+	next := binary.BigEndian.Uint32(cell[local:])
+
+	for len(payload) < total {
+		if next == 0 {
+			return 0, nil, fmt.Errorf("%w: overflow chain ends early", ErrCorruptSQLite)
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = binary.BigEndian.Uint32(page)
+
+		chunk := page[4:db.usable]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+	}
+
+	return int64(rowid), payload, nil
+}
+
+// decodeRecord decodes the column values of a record https://www.sqlite.org/fileformat.html#record_format
+func decodeRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := readVarint(payload)
+	if n == 0 || headerSize > uint64(len(payload)) {
+		return nil, fmt.Errorf("%w: invalid record header", ErrCorruptSQLite)
+	}
+
+	types := make([]uint64, 0)
+	for pos := n; pos < int(headerSize); {
+		serialType, n := readVarint(payload[pos:headerSize])
+		if n == 0 {
+			return nil, fmt.Errorf("%w: invalid record header", ErrCorruptSQLite)
+		}
+		types = append(types, serialType)
+		pos += n
+	}
+
+	values := make([]interface{}, 0, len(types))
+	body := payload[headerSize:]
+	for _, serialType := range types {
+		size := serialTypeSize(serialType)
+		if size > uint64(len(body)) {
+			return nil, fmt.Errorf("%w: record value out of range", ErrCorruptSQLite)
+		}
+		data := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType >= 1 && serialType <= 6:
+			// Big endian two's complement of 1, 2, 3, 4, 6 or 8 bytes
+			v := int64(int8(data[0]))
+			for _, b := range data[1:] {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType >= 12 && serialType%2 == 0:
+			values = append(values, data)
+		case serialType >= 13:
+			values = append(values, string(data))
+		default:
+			return nil, fmt.Errorf("%w: reserved serial type %d", ErrCorruptSQLite, serialType)
+		}
+	}
+
+	return values, nil
+}
+
+func serialTypeSize(serialType uint64) uint64 {
+	switch serialType {
+	case 0, 8, 9, 10, 11:
+		return 0
+	case 1, 2, 3, 4:
+		return serialType
+	case 5:
+		return 6
+	case 6, 7:
+		return 8
+	}
+	if serialType%2 == 0 {
+		return (serialType - 12) / 2
+	}
+	return (serialType - 13) / 2
+}
+
+// readVarint decodes the variable length integer of SQLite and returns the number of bytes it took.
+// A length of 0 means the buffer ended early.
+func readVarint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(buf[i]), 9
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
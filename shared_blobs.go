@@ -0,0 +1,152 @@
+package oci
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/afero"
+)
+
+// sharedBlobsDirectory at the root of a repository stores the blobs of all its layouts once. It is laid out like the
+// blobs directory of a layout whose blobs are hardlinks into it.
+const sharedBlobsDirectory = blobsDirectory
+
+var ErrSharedBlobsUnsupported = errors.New("shared blobs need a filesystem with hardlinks")
+
+// SharedBlobs reports whether the layouts of the repository share their blobs
+func (r *Repository) SharedBlobs() bool {
+	return r.sharedBlobs
+}
+
+// EnableSharedBlobs makes all layouts of the repository share their blobs. Blobs are stored once in the repository
+// and the blobs directories of the layouts hardlink to them. Existing blobs are moved into the shared store.
+// Hardlinks are only possible on the OS filesystem, others return ErrSharedBlobsUnsupported.
+// The mode is not recorded in the repository thus enable it every time the repository is opened, before opening
+// its layouts. Layouts opened before keep storing their blobs on their own.
+func (r *Repository) EnableSharedBlobs() error {
+	if _, ok := r.base.(*afero.OsFs); !ok {
+		return ErrSharedBlobsUnsupported
+	}
+
+	names, err := r.ListImageLayouts()
+	if err != nil {
+		return err
+	}
+
+	if err := r.fs.MkdirAll(sharedBlobsDirectory, 0755); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err := r.walkLayoutBlobs(name, func(blob string, d digest.Digest, info os.FileInfo) error {
+			return r.shareBlob(blob, blob, blobPath(d))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	r.sharedBlobs = true
+	return nil
+}
+
+// shareBlob moves the file src into the shared store at shared unless the blob is stored already and links the
+// shared blob to target. All names are relative to the repository root.
+func (r *Repository) shareBlob(src, target, shared string) error {
+	exists, err := afero.Exists(r.fs, shared)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		if err := r.fs.Remove(src); err != nil {
+			return err
+		}
+	} else {
+		if err := r.fs.MkdirAll(path.Dir(shared), 0755); err != nil {
+			return err
+		}
+		if err := r.fs.Rename(src, shared); err != nil {
+			return err
+		}
+	}
+
+	if err := r.fs.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return r.link(shared, target)
+}
+
+// link creates newname as hardlink of oldname, EnableSharedBlobs made sure the filesystem can link files
+func (r *Repository) link(oldname, newname string) error {
+	return os.Link(filepath.Join(r.root, oldname), filepath.Join(r.root, newname))
+}
+
+// walkLayoutBlobs calls fn for every blob in the blobs directory of the layout name with its path in the repository
+func (r *Repository) walkLayoutBlobs(name string, fn func(blob string, d digest.Digest, info os.FileInfo) error) error {
+	return r.walkBlobs(path.Join(name, blobsDirectory), fn)
+}
+
+// walkBlobs calls fn for every file in dir whose path matches the digest it stores
+func (r *Repository) walkBlobs(dir string, fn func(blob string, d digest.Digest, info os.FileInfo) error) error {
+	exists, err := afero.DirExists(r.fs, dir)
+	if err != nil || !exists {
+		return err
+	}
+
+	return afero.Walk(r.fs, dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+
+		alg, encoded, ok := strings.Cut(filepath.ToSlash(rel), "/")
+		if !ok {
+			return nil
+		}
+		d := digest.NewDigestFromEncoded(digest.Algorithm(alg), encoded)
+		if d.Validate() != nil {
+			return nil
+		}
+
+		return fn(strings.TrimPrefix(filepath.ToSlash(name), "/"), d, info)
+	})
+}
+
+func (r *Repository) shareBlobs(layout *ImageLayout, name string) *ImageLayout {
+	layout.logger = r.logger
+	if r.blobs != nil {
+		layout.blobs = r.blobs
+	} else if r.sharedBlobs {
+		layout.fs = &sharedBlobFs{Fs: layout.fs, repo: r, layout: name}
+		layout.blobs = NewFsBlobStore(layout.fs)
+	}
+	return layout
+}
+
+// sharedBlobFs is the filesystem of a layout in a repository with shared blobs. Blobs committed by a DescriptorWriter
+// are moved into the shared store and linked back into the layout.
+type sharedBlobFs struct {
+	afero.Fs
+	repo   *Repository
+	layout string
+}
+
+func (fs *sharedBlobFs) Rename(oldname, newname string) error {
+	blob := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(newname)), "/")
+	parts := strings.Split(blob, "/")
+	if len(parts) != 3 || parts[0] != blobsDirectory {
+		return fs.Fs.Rename(oldname, newname)
+	}
+
+	return fs.repo.shareBlob(path.Join(fs.layout, filepath.ToSlash(oldname)), path.Join(fs.layout, blob), path.Join(sharedBlobsDirectory, parts[1], parts[2]))
+}
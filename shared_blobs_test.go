@@ -0,0 +1,170 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestSharedBlobs() {
+	root := suite.T().TempDir()
+	repo, err := CreateRepositoryFS(afero.NewOsFs(), filepath.Join(root, "repo"))
+	require.NoError(suite.T(), err)
+
+	base := suite.writeTree(map[string]string{"bin/sh": "sh"})
+	build := func(layout *ImageLayout, ref string, files map[string]string) *Image {
+		img := layout.CreateImage(ref)
+		require.NoError(suite.T(), img.AddTree(base, specsv1.History{CreatedBy: "base"}))
+		require.NoError(suite.T(), img.AddTree(suite.writeTree(files), specsv1.History{CreatedBy: ref}))
+		require.NoError(suite.T(), layout.SaveImage(img))
+		require.NoError(suite.T(), layout.Close())
+		return img
+	}
+
+	// Blobs written before the repository shares them are moved into the shared store
+	first, err := repo.CreateImageLayout("first")
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), repo.SharedBlobs())
+	one := build(first, "one", map[string]string{"one": "1"})
+	require.NoError(suite.T(), repo.EnableSharedBlobs())
+	assert.True(suite.T(), repo.SharedBlobs())
+
+	second, err := repo.CreateImageLayout("second")
+	require.NoError(suite.T(), err)
+	two := build(second, "two", map[string]string{"two": "2"})
+
+	baseLayer := one.manifest.Layers[0]
+	require.Equal(suite.T(), baseLayer, two.manifest.Layers[0])
+	sharedInfo, err := os.Stat(filepath.Join(root, "repo", blobPath(baseLayer.Digest)))
+	require.NoError(suite.T(), err)
+	for _, name := range []string{"first", "second"} {
+		info, err := os.Stat(filepath.Join(root, "repo", name, blobPath(baseLayer.Digest)))
+		require.NoError(suite.T(), err)
+		assert.True(suite.T(), os.SameFile(sharedInfo, info), name)
+	}
+
+	// Layouts opened later share blobs as well and their images are readable
+	names, err := repo.ListImageLayouts()
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"first", "second"}, names)
+	second, err = repo.OpenImageLayout("second")
+	require.NoError(suite.T(), err)
+	img, err := second.OpenImage("two")
+	require.NoError(suite.T(), err)
+	mergedFs, err := img.Fs()
+	require.NoError(suite.T(), err)
+	data, err := afero.ReadFile(mergedFs, "/two")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "2", string(data))
+
+	result, err := repo.GC()
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), result.Removed)
+
+	// The base layer is still used by the second layout
+	first, err = repo.OpenImageLayout("first")
+	require.NoError(suite.T(), err)
+	manifest, err := first.Resolve("one")
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), first.RemoveManifest("one"))
+	require.NoError(suite.T(), first.Close())
+
+	result, err = repo.GC()
+	require.NoError(suite.T(), err)
+	assert.ElementsMatch(suite.T(), []digest.Digest{manifest.Digest, one.manifest.Config.Digest, one.manifest.Layers[1].Digest}, result.Removed)
+	assert.Positive(suite.T(), result.Freed)
+	assert.False(suite.T(), first.HasBlob(baseLayer.Digest))
+	assert.True(suite.T(), second.HasBlob(baseLayer.Digest))
+	_, err = os.Stat(filepath.Join(root, "repo", blobPath(baseLayer.Digest)))
+	assert.NoError(suite.T(), err)
+	_, err = os.Stat(filepath.Join(root, "repo", blobPath(one.manifest.Layers[1].Digest)))
+	assert.True(suite.T(), os.IsNotExist(err))
+
+	// The mode is explicit, reopening the repository does not share blobs on its own
+	repo, err = OpenRepositoryFS(afero.NewOsFs(), filepath.Join(root, "repo"))
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), repo.SharedBlobs())
+	require.NoError(suite.T(), repo.EnableSharedBlobs())
+	assert.True(suite.T(), repo.SharedBlobs())
+}
+
+func (suite *OCITestSuite) TestSharedBlobsUnsupported() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	assert.ErrorIs(suite.T(), repo.EnableSharedBlobs(), ErrSharedBlobsUnsupported)
+	assert.False(suite.T(), repo.SharedBlobs())
+	exists, err := afero.DirExists(suite.fs, filepath.Join("test", sharedBlobsDirectory))
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), exists)
+}
+
+func (suite *OCITestSuite) TestGCWithBlobStore() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	store := NewMemoryBlobStore()
+	repo.SetBlobStore(store)
+
+	base := suite.writeTree(map[string]string{"bin/sh": "sh"})
+	build := func(name string) *Image {
+		layout, err := repo.CreateImageLayout(name)
+		require.NoError(suite.T(), err)
+		img := layout.CreateImage("latest")
+		require.NoError(suite.T(), img.AddTree(base, specsv1.History{CreatedBy: "base"}))
+		require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{name: name}), specsv1.History{CreatedBy: name}))
+		require.NoError(suite.T(), layout.SaveImage(img))
+		require.NoError(suite.T(), layout.Close())
+		return img
+	}
+	first := build("first")
+	second := build("second")
+	orphan, err := writeBlob(store, "application/octet-stream", []byte("orphan"))
+	require.NoError(suite.T(), err)
+
+	// Blobs referenced by any layout are kept
+	result, err := repo.GC()
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []digest.Digest{orphan.Digest}, result.Removed)
+	assert.Equal(suite.T(), orphan.Size, result.Freed)
+
+	layout, err := repo.OpenImageLayout("first")
+	require.NoError(suite.T(), err)
+	manifest, err := layout.Resolve("latest")
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), layout.RemoveManifest("latest"))
+	require.NoError(suite.T(), layout.Close())
+
+	result, err = repo.GC()
+	require.NoError(suite.T(), err)
+	assert.ElementsMatch(suite.T(), []digest.Digest{manifest.Digest, first.manifest.Config.Digest, first.manifest.Layers[1].Digest}, result.Removed)
+	_, err = store.Stat(second.manifest.Layers[0].Digest)
+	assert.NoError(suite.T(), err)
+	_, err = store.Stat(first.manifest.Layers[1].Digest)
+	assert.True(suite.T(), os.IsNotExist(err))
+}
+
+func (suite *OCITestSuite) TestGCWithoutSharedBlobs() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(suite.T(), img.AddTree(suite.writeTree(map[string]string{"a": "a"}), specsv1.History{CreatedBy: "a"}))
+	require.NoError(suite.T(), layout.SaveImage(img))
+	orphan, err := writeBlob(layout.blobs, "application/octet-stream", []byte("orphan"))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), layout.Close())
+
+	result, err := repo.GC()
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []digest.Digest{orphan.Digest}, result.Removed)
+	assert.Equal(suite.T(), orphan.Size, result.Freed)
+	assert.False(suite.T(), layout.HasBlob(orphan.Digest))
+	assert.True(suite.T(), layout.HasBlob(img.manifest.Layers[0].Digest))
+	assert.True(suite.T(), layout.HasBlob(img.manifest.Config.Digest))
+}
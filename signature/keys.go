@@ -0,0 +1,151 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var (
+	ErrUnsupportedKey   = errors.New("unsupported key")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Signer signs payloads with a private key
+type Signer interface {
+	Sign(payload []byte) ([]byte, error)
+	PublicKey() crypto.PublicKey
+}
+
+type keySigner struct {
+	key crypto.Signer
+}
+
+// NewSigner wraps an ed25519, ECDSA P-256 or RSA private key.
+// ECDSA and RSA sign the SHA-256 digest of the payload, ed25519 the payload itself.
+func NewSigner(key crypto.PrivateKey) (Signer, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return &keySigner{key: k}, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ECDSA keys must use P-256", ErrUnsupportedKey)
+		}
+		return &keySigner{key: k}, nil
+	case *rsa.PrivateKey:
+		return &keySigner{key: k}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+func (s *keySigner) Sign(payload []byte) ([]byte, error) {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(payload)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func (s *keySigner) PublicKey() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Verify checks the signature of payload with an ed25519, ECDSA or RSA public key
+func Verify(key crypto.PublicKey, payload, sig []byte) error {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+
+	return nil
+}
+
+// LoadSigner reads a PEM encoded private key from path
+func LoadSigner(path string) (Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSigner(data)
+}
+
+// ParseSigner parses a PEM encoded PKCS#8, SEC 1 or PKCS#1 private key
+func ParseSigner(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", ErrUnsupportedKey)
+	}
+
+	var (
+		key crypto.PrivateKey
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM block of type %s", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(key)
+}
+
+// LoadPublicKey reads a PEM encoded public key from path
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePublicKey(data)
+}
+
+// ParsePublicKey parses a PEM encoded PKIX or PKCS#1 public key
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data found", ErrUnsupportedKey)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM block of type %s", ErrUnsupportedKey, block.Type)
+	}
+}
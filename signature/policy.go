@@ -0,0 +1,190 @@
+package signature
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+// Requirement types of a trust policy
+const (
+	RequirementReject                 = "reject"
+	RequirementInsecureAcceptAnything = "insecureAcceptAnything"
+	RequirementSignedBy               = "signedBy"
+)
+
+var (
+	ErrRejected   = errors.New("rejected by trust policy")
+	ErrNotTrusted = errors.New("no trusted signature")
+)
+
+// Requirement decides if an image is trusted
+type Requirement struct {
+	Type string `json:"type"`
+	// KeyPaths are PEM encoded public keys of which one has to have signed the image for signedBy.
+	// Relative paths are resolved relative to the policy file.
+	KeyPaths []string `json:"keyPaths,omitempty"`
+	// IgnoreIdentity accepts signatures made for any repository as long as the manifest digest matches
+	IgnoreIdentity bool `json:"ignoreIdentity,omitempty"`
+
+	keys []crypto.PublicKey
+}
+
+// Rule applies a requirement to all references matching the pattern.
+// In patterns * matches any sequence of characters, including slashes.
+type Rule struct {
+	Pattern     string      `json:"pattern"`
+	Requirement Requirement `json:"requirement"`
+}
+
+// Policy maps image references to the requirements they have to fulfil. The rule with the longest matching pattern
+// applies, if no rule matches the default requirement does. A policy without default rejects unmatched references.
+type Policy struct {
+	Default *Requirement `json:"default,omitempty"`
+	Rules   []Rule       `json:"rules,omitempty"`
+}
+
+// LoadPolicy reads a JSON encoded policy and the public keys it refers to
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("policy %s cannot be parsed: %w", path, err)
+	}
+
+	requirements := make([]*Requirement, 0, len(policy.Rules)+1)
+	if policy.Default != nil {
+		requirements = append(requirements, policy.Default)
+	}
+	for i := range policy.Rules {
+		requirements = append(requirements, &policy.Rules[i].Requirement)
+	}
+
+	for _, req := range requirements {
+		for _, keyPath := range req.KeyPaths {
+			if !filepath.IsAbs(keyPath) {
+				keyPath = filepath.Join(filepath.Dir(path), keyPath)
+			}
+			key, err := LoadPublicKey(keyPath)
+			if err != nil {
+				return nil, fmt.Errorf("key %s of policy %s cannot be loaded: %w", keyPath, path, err)
+			}
+			req.keys = append(req.keys, key)
+		}
+	}
+
+	return policy, nil
+}
+
+// SignedBy creates a requirement for a signature made with one of the keys
+func SignedBy(keys ...crypto.PublicKey) Requirement {
+	return Requirement{Type: RequirementSignedBy, keys: keys}
+}
+
+// AddRule adds a rule for the references matching pattern
+func (p *Policy) AddRule(pattern string, req Requirement) {
+	p.Rules = append(p.Rules, Rule{Pattern: pattern, Requirement: req})
+}
+
+// RequirementFor returns the requirement which applies to the reference
+func (p *Policy) RequirementFor(reference string) Requirement {
+	var (
+		best    *Rule
+		bestLen = -1
+	)
+	for i, rule := range p.Rules {
+		if matchPattern(rule.Pattern, reference) && len(rule.Pattern) > bestLen {
+			best = &p.Rules[i]
+			bestLen = len(rule.Pattern)
+		}
+	}
+
+	if best != nil {
+		return best.Requirement
+	}
+
+	if p.Default != nil {
+		return *p.Default
+	}
+
+	return Requirement{Type: RequirementReject}
+}
+
+// Verify evaluates the policy for the manifest in the layout which is published as reference.
+// Call it before ExtractInto to make sure only trusted content ends up on disk.
+// For signedBy requirements the signatures of the manifest are returned which satisfied it.
+func (p *Policy) Verify(layout *oci.ImageLayout, manifest specsv1.Descriptor, reference string) ([]Signature, error) {
+	req := p.RequirementFor(reference)
+
+	switch req.Type {
+	case RequirementInsecureAcceptAnything:
+		return nil, nil
+	case RequirementSignedBy:
+		return req.verifySignedBy(layout, manifest, reference)
+	case RequirementReject:
+		return nil, fmt.Errorf("%w: %s", ErrRejected, reference)
+	default:
+		return nil, fmt.Errorf("%w: unknown requirement type %q for %s", ErrRejected, req.Type, reference)
+	}
+}
+
+func (req Requirement) verifySignedBy(layout *oci.ImageLayout, manifest specsv1.Descriptor, reference string) ([]Signature, error) {
+	if len(req.keys) == 0 {
+		return nil, fmt.Errorf("%w: signedBy requirement for %s has no keys", ErrRejected, reference)
+	}
+
+	signatures, err := Signatures(layout, manifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := reference
+	if req.IgnoreIdentity {
+		identity = ""
+	}
+
+	trusted := make([]Signature, 0)
+	var lastErr error
+	for _, sig := range signatures {
+		for _, key := range req.keys {
+			if _, err := sig.Verify(key, manifest.Digest, identity); err != nil {
+				lastErr = err
+				continue
+			}
+			trusted = append(trusted, sig)
+			break
+		}
+	}
+
+	if len(trusted) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("%w for %s: %v", ErrNotTrusted, reference, lastErr)
+		}
+		return nil, fmt.Errorf("%w for %s: image is not signed", ErrNotTrusted, reference)
+	}
+
+	return trusted, nil
+}
+
+// matchPattern matches reference against a pattern in which * stands for any sequence of characters
+func matchPattern(pattern, reference string) bool {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+
+	matched, err := regexp.MatchString("^"+strings.Join(parts, ".*")+"$", reference)
+	return err == nil && matched
+}
@@ -0,0 +1,202 @@
+// Package signature signs manifests in an oci.ImageLayout and verifies them against a trust policy.
+// Signatures are stored as artifacts referring to the signed manifest. Their payload follows the simple signing
+// format of cosign https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+package signature
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/toasterson/oci"
+)
+
+const (
+	ArtifactTypeSignature   = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	MediaTypeSimpleSigning  = "application/vnd.dev.cosign.simplesigning.v1+json"
+	AnnotationSignature     = "dev.cosignproject.cosign/signature"
+	simpleSigningType       = "cosign container image signature"
+	maxSimpleSigningPayload = 64 * 1024
+)
+
+// Payload is the simple signing document which gets signed
+type Payload struct {
+	Critical Critical               `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+type Critical struct {
+	Identity Identity `json:"identity"`
+	Image    Image    `json:"image"`
+	Type     string   `json:"type"`
+}
+
+type Identity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+type Image struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// Signature is a signature artifact found in a layout
+type Signature struct {
+	// Descriptor of the signature artifact
+	Descriptor specsv1.Descriptor
+	// Payload as it was signed
+	Payload []byte
+	// Signature of the payload
+	Signature []byte
+}
+
+// Sign creates a signature artifact for the manifest and adds it to the index of the layout.
+// The reference is the repository the image is published as, like registry.example.com/app. A tag or digest in the
+// reference is dropped as the manifest digest identifies the image. Optional claims are signed as well.
+// The index of the layout is only persisted once the layout is closed.
+func Sign(layout *oci.ImageLayout, manifest specsv1.Descriptor, reference string, signer Signer, optional map[string]interface{}) (specsv1.Descriptor, error) {
+	payload, err := json.Marshal(Payload{
+		Critical: Critical{
+			Identity: Identity{DockerReference: repositoryOf(reference)},
+			Image:    Image{DockerManifestDigest: manifest.Digest.String()},
+			Type:     simpleSigningType,
+		},
+		Optional: optional,
+	})
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	sig, err := signer.Sign(payload)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	artifact := layout.CreateArtifact(ArtifactTypeSignature, &manifest)
+	if _, err := artifact.AddBlob(MediaTypeSimpleSigning, strings.NewReader(string(payload)), map[string]string{
+		AnnotationSignature: base64.StdEncoding.EncodeToString(sig),
+	}); err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	return layout.SaveArtifact(artifact)
+}
+
+// Signatures returns all signature artifacts in the layout which refer to the manifest
+func Signatures(layout *oci.ImageLayout, manifest digest.Digest) ([]Signature, error) {
+	referrers, err := layout.Referrers(manifest, ArtifactTypeSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := make([]Signature, 0, len(referrers))
+	for _, referrer := range referrers {
+		var artifact specsv1.Manifest
+		if err := decodeBlob(layout, referrer.Digest, &artifact); err != nil {
+			return nil, err
+		}
+
+		for _, layer := range artifact.Layers {
+			if layer.MediaType != MediaTypeSimpleSigning {
+				continue
+			}
+
+			sig, err := base64.StdEncoding.DecodeString(layer.Annotations[AnnotationSignature])
+			if err != nil {
+				return nil, fmt.Errorf("signature in artifact %s is not base64 encoded: %w", referrer.Digest, err)
+			}
+
+			payload, err := readPayload(layout, layer)
+			if err != nil {
+				return nil, err
+			}
+
+			signatures = append(signatures, Signature{
+				Descriptor: referrer,
+				Payload:    payload,
+				Signature:  sig,
+			})
+		}
+	}
+
+	return signatures, nil
+}
+
+// Verify checks the signature with the key and makes sure the signed payload is about the manifest.
+// If reference is not empty the payload must also name the same repository.
+func (s Signature) Verify(key crypto.PublicKey, manifest digest.Digest, reference string) (*Payload, error) {
+	if err := Verify(key, s.Payload, s.Signature); err != nil {
+		return nil, err
+	}
+
+	payload := &Payload{}
+	if err := json.Unmarshal(s.Payload, payload); err != nil {
+		return nil, fmt.Errorf("%w: payload cannot be parsed: %v", ErrInvalidSignature, err)
+	}
+
+	if payload.Critical.Type != simpleSigningType {
+		return nil, fmt.Errorf("%w: unknown payload type %s", ErrInvalidSignature, payload.Critical.Type)
+	}
+
+	if payload.Critical.Image.DockerManifestDigest != manifest.String() {
+		return nil, fmt.Errorf("%w: signature is for manifest %s", ErrInvalidSignature, payload.Critical.Image.DockerManifestDigest)
+	}
+
+	if reference != "" && payload.Critical.Identity.DockerReference != repositoryOf(reference) {
+		return nil, fmt.Errorf("%w: signature is for %s", ErrInvalidSignature, payload.Critical.Identity.DockerReference)
+	}
+
+	return payload, nil
+}
+
+func readPayload(layout *oci.ImageLayout, layer specsv1.Descriptor) ([]byte, error) {
+	if layer.Size > maxSimpleSigningPayload {
+		return nil, fmt.Errorf("signature payload %s exceeds %d bytes", layer.Digest, maxSimpleSigningPayload)
+	}
+
+	rd, err := layout.OpenBlob(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	// The size in the manifest is not trusted, the blob may be larger
+	data, err := ioutil.ReadAll(io.LimitReader(rd, maxSimpleSigningPayload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSimpleSigningPayload {
+		return nil, fmt.Errorf("signature payload %s exceeds %d bytes", layer.Digest, maxSimpleSigningPayload)
+	}
+
+	return data, nil
+}
+
+func decodeBlob(layout *oci.ImageLayout, d digest.Digest, v interface{}) error {
+	rd, err := layout.OpenBlob(d)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	return rd.Decode(v)
+}
+
+// repositoryOf strips tag and digest from an image reference
+func repositoryOf(reference string) string {
+	if idx := strings.Index(reference, "@"); idx >= 0 {
+		reference = reference[:idx]
+	}
+
+	// A colon after the last slash separates the tag, one before it belongs to the registry port
+	if idx := strings.LastIndex(reference, ":"); idx > strings.LastIndex(reference, "/") {
+		reference = reference[:idx]
+	}
+
+	return reference
+}
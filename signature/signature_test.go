@@ -0,0 +1,201 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toasterson/oci"
+)
+
+func testLayout(t *testing.T) (*oci.ImageLayout, specsv1.Descriptor) {
+	repo, err := oci.CreateRepositoryFS(afero.NewMemMapFs(), "/repo")
+	require.NoError(t, err)
+	layout, err := repo.CreateImageLayout("signed")
+	require.NoError(t, err)
+
+	img := layout.CreateImage("latest")
+	require.NoError(t, layout.SaveImage(img))
+	manifest, err := layout.Resolve("latest")
+	require.NoError(t, err)
+
+	return layout, manifest
+}
+
+// writeKeyPair stores the key pair PEM encoded in dir and returns the paths of private and public key
+func writeKeyPair(t *testing.T, dir, name string, key crypto.Signer, pkcs8 bool) (string, string) {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if !pkcs8 {
+			der, err := x509.MarshalECPrivateKey(k)
+			require.NoError(t, err)
+			block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		}
+	case *rsa.PrivateKey:
+		if !pkcs8 {
+			block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		}
+	}
+	if block == nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	privPath := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(privPath, pem.EncodeToMemory(block), 0600))
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	pubPath := filepath.Join(dir, name+".pub")
+	require.NoError(t, ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	return privPath, pubPath
+}
+
+func TestSignAndVerifyKeyTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-signature")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		key   crypto.Signer
+		pkcs8 bool
+	}{
+		"ed25519":     {edKey, true},
+		"ecdsa-sec1":  {ecKey, false},
+		"ecdsa-pkcs8": {ecKey, true},
+		"rsa-pkcs1":   {rsaKey, false},
+		"rsa-pkcs8":   {rsaKey, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			layout, manifest := testLayout(t)
+			privPath, pubPath := writeKeyPair(t, dir, name, tc.key, tc.pkcs8)
+
+			signer, err := LoadSigner(privPath)
+			require.NoError(t, err)
+			pub, err := LoadPublicKey(pubPath)
+			require.NoError(t, err)
+
+			_, err = Sign(layout, manifest, "registry.example.com/app:1.0", signer, map[string]interface{}{"builder": "test"})
+			require.NoError(t, err)
+
+			signatures, err := Signatures(layout, manifest.Digest)
+			require.NoError(t, err)
+			require.Len(t, signatures, 1)
+
+			payload, err := signatures[0].Verify(pub, manifest.Digest, "registry.example.com/app:2.0")
+			require.NoError(t, err)
+			assert.Equal(t, "registry.example.com/app", payload.Critical.Identity.DockerReference)
+			assert.Equal(t, "test", payload.Optional["builder"])
+
+			_, err = signatures[0].Verify(pub, manifest.Digest, "registry.example.com/other")
+			assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+			tampered := signatures[0]
+			tampered.Payload = append([]byte(" "), tampered.Payload...)
+			_, err = tampered.Verify(pub, manifest.Digest, "")
+			assert.True(t, errors.Is(err, ErrInvalidSignature))
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, trustedKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, _ = writeKeyPair(t, dir, "trusted", trustedKey, true)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "policy.json"), []byte(`{
+	"default": {"type": "reject"},
+	"rules": [
+		{"pattern": "registry.example.com/*", "requirement": {"type": "signedBy", "keyPaths": ["trusted.pub"]}},
+		{"pattern": "registry.example.com/public/*", "requirement": {"type": "insecureAcceptAnything"}}
+	]
+}`), 0644))
+
+	policy, err := LoadPolicy(filepath.Join(dir, "policy.json"))
+	require.NoError(t, err)
+
+	layout, manifest := testLayout(t)
+
+	_, err = policy.Verify(layout, manifest, "registry.example.com/app:1.0")
+	assert.True(t, errors.Is(err, ErrNotTrusted))
+
+	_, err = policy.Verify(layout, manifest, "registry.example.com/public/app:1.0")
+	assert.NoError(t, err)
+
+	_, err = policy.Verify(layout, manifest, "docker.io/library/app:1.0")
+	assert.True(t, errors.Is(err, ErrRejected))
+
+	otherSigner, err := NewSigner(otherKey)
+	require.NoError(t, err)
+	_, err = Sign(layout, manifest, "registry.example.com/app", otherSigner, nil)
+	require.NoError(t, err)
+
+	_, err = policy.Verify(layout, manifest, "registry.example.com/app:1.0")
+	assert.True(t, errors.Is(err, ErrNotTrusted))
+
+	trustedSigner, err := NewSigner(trustedKey)
+	require.NoError(t, err)
+	_, err = Sign(layout, manifest, "registry.example.com/app", trustedSigner, nil)
+	require.NoError(t, err)
+
+	trusted, err := policy.Verify(layout, manifest, "registry.example.com/app:1.0")
+	require.NoError(t, err)
+	assert.Len(t, trusted, 1)
+
+	inMemory := &Policy{}
+	inMemory.AddRule("*", SignedBy(trustedKey.Public()))
+	_, err = inMemory.Verify(layout, manifest, "registry.example.com/app")
+	assert.NoError(t, err)
+}
+
+func TestRepositoryOf(t *testing.T) {
+	assert.Equal(t, "registry.example.com:5000/app", repositoryOf("registry.example.com:5000/app:1.0"))
+	assert.Equal(t, "registry.example.com:5000/app", repositoryOf("registry.example.com:5000/app"))
+	assert.Equal(t, "app", repositoryOf("app@sha256:abcd"))
+}
+
+func TestReadPayloadLimit(t *testing.T) {
+	layout, _ := testLayout(t)
+	wr, err := layout.NewBlobWriter(MediaTypeSimpleSigning, digest.Canonical)
+	require.NoError(t, err)
+	_, err = wr.Write(make([]byte, maxSimpleSigningPayload+1))
+	require.NoError(t, err)
+	layer, err := wr.Close()
+	require.NoError(t, err)
+
+	// The size of the descriptor is not trusted
+	layer.Size = 10
+	_, err = readPayload(layout, layer)
+	assert.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package oci
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Squash merges the layers from..to-1 into a single layer. Whiteouts between the squashed layers are applied and
+// files deleted by them are dropped, only whiteouts affecting the layers below from are kept. The history entries
+// of the squashed layers are marked as empty layers and a new entry for the squashed layer follows them.
+// Save the image to write the new manifest, the blobs of the squashed layers stay in the layout.
+func (img *Image) Squash(from, to int) error {
+	if from < 0 || to > len(img.manifest.Layers) || from >= to {
+		return fmt.Errorf("cannot squash layers %d to %d of image with %d layers", from, to, len(img.manifest.Layers))
+	}
+
+	historyIndices := img.layerHistoryIndices()
+
+	visible, whiteouts, layers, err := img.mergedIndexEntries(from, to)
+	if err != nil {
+		return err
+	}
+
+	layer, err := img.NewLayerWriter(digest.Canonical)
+	if err != nil {
+		return err
+	}
+
+	isDir := func(loc entryLocation) bool {
+		return layers[loc.layer-from][loc.entry].hdr.Typeflag == tar.TypeDir
+	}
+
+	// Nothing is below the first layer thus its whiteouts have no effect
+	if from > 0 {
+		names := make([]string, 0, len(whiteouts))
+		for name := range whiteouts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := squashWhiteout(layer, name, visible, isDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := from; i < to; i++ {
+		entry := 0
+		err := img.walkLayer(i, func(index int, hdr *tar.Header, r io.Reader) error {
+			loc := entryLocation{layer: index, entry: entry}
+			entry++
+
+			name := CleanEntryName(hdr.Name)
+			if found, ok := visible[name]; !ok || found != loc {
+				return nil
+			}
+			return layer.CopyEntry(hdr, r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	descr, err := layer.Close()
+	if err != nil {
+		return err
+	}
+
+	img.manifest.Layers = replaceRange(img.manifest.Layers, from, to, descr)
+	img.Config.RootFS.DiffIDs = replaceDigestRange(img.Config.RootFS.DiffIDs, from, to, layer.DiffID())
+
+	// The squashed layer takes the place of the history entry of the last layer it contains. It gets none if
+	// the history ends before the squashed layers.
+	squashed := make(map[int]bool)
+	last := -1
+	for _, h := range historyIndices[from:to] {
+		if h >= 0 {
+			squashed[h] = true
+			last = h
+		}
+	}
+
+	history := make([]specsv1.History, 0, len(img.Config.History)+1)
+	for i, h := range img.Config.History {
+		if squashed[i] {
+			h.EmptyLayer = true
+		}
+		history = append(history, h)
+
+		if i == last {
+			now := time.Now().UTC()
+			history = append(history, specsv1.History{
+				Created:   &now,
+				CreatedBy: fmt.Sprintf("squash layers %d to %d", from, to-1),
+				Comment:   fmt.Sprintf("squashed %d layers", to-from),
+			})
+		}
+	}
+	img.Config.History = history
+
+	return nil
+}
+
+// squashWhiteout writes the whiteout name into the squashed layer if it still affects the layers below.
+// Whiteouts of paths which are added again as directory hide the lower contents with an opaque marker instead.
+func squashWhiteout(layer *LayerWriter, name string, visible map[string]entryLocation, isDir func(entryLocation) bool) error {
+	target, opaque, _ := WhiteoutTarget(name)
+
+	// A file replacing a parent directory hides the path anyway
+	for dir := path.Dir(target); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if loc, ok := visible[dir]; ok && !isDir(loc) {
+			return nil
+		}
+	}
+
+	loc, ok := visible[target]
+	switch {
+	case opaque && (ok || target == ""):
+		return layer.OpaqueDir(target)
+	case opaque:
+		// The directory was deleted later, its whiteout is written on its own
+		return nil
+	case !ok:
+		return layer.Whiteout(target)
+	case isDir(loc):
+		return layer.OpaqueDir(target)
+	}
+
+	// A file replaces the path which hides the lower one without whiteout
+	return nil
+}
+
+// layerHistoryIndices returns the index of the history entry of every layer. History is optional thus layers
+// without entry, as in images with missing or short history, get -1.
+func (img *Image) layerHistoryIndices() []int {
+	indices := make([]int, len(img.manifest.Layers))
+	layer := 0
+	for i, h := range img.Config.History {
+		if layer == len(indices) {
+			break
+		}
+		if !h.EmptyLayer {
+			indices[layer] = i
+			layer++
+		}
+	}
+	for ; layer < len(indices); layer++ {
+		indices[layer] = -1
+	}
+
+	return indices
+}
+
+// checkLayerHistory returns an error unless every layer has exactly one history entry
+func (img *Image) checkLayerHistory() error {
+	layers := 0
+	for _, h := range img.Config.History {
+		if !h.EmptyLayer {
+			layers++
+		}
+	}
+
+	if layers != len(img.manifest.Layers) {
+		return fmt.Errorf("history of image has %d entries for %d layers", layers, len(img.manifest.Layers))
+	}
+
+	return nil
+}
+
+func replaceRange(layers []specsv1.Descriptor, from, to int, descr specsv1.Descriptor) []specsv1.Descriptor {
+	replaced := make([]specsv1.Descriptor, 0, len(layers)-(to-from)+1)
+	replaced = append(replaced, layers[:from]...)
+	replaced = append(replaced, descr)
+	return append(replaced, layers[to:]...)
+}
+
+func replaceDigestRange(digests []digest.Digest, from, to int, d digest.Digest) []digest.Digest {
+	replaced := make([]digest.Digest, 0, len(digests)-(to-from)+1)
+	replaced = append(replaced, digests[:from]...)
+	replaced = append(replaced, d)
+	return append(replaced, digests[to:]...)
+}
@@ -0,0 +1,76 @@
+package oci
+
+import (
+	"archive/tar"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *OCITestSuite) TestSquash() {
+	repo, err := CreateRepositoryFS(suite.fs, "test")
+	require.NoError(suite.T(), err)
+	layout, err := repo.CreateImageLayout("testing")
+	require.NoError(suite.T(), err)
+
+	lower := suite.writeTree(map[string]string{"a": "a", "d/x": "x", "gone": "gone", "keep": "keep"})
+	upper := suite.writeTree(map[string]string{"a": "a", "d": "file", "keep": "keep"})
+	top := suite.writeTree(map[string]string{"d/z": "z", "new": "new"})
+
+	create := func(name string) *Image {
+		img := layout.CreateImage(name)
+		require.NoError(suite.T(), img.AddTree(lower, specsv1.History{CreatedBy: "lower"}))
+		require.NoError(suite.T(), img.AddDiff(afero.NewOsFs(), afero.NewOsFs(), lower, upper, specsv1.History{CreatedBy: "upper"}))
+		require.NoError(suite.T(), img.AddTree(top, specsv1.History{CreatedBy: "top"}))
+		return img
+	}
+
+	orig := create("orig")
+	img := create("latest")
+	assert.Error(suite.T(), img.Squash(1, 4))
+	assert.Error(suite.T(), img.Squash(2, 2))
+
+	// Layers built twice from the same tree may differ in access times thus the image is compared to itself
+	first := img.manifest.Layers[0]
+	require.NoError(suite.T(), img.Squash(1, 3))
+	require.Len(suite.T(), img.manifest.Layers, 2)
+	require.Len(suite.T(), img.Config.RootFS.DiffIDs, 2)
+	assert.Equal(suite.T(), first, img.manifest.Layers[0])
+
+	require.Len(suite.T(), img.Config.History, 4)
+	assert.False(suite.T(), img.Config.History[0].EmptyLayer)
+	assert.True(suite.T(), img.Config.History[1].EmptyLayer)
+	assert.True(suite.T(), img.Config.History[2].EmptyLayer)
+	assert.False(suite.T(), img.Config.History[3].EmptyLayer)
+	assert.Equal(suite.T(), "squash layers 1 to 2", img.Config.History[3].CreatedBy)
+
+	// The deletion of gone and the replacement of d still apply to the base layer
+	assert.Equal(suite.T(), map[string]byte{
+		".wh.gone":               tar.TypeReg,
+		"d/" + WhiteoutOpaqueDir: tar.TypeReg,
+		"d":                      tar.TypeDir,
+		"d/z":                    tar.TypeReg,
+		"new":                    tar.TypeReg,
+	}, suite.layerEntries(img))
+
+	report, err := DiffImages(orig, img)
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), report.Changes)
+
+	// Whiteouts of the first layer have nothing to hide
+	require.NoError(suite.T(), img.Squash(0, 2))
+	require.Len(suite.T(), img.manifest.Layers, 1)
+	entries := suite.layerEntries(img)
+	assert.NotContains(suite.T(), entries, ".wh.gone")
+	assert.NotContains(suite.T(), entries, "d/"+WhiteoutOpaqueDir)
+	assert.NotContains(suite.T(), entries, "gone")
+	assert.NotContains(suite.T(), entries, "d/x")
+
+	report, err = DiffImages(orig, img)
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), report.Changes)
+	require.Len(suite.T(), img.Config.History, 5)
+	assert.Equal(suite.T(), "squash layers 0 to 1", img.Config.History[4].CreatedBy)
+}
@@ -3,11 +3,12 @@ package oci
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"strings"
+	"path"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -25,23 +26,37 @@ const (
 )
 
 type TarReader struct {
-	backingFile   afero.File
+	backingFile   io.Closer
 	backingReader io.Reader
 	archiveReader *tar.Reader
+	progress      *progressTracker
+	logger        Logger
 }
 
 func NewTarReader(compressor ArchiveCompressor, fs afero.Fs, fName string) (tarReader *TarReader, err error) {
-	tarReader = &TarReader{}
 	f, err := fs.Open(fName)
 	if err != nil {
 		return nil, err
 	}
+
+	tarReader, err = newTarReader(compressor, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return tarReader, nil
+}
+
+func newTarReader(compressor ArchiveCompressor, f io.ReadCloser) (tarReader *TarReader, err error) {
+	tarReader = &TarReader{progress: newProgressTracker(ProgressExtractArchive), logger: nopLogger{}}
 	tarReader.backingFile = f
+	// Progress counts the bytes of the archive as stored thus the size of a blob is the total
+	counted := &progressReader{r: f, tracker: tarReader.progress}
 	switch compressor {
 	case ArchiveCompressorNone:
-		tarReader.backingReader = f
+		tarReader.backingReader = counted
 	case ArchiveCompressorGzip:
-		tarReader.backingReader, err = gzip.NewReader(f)
+		tarReader.backingReader, err = gzip.NewReader(counted)
 		if err != nil {
 			return nil, err
 		}
@@ -50,9 +65,36 @@ func NewTarReader(compressor ArchiveCompressor, fs afero.Fs, fName string) (tarR
 	return tarReader, nil
 }
 
+// SetProgress reports the extracted entries and the bytes read of the archive to reporter
+func (tarReader *TarReader) SetProgress(reporter ProgressReporter) {
+	tarReader.progress.setReporter(reporter)
+}
+
+// SetLogger makes the reader log the entries it extracts to logger
+func (tarReader *TarReader) SetLogger(logger Logger) {
+	tarReader.logger = orNop(logger)
+}
+
 func (tarReader *TarReader) ExtractTreeInto(targetFs afero.Fs) error {
+	return tarReader.ExtractTreeIntoContext(context.Background(), targetFs)
+}
+
+// ExtractTreeIntoContext extracts the archive into targetFs and stops with the error of ctx once it is done.
+// Cancellation is checked between entries and while copying their content, entries extracted before are kept.
+func (tarReader *TarReader) ExtractTreeIntoContext(ctx context.Context, targetFs afero.Fs) error {
+	if err := tarReader.extractTreeInto(ctx, targetFs); err != nil {
+		return contextErr(ctx, err)
+	}
+	return nil
+}
+
+func (tarReader *TarReader) extractTreeInto(ctx context.Context, targetFs afero.Fs) error {
+	content := &contextReader{ctx: ctx, r: tarReader.archiveReader}
 	symLinkList := make([]tar.Header, 0)
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		th, err := tarReader.archiveReader.Next()
 		if err == io.EOF {
 			break
@@ -60,39 +102,40 @@ func (tarReader *TarReader) ExtractTreeInto(targetFs afero.Fs) error {
 		if err != nil {
 			return err
 		}
-		if strings.Contains(th.Name, ".wh.") {
-			if err := targetFs.Remove(strings.Replace(th.Name, ".wh.", "", -1)); err != nil && !os.IsNotExist(err) {
+		tarReader.progress.entry(th.Name)
+		if target, opaque, ok := WhiteoutTarget(th.Name); ok {
+			tarReader.logger.Debugf("removing %s", target)
+			if err := removeWhiteoutTarget(targetFs, target, opaque); err != nil {
 				return err
 			}
 
-			if _, err = io.Copy(ioutil.Discard, tarReader.archiveReader); err != nil {
+			if _, err = io.Copy(ioutil.Discard, content); err != nil {
 				return err
 			}
 
 		} else {
-			//fileOrDirPath := filepath.Join(dir, th.Name)
 			switch th.Typeflag {
 			case tar.TypeDir:
-				//logrus.Tracef("Extracting Directory %s", fileOrDirPath)
+				tarReader.logger.Debugf("extracting directory %s", th.Name)
 				if err := unpackDir(th, targetFs); err != nil {
-					return err
+					return &Error{Op: "extract", Path: th.Name, Err: err}
 				}
 			case tar.TypeSymlink:
 				//Defer symlink creation to later to avoid file not exist errors
-				//logrus.Tracef("Saving Symlink %s for later extraction", fileOrDirPath)
+				tarReader.logger.Debugf("saving symlink %s for later extraction", th.Name)
 				symLinkList = append(symLinkList, *th)
 			case tar.TypeLink:
 				// Links must be created relative to dir in order to find a file that already exists
 				// Hardlinks are resolved at link time rather than symlinks which are resolved at runtime
-				//logrus.Tracef("Extracting Hardlink %s", fileOrDirPath)
+				tarReader.logger.Debugf("extracting hardlink %s", th.Name)
 				if targetLinker, ok := targetFs.(afero.Linker); ok {
 					if err := targetLinker.SymlinkIfPossible(th.Linkname, th.Name); err != nil {
 						return err
 					}
 				}
 			case tar.TypeReg:
-				//logrus.Tracef("Extracting File %s", fileOrDirPath)
-				if err := unpackFile(th, tarReader.archiveReader, targetFs); err != nil {
+				tarReader.logger.Debugf("extracting file %s", th.Name)
+				if err := unpackFile(th, content, targetFs); err != nil {
 					return err
 				}
 			case tar.TypeChar, tar.TypeBlock:
@@ -104,7 +147,7 @@ func (tarReader *TarReader) ExtractTreeInto(targetFs afero.Fs) error {
 	}
 
 	for _, th := range symLinkList {
-		//logrus.Tracef("Extracting Symlink %s", path)
+		tarReader.logger.Debugf("extracting symlink %s", th.Name)
 		if targetLinker, ok := targetFs.(afero.Linker); ok {
 			if err := targetLinker.SymlinkIfPossible(th.Linkname, th.Name); err != nil {
 				if os.IsExist(err) {
@@ -128,6 +171,7 @@ func (tarReader *TarReader) ExtractTreeInto(targetFs afero.Fs) error {
 			}
 		}
 	}
+	tarReader.progress.done()
 	return nil
 }
 
@@ -146,6 +190,31 @@ func (tarReader *TarReader) Close() error {
 	return nil
 }
 
+// removeWhiteoutTarget deletes the path hidden by a whiteout, opaque markers only delete the contents of the directory
+func removeWhiteoutTarget(targetFs afero.Fs, target string, opaque bool) error {
+	if !opaque {
+		return targetFs.RemoveAll(target)
+	}
+
+	dir := target
+	if dir == "" {
+		dir = "/"
+	}
+	entries, err := afero.ReadDir(targetFs, dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := targetFs.RemoveAll(path.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func unpackDir(th *tar.Header, targetFs afero.Fs) error {
 	info := th.FileInfo()
 	if err := targetFs.Mkdir(th.Name, info.Mode()); err != nil && !os.IsExist(err) {
@@ -168,10 +237,7 @@ func unpackFile(th *tar.Header, tr io.Reader, targetFs afero.Fs) error {
 	}
 	defer f.Close()
 	if written, err := io.Copy(f, tr); err != nil {
-		if strings.Contains(err.Error(), "EOF") {
-			fmt.Printf("file: %s; length expected: %s; length written %s\n", th.Name, humanize.Bytes(uint64(th.Size)), humanize.Bytes(uint64(written)))
-		}
-		return err
+		return &Error{Op: "extract", Path: th.Name, Err: fmt.Errorf("%s of %s extracted: %w", humanize.Bytes(uint64(written)), humanize.Bytes(uint64(th.Size)), err)}
 	}
 	if err := targetFs.Chmod(th.Name, th.FileInfo().Mode()); err != nil {
 		return err
@@ -12,8 +12,7 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/sirupsen/logrus"
-	"github.com/ztrue/tracerr"
+	"github.com/spf13/afero"
 )
 
 var whiteoutByte = []byte("WHITEOUT")
@@ -33,10 +32,12 @@ type TarWriter struct {
 	archiveWriter *tar.Writer
 	seen          map[Devino]string
 	symLinks      []*tar.Header
+	progress      *progressTracker
+	logger        Logger
 }
 
 func NewTarWriter(compressor ArchiveCompressor, writer io.Writer) (tarWriter *TarWriter, err error) {
-	tarWriter = &TarWriter{seen: make(map[Devino]string)}
+	tarWriter = &TarWriter{seen: make(map[Devino]string), progress: newProgressTracker(ProgressWriteArchive), logger: nopLogger{}}
 	switch compressor {
 	case ArchiveCompressorGzip:
 		tarWriter.gzipWriter = gzip.NewWriter(writer)
@@ -48,34 +49,50 @@ func NewTarWriter(compressor ArchiveCompressor, writer io.Writer) (tarWriter *Ta
 	return tarWriter, nil
 }
 
+// SetProgress reports every entry and the bytes of their content to reporter
+func (tarWriter *TarWriter) SetProgress(reporter ProgressReporter) {
+	tarWriter.progress.setReporter(reporter)
+}
+
+// SetLogger makes the writer log the entries it adds to logger
+func (tarWriter *TarWriter) SetLogger(logger Logger) {
+	tarWriter.logger = orNop(logger)
+}
+
 func (tarWriter *TarWriter) Close() error {
 
 	//Write symlinks last to avoid file does not exist errors
 	for _, hdr := range tarWriter.symLinks {
 		if err := tarWriter.archiveWriter.WriteHeader(hdr); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 	}
 
 	if err := tarWriter.archiveWriter.Flush(); err != nil {
-		return tracerr.Wrap(err)
+		return err
 	}
 	if err := tarWriter.archiveWriter.Close(); err != nil {
-		return tracerr.Wrap(err)
+		return err
 	}
 	if tarWriter.gzipWriter != nil {
 		if err := tarWriter.gzipWriter.Flush(); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 		if err := tarWriter.gzipWriter.Close(); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 	}
+	tarWriter.progress.done()
 	return nil
 }
 
 // Add a new File into the Layer Archive
 func (tarWriter *TarWriter) AddEntry(realPath string, inImagePath string, info os.FileInfo, whiteout bool) (err error) {
+	return tarWriter.AddEntryFs(afero.NewOsFs(), realPath, inImagePath, info, whiteout)
+}
+
+// AddEntryFs adds the file realPath of fs into the Layer Archive. Symlinks can only be added if fs is an afero.LinkReader.
+func (tarWriter *TarWriter) AddEntryFs(fs afero.Fs, realPath string, inImagePath string, info os.FileInfo, whiteout bool) (err error) {
 	if strings.HasPrefix(inImagePath, "/") {
 		inImagePath = inImagePath[1:]
 	}
@@ -95,7 +112,7 @@ func (tarWriter *TarWriter) AddEntry(realPath string, inImagePath string, info o
 	case os.ModeDir:
 		hdr, err = tar.FileInfoHeader(info, "")
 		if err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 		//Fixup Fullpath
 		hdr.Name = inImagePath
@@ -103,62 +120,64 @@ func (tarWriter *TarWriter) AddEntry(realPath string, inImagePath string, info o
 		// Force GNU Format to properly support UTF-8
 		hdr.Format = tar.FormatGNU
 		if err = tarWriter.archiveWriter.WriteHeader(hdr); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
+		tarWriter.progress.entry(inImagePath)
 	case os.ModeDevice, os.ModeCharDevice:
 		hdr, err = tar.FileInfoHeader(info, inImagePath)
 		if err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 		hdr.Name = inImagePath
 
 		// Force GNU Format to properly support UTF-8
 		hdr.Format = tar.FormatGNU
 		if err = tarWriter.archiveWriter.WriteHeader(hdr); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
+		tarWriter.progress.entry(inImagePath)
 	case os.ModeSymlink:
 		//We have a Symlink thus Create it on the Target
-		dstTarget, err := os.Readlink(realPath)
+		dstTarget, err := readlinkFs(fs, realPath)
 		if err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 		hdr, err = tar.FileInfoHeader(info, dstTarget)
 		if err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 		hdr.Name = inImagePath
 
 		// Force GNU Format to properly support UTF-8
 		hdr.Format = tar.FormatGNU
 		tarWriter.symLinks = append(tarWriter.symLinks, hdr)
+		tarWriter.progress.entry(inImagePath)
 	default:
 		if whiteout {
 			if err = tarWriter.WhiteoutFile(inImagePath); err != nil {
-				return tracerr.Wrap(err)
+				return err
 			}
 
 			return nil
 		}
 
-		fileObj, err := os.Open(realPath)
+		fileObj, err := fs.Open(realPath)
 		if err != nil {
-			fmt.Println(info.Mode() & os.ModeType)
-			return tracerr.Wrap(err)
+			return &Error{Op: "add", Path: realPath, Err: err}
 		}
 		defer fileObj.Close()
-		if _, err := fileObj.Read(make([]byte, 1, 1)); err != nil {
+		if _, err := fileObj.Read(make([]byte, 1, 1)); err != nil && err != io.EOF {
 			//Workaround for non regular files and funky filesystems
 			return nil
 		}
 
 		if _, err = fileObj.Seek(io.SeekStart, 0); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 
 		hdr, err = tar.FileInfoHeader(info, "")
 		if err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 
 		// This adds support for hardlinks
@@ -186,14 +205,13 @@ func (tarWriter *TarWriter) AddEntry(realPath string, inImagePath string, info o
 		// Force GNU Format to properly support UTF-8
 		hdr.Format = tar.FormatGNU
 		if err = tarWriter.archiveWriter.WriteHeader(hdr); err != nil {
-			return tracerr.Wrap(err)
+			return err
 		}
 
+		tarWriter.progress.entry(inImagePath)
 		if hdr.Typeflag == tar.TypeReg {
-			if _, err := io.Copy(tarWriter.archiveWriter, fileObj); err != nil {
-				logrus.Errorf("file: %s (%s); length: %s\n", realPath, inImagePath, humanize.Bytes(uint64(info.Size())))
-				logrus.Errorf("header: length: %s\n", humanize.Bytes(uint64(hdr.Size)))
-				return tracerr.Wrap(err)
+			if written, err := io.Copy(&progressWriter{w: tarWriter.archiveWriter, tracker: tarWriter.progress}, fileObj); err != nil {
+				return &Error{Op: "add", Path: realPath, Err: fmt.Errorf("%s of %s added as %s: %w", humanize.Bytes(uint64(written)), humanize.Bytes(uint64(hdr.Size)), inImagePath, err)}
 			}
 		}
 	}
@@ -202,14 +220,14 @@ func (tarWriter *TarWriter) AddEntry(realPath string, inImagePath string, info o
 }
 
 func (tarWriter *TarWriter) AddTree(path, targetBasePath string) error {
-	logrus.Debugf("packing directory %s as %s", path, targetBasePath)
+	tarWriter.logger.Debugf("packing directory %s as %s", path, targetBasePath)
 	if err := filepath.Walk(path, func(fPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
 		tPath := strings.ReplaceAll(fPath, path, "")
-		logrus.Debugf("Adding %s -> %s", fPath, filepath.Join(targetBasePath, tPath))
+		tarWriter.logger.Debugf("adding %s as %s", fPath, filepath.Join(targetBasePath, tPath))
 		err = tarWriter.AddEntry(fPath, tPath, info, false)
 		if err != nil {
 			return err
@@ -217,12 +235,36 @@ func (tarWriter *TarWriter) AddTree(path, targetBasePath string) error {
 
 		return nil
 	}); err != nil {
-		return tracerr.Wrap(err)
+		return err
 	}
 
 	return nil
 }
 
+// CopyEntry adds an entry read from another archive with its content from r
+func (tarWriter *TarWriter) CopyEntry(hdr *tar.Header, r io.Reader) error {
+	if err := tarWriter.archiveWriter.WriteHeader(hdr); err != nil {
+		return err
+	}
+	tarWriter.progress.entry(hdr.Name)
+
+	if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+		if _, err := io.CopyN(&progressWriter{w: tarWriter.archiveWriter, tracker: tarWriter.progress}, r, hdr.Size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readlinkFs(fs afero.Fs, name string) (string, error) {
+	linkReader, ok := fs.(afero.LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+	}
+	return linkReader.ReadlinkIfPossible(name)
+}
+
 func (tarWriter *TarWriter) WhiteoutFile(name string) error {
 	whName := filepath.Join(filepath.Dir(name), ".wh."+filepath.Base(name))
 	hdr := tar.Header{
@@ -237,10 +279,11 @@ func (tarWriter *TarWriter) WhiteoutFile(name string) error {
 		ChangeTime: time.Now(),
 	}
 	if err := tarWriter.archiveWriter.WriteHeader(&hdr); err != nil {
-		return tracerr.Wrap(err)
+		return err
 	}
 	if _, err := tarWriter.archiveWriter.Write(whiteoutByte); err != nil {
-		return tracerr.Wrap(err)
+		return err
 	}
+	tarWriter.progress.entry(whName)
 	return nil
 }